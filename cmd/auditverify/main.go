@@ -0,0 +1,63 @@
+// Command auditverify checks the hash chain of audit logs written by
+// loggo.AuditWriter.
+//
+// Usage:
+//
+//	auditverify [-key hex | -key-file path] file...
+//
+// It exits with status 1 if any file fails verification.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lngwu11/toolgo/loggo"
+)
+
+func main() {
+	keyHex := flag.String("key", "", "hex encoded HMAC key the log was written with")
+	keyFile := flag.String("key-file", "", "file holding the raw HMAC key")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-key hex | -key-file path] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var key []byte
+	var err error
+	switch {
+	case *keyHex != "" && *keyFile != "":
+		_, _ = fmt.Fprintln(os.Stderr, "-key and -key-file are mutually exclusive")
+		os.Exit(2)
+	case *keyHex != "":
+		key, err = hex.DecodeString(strings.TrimSpace(*keyHex))
+	case *keyFile != "":
+		key, err = os.ReadFile(*keyFile)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot load key: %v\n", err)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		result, err := loggo.VerifyAuditFile(path, key)
+		if err != nil {
+			failed = true
+			_, _ = fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("%s: ok, %d records, last hash %s\n", path, result.Records, result.LastHash)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/lngwu11/toolgo
 
-go 1.20
+go 1.21
 
 require (
 	github.com/fsnotify/fsnotify v1.6.0
@@ -0,0 +1,457 @@
+package loggo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditSyncPolicy selects when an AuditWriter flushes the file to disk.
+type AuditSyncPolicy int
+
+const (
+	// AuditSyncEveryEntry syncs the file after every entry.
+	AuditSyncEveryEntry AuditSyncPolicy = iota
+	// AuditSyncInterval syncs the file periodically when it has changed.
+	AuditSyncInterval
+	// AuditSyncNever leaves flushing to the operating system, the file is
+	// only synced when the writer is closed. The head file is still updated
+	// after every entry, so a crash does not leave the log without one.
+	AuditSyncNever
+)
+
+const (
+	defaultAuditSyncInterval = time.Second
+	auditHeadSuffix          = ".head"
+	// auditHashKey starts the last member of every audit record. As it is
+	// last, the hash covers all the bytes of the record before it.
+	auditHashKey = `,"hash":"`
+)
+
+// auditGenesisHash is the previous hash of the first record in a file.
+var auditGenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// AuditWriterConfig configures an AuditWriter.
+type AuditWriterConfig struct {
+	// Path is the audit log file. An existing file is verified and appended
+	// to.
+	Path string
+	// Perm is the permission of a new file. Default 0600.
+	Perm os.FileMode
+	// Key, if set, makes the chain an HMAC-SHA256 chain, so records cannot be
+	// forged without the key. Otherwise plain SHA-256 is used.
+	Key []byte
+	// SyncPolicy selects when the file is synced to disk.
+	SyncPolicy AuditSyncPolicy
+	// SyncInterval is the period of AuditSyncInterval. Default 1s.
+	SyncInterval time.Duration
+}
+
+// auditRecord is a single line of an audit log. Hash must stay the last
+// member, it is computed over the encoding of the members before it.
+type auditRecord struct {
+	Seq       uint64                 `json:"seq"`
+	Timestamp time.Time              `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Module    string                 `json:"module"`
+	Filename  string                 `json:"filename"`
+	Line      int                    `json:"line"`
+	Message   string                 `json:"message"`
+	Labels    []string               `json:"labels,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Prev      string                 `json:"prev"`
+	Hash      string                 `json:"hash"`
+}
+
+// auditHead records the last synced record, so that the verifier can tell
+// when records were cut off the end of the file.
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+// AuditWriter is an append-only Writer for audit trails. Every entry is
+// written as a JSON line holding a sequence number and a hash chained to the
+// previous entry, so that edits, reordering and truncation can be detected
+// with VerifyAuditFile. The sequence number and hash of the last synced entry
+// are kept in a "<path>.head" file next to the log.
+type AuditWriter struct {
+	config AuditWriterConfig
+
+	mutex    sync.Mutex
+	file     *os.File
+	seq      uint64
+	prevHash string
+	dirty    bool
+	err      error
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewAuditWriter opens the audit log at config.Path. If the file already
+// exists its chain is verified first and new entries continue it; an error
+// is returned if the existing file fails verification.
+func NewAuditWriter(config AuditWriterConfig) (*AuditWriter, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("audit log path cannot be empty")
+	}
+	if config.Perm == 0 {
+		config.Perm = 0600
+	}
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = defaultAuditSyncInterval
+	}
+
+	w := &AuditWriter{
+		config:   config,
+		prevHash: auditGenesisHash,
+	}
+	if _, err := os.Stat(config.Path); err == nil {
+		result, err := VerifyAuditFile(config.Path, config.Key)
+		if err != nil {
+			return nil, err
+		}
+		w.seq = result.Records
+		if result.Records > 0 {
+			w.prevHash = result.LastHash
+		}
+	}
+	file, err := os.OpenFile(config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, config.Perm)
+	if err != nil {
+		return nil, err
+	}
+	w.file = file
+	// Write the head of a new log right away, a log without a head cannot be
+	// verified with a key.
+	if _, err = os.Stat(config.Path + auditHeadSuffix); os.IsNotExist(err) {
+		w.dirty = true
+		if err = w.sync(); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+
+	if config.SyncPolicy == AuditSyncInterval {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// Write appends the entry to the audit log. As Write cannot return an error,
+// failures are kept and reported by Err; once a write has failed no further
+// entries are written, so the chain is never broken by a partial record.
+func (w *AuditWriter) Write(entry Entry) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err != nil || w.file == nil {
+		return
+	}
+
+	record := auditRecord{
+		Seq:       w.seq + 1,
+		Timestamp: entry.Timestamp,
+		Level:     entry.Level.String(),
+		Module:    entry.Module,
+		Filename:  entry.Filename,
+		Line:      entry.Line,
+		Message:   entry.Message,
+		Labels:    entry.Labels,
+		Prev:      w.prevHash,
+	}
+	if len(entry.Fields) > 0 {
+		record.Fields = make(map[string]interface{}, len(entry.Fields))
+		for _, field := range entry.Fields {
+			record.Fields[field.Key] = fmt.Sprint(jsonFieldValue(field.Value))
+		}
+	}
+	line, sum, err := encodeAuditRecord(record, w.config.Key)
+	if err != nil {
+		w.err = err
+		return
+	}
+	if _, err = w.file.Write(line); err != nil {
+		w.err = err
+		return
+	}
+	w.seq = record.Seq
+	w.prevHash = sum
+	w.dirty = true
+
+	switch w.config.SyncPolicy {
+	case AuditSyncEveryEntry:
+		w.err = w.sync()
+	case AuditSyncNever:
+		w.err = w.writeHead()
+	}
+}
+
+// Err returns the first error met while writing or syncing the log.
+func (w *AuditWriter) Err() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.err
+}
+
+// Sync flushes the log to disk and updates the head file.
+func (w *AuditWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return fmt.Errorf("audit log already closed")
+	}
+	return w.sync()
+}
+
+// Close syncs and closes the audit log.
+func (w *AuditWriter) Close() error {
+	if w.stop != nil {
+		w.stopOnce.Do(func() {
+			close(w.stop)
+		})
+		<-w.done
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.sync()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	return err
+}
+
+func (w *AuditWriter) syncLoop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mutex.Lock()
+			if w.file != nil && w.err == nil {
+				w.err = w.sync()
+			}
+			w.mutex.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// sync must be called with the mutex held.
+func (w *AuditWriter) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	if err := w.writeHead(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// writeHead replaces the head file with the last written record, it must be
+// called with the mutex held.
+func (w *AuditWriter) writeHead() error {
+	head := auditHead{Seq: w.seq, Hash: w.prevHash}
+	if len(w.config.Key) > 0 {
+		head.MAC = auditHeadMAC(head, w.config.Key)
+	}
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	// Replace the head atomically, a torn head would fail verification.
+	name := w.config.Path + auditHeadSuffix
+	tmp := name + ".tmp"
+	if err = os.WriteFile(tmp, data, w.config.Perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func newAuditHash(key []byte) hash.Hash {
+	if len(key) > 0 {
+		return hmac.New(sha256.New, key)
+	}
+	return sha256.New()
+}
+
+// encodeAuditRecord returns the record line and its hash. The hash covers
+// the encoded record up to, and excluding, the hash member.
+func encodeAuditRecord(record auditRecord, key []byte) ([]byte, string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, "", err
+	}
+	i := bytes.LastIndex(data, []byte(auditHashKey))
+	if i < 0 {
+		return nil, "", fmt.Errorf("audit record has no hash member")
+	}
+	h := newAuditHash(key)
+	h.Write(data[:i])
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	line := make([]byte, 0, i+len(auditHashKey)+len(sum)+3)
+	line = append(line, data[:i]...)
+	line = append(line, auditHashKey...)
+	line = append(line, sum...)
+	line = append(line, "\"}\n"...)
+	return line, sum, nil
+}
+
+func auditHeadMAC(head auditHead, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = fmt.Fprintf(mac, "%d:%s", head.Seq, head.Hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuditError describes where an audit log failed verification.
+type AuditError struct {
+	// Line is the 1-based line number of the offending record, zero when the
+	// problem is not tied to a line.
+	Line int
+	// Reason describes the problem.
+	Reason string
+}
+
+// Error implements error.
+func (e *AuditError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("audit log verification failed: %s", e.Reason)
+	}
+	return fmt.Sprintf("audit log verification failed at line %d: %s", e.Line, e.Reason)
+}
+
+// AuditVerifyResult describes a verified audit log.
+type AuditVerifyResult struct {
+	// Records is the number of records, which is also the last sequence
+	// number.
+	Records uint64
+	// LastHash is the hash of the last record.
+	LastHash string
+}
+
+// VerifyAuditLog reads an audit log produced by AuditWriter and checks its
+// chain: sequence numbers must start at 1 and increase by one, every record
+// must name the hash of the record before it, and the hash of every record
+// must match its content. The key must be the one the log was written with.
+// A log cut in the middle of a record is reported, but whole records cut off
+// the end can only be detected with the head file; see VerifyAuditFile.
+func VerifyAuditLog(r io.Reader, key []byte) (AuditVerifyResult, error) {
+	return verifyAuditLog(r, key, nil)
+}
+
+// verifyAuditLog verifies the log, calling visit with the sequence number and
+// hash of every valid record.
+func verifyAuditLog(r io.Reader, key []byte, visit func(seq uint64, hash string)) (AuditVerifyResult, error) {
+	result := AuditVerifyResult{LastHash: auditGenesisHash}
+	reader := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return result, &AuditError{Line: lineNo, Reason: "truncated record"}
+			}
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		line = line[:len(line)-1]
+
+		var record auditRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return result, &AuditError{Line: lineNo, Reason: fmt.Sprintf("malformed record: %v", err)}
+		}
+		if record.Seq != result.Records+1 {
+			return result, &AuditError{Line: lineNo, Reason: fmt.Sprintf("sequence %d, expected %d", record.Seq, result.Records+1)}
+		}
+		if record.Prev != result.LastHash {
+			return result, &AuditError{Line: lineNo, Reason: "previous hash does not match the chain"}
+		}
+		i := bytes.LastIndex(line, []byte(auditHashKey))
+		if i < 0 {
+			return result, &AuditError{Line: lineNo, Reason: "record has no hash"}
+		}
+		h := newAuditHash(key)
+		h.Write(line[:i])
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != record.Hash {
+			return result, &AuditError{Line: lineNo, Reason: "record hash does not match its content"}
+		}
+		result.Records = record.Seq
+		result.LastHash = record.Hash
+		if visit != nil {
+			visit(record.Seq, record.Hash)
+		}
+	}
+}
+
+// VerifyAuditFile verifies the audit log at path with VerifyAuditLog and
+// checks that the log still holds the record in the head file written by
+// AuditWriter, which detects records cut off the end. With a key, the head
+// itself is authenticated and a missing head is an error, as removing it
+// would hide truncation. Without a key the head is optional.
+func VerifyAuditFile(path string, key []byte) (AuditVerifyResult, error) {
+	var head *auditHead
+	data, err := os.ReadFile(path + auditHeadSuffix)
+	if err == nil {
+		head = new(auditHead)
+		if err = json.Unmarshal(data, head); err != nil {
+			return AuditVerifyResult{}, &AuditError{Reason: fmt.Sprintf("malformed head file: %v", err)}
+		}
+		if len(key) > 0 && !hmac.Equal([]byte(head.MAC), []byte(auditHeadMAC(*head, key))) {
+			return AuditVerifyResult{}, &AuditError{Reason: "head file authentication failed"}
+		}
+	} else if !os.IsNotExist(err) {
+		return AuditVerifyResult{}, err
+	} else if len(key) > 0 {
+		return AuditVerifyResult{}, &AuditError{Reason: "head file missing"}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return AuditVerifyResult{}, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var headHash string
+	result, err := verifyAuditLog(file, key, func(seq uint64, hash string) {
+		if head != nil && seq == head.Seq {
+			headHash = hash
+		}
+	})
+	if err != nil || head == nil || head.Seq == 0 {
+		return result, err
+	}
+	if head.Seq > result.Records {
+		return result, &AuditError{Reason: fmt.Sprintf("log truncated: %d records, head expects %d", result.Records, head.Seq)}
+	}
+	if headHash != head.Hash {
+		return result, &AuditError{Reason: fmt.Sprintf("record %d does not match the head file", head.Seq)}
+	}
+	return result, nil
+}
@@ -0,0 +1,98 @@
+package loggo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeAuditLog(t *testing.T, key []byte, messages ...string) string {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writer, err := NewAuditWriter(AuditWriterConfig{Path: path, Key: key})
+	require.NoError(t, err)
+	for _, msg := range messages {
+		writer.Write(Entry{Level: INFO, Module: "audit", Timestamp: time.Now(), Message: msg})
+	}
+	require.NoError(t, writer.Close())
+	return path
+}
+
+func TestAuditWriterVerify(t *testing.T) {
+	key := []byte("secret")
+	path := writeAuditLog(t, key, "one", "two", "three")
+
+	result, err := VerifyAuditFile(path, key)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), result.Records)
+
+	_, err = VerifyAuditFile(path, []byte("wrong"))
+	require.Error(t, err)
+
+	// Reopening continues the chain.
+	writer, err := NewAuditWriter(AuditWriterConfig{Path: path, Key: key})
+	require.NoError(t, err)
+	writer.Write(Entry{Level: INFO, Message: "four"})
+	require.NoError(t, writer.Close())
+	result, err = VerifyAuditFile(path, key)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Records)
+}
+
+func TestAuditWriterDetectsTampering(t *testing.T) {
+	key := []byte("secret")
+	path := writeAuditLog(t, key, "one", "two", "three")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	tests := map[string][]byte{
+		"edited":    bytes.Replace(data, []byte(`"two"`), []byte(`"2wo"`), 1),
+		"reordered": bytes.Join([][]byte{lines[1], lines[0], lines[2]}, nil),
+		"truncated": bytes.Join(lines[:2], nil),
+		"cut":       data[:len(data)-10],
+		"removed":   bytes.Join([][]byte{lines[0], lines[2]}, nil),
+	}
+	for name, content := range tests {
+		require.NoError(t, os.WriteFile(path, content, 0600))
+		_, err = VerifyAuditFile(path, key)
+		require.Error(t, err, name)
+		require.IsType(t, &AuditError{}, err, name)
+	}
+
+	// Removing the head must not hide truncation.
+	require.NoError(t, os.WriteFile(path, bytes.Join(lines[:2], nil), 0600))
+	require.NoError(t, os.Remove(path+auditHeadSuffix))
+	_, err = VerifyAuditFile(path, key)
+	require.IsType(t, &AuditError{}, err)
+	_, err = NewAuditWriter(AuditWriterConfig{Path: path, Key: key})
+	require.Error(t, err)
+}
+
+func TestAuditWriterSyncNeverHead(t *testing.T) {
+	key := []byte("secret")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writer, err := NewAuditWriter(AuditWriterConfig{Path: path, Key: key, SyncPolicy: AuditSyncNever})
+	require.NoError(t, err)
+	// An empty log already has a head.
+	_, err = VerifyAuditFile(path, key)
+	require.NoError(t, err)
+
+	writer.Write(Entry{Level: INFO, Message: "one"})
+	writer.Write(Entry{Level: INFO, Message: "two"})
+	require.NoError(t, writer.Err())
+
+	// Without Close, as after a crash, the head covers the written entries.
+	result, err := VerifyAuditFile(path, key)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), result.Records)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data[:bytes.IndexByte(data, '\n')+1], 0600))
+	_, err = VerifyAuditFile(path, key)
+	require.Error(t, err)
+	require.NoError(t, writer.Close())
+}
@@ -83,29 +83,109 @@ func parseConfigValue(value string) (string, Level, error) {
 // This is equivalent to specifying the level of the root module,
 // so "DEBUG" is equivalent to `<root>=DEBUG`
 //
+// Label changes, written as <modulename>+=#<label> or <modulename>-=#<label>,
+// cannot be expressed as a Config and are reported as an error; use
+// ParseLabelChanges or ConfigureLoggers for specifications that contain them.
+//
 // An example specification:
 //	`<root>=ERROR; foo.bar=WARNING`
 //	`[LABEL]=ERROR`
 func ParseConfigString(specification string) (Config, error) {
+	config, changes, err := parseSpecification(specification)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		return nil, fmt.Errorf("label change %q cannot be expressed as a config, use ConfigureLoggers", changes[0])
+	}
+	return config, nil
+}
+
+// LabelChange adds a label to, or removes a label from, a logger module.
+type LabelChange struct {
+	// Module is the logger module name, "<root>" or "" for the root module.
+	Module string
+	// Label is the label, without the leading '#'.
+	Label string
+	// Remove is true if the label is removed rather than added.
+	Remove bool
+}
+
+// String returns the change in the configuration string syntax.
+func (c LabelChange) String() string {
+	name := c.Module
+	if name == "" {
+		name = rootString
+	}
+	op := "+="
+	if c.Remove {
+		op = "-="
+	}
+	return fmt.Sprintf("%s%s#%s", name, op, c.Label)
+}
+
+// ParseLabelChanges parses the label changes, written as
+// <modulename>+=#<label> or <modulename>-=#<label>, out of a logger
+// configuration string. Level settings in the string are validated but not
+// returned; see ParseConfigString.
+func ParseLabelChanges(specification string) ([]LabelChange, error) {
+	_, changes, err := parseSpecification(specification)
+	return changes, err
+}
+
+func parseSpecification(specification string) (Config, []LabelChange, error) {
 	specification = strings.TrimSpace(specification)
 	if specification == "" {
-		return nil, nil
+		return nil, nil, nil
 	}
 	cfg := make(Config)
 	if level, ok := ParseLevel(specification); ok {
 		cfg[""] = level
-		return cfg, nil
+		return cfg, nil, nil
 	}
 
+	var changes []LabelChange
 	values := strings.FieldsFunc(specification, func(r rune) bool { return r == ';' || r == ':' })
 	for _, value := range values {
+		if strings.Contains(value, "+=") || strings.Contains(value, "-=") {
+			change, err := parseLabelChange(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			changes = append(changes, change)
+			continue
+		}
 		name, level, err := parseConfigValue(value)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		cfg[name] = level
 	}
-	return cfg, nil
+	return cfg, changes, nil
+}
+
+func parseLabelChange(value string) (LabelChange, error) {
+	remove := false
+	i := strings.Index(value, "+=")
+	if i < 0 {
+		i = strings.Index(value, "-=")
+		remove = true
+	}
+	name := strings.TrimSpace(value[:i])
+	if name == "" {
+		return LabelChange{}, fmt.Errorf("label change %q has missing module name", value)
+	}
+	if name == rootString {
+		name = ""
+	}
+	label := extractConfigLabel(value[i+2:])
+	if label == "" {
+		return LabelChange{}, fmt.Errorf("label change expected '#label', found %q", value)
+	}
+	if strings.Contains(label, ".") {
+		return LabelChange{}, fmt.Errorf("config label should not contain '.', found %q", value)
+	}
+	return LabelChange{Module: name, Label: label, Remove: remove}, nil
 }
 
 func extractConfigLabel(s string) string {
@@ -5,6 +5,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // Context produces loggers for a hierarchy of modules. The context holds
@@ -21,8 +22,15 @@ type Context struct {
 	writersMutex sync.Mutex
 	writers      map[string]Writer
 
+	// redactors sanitise entries before they are passed to the writers.
+	redactMutex sync.RWMutex
+	redactors   []*redactSelector
+
 	// writeMuxtex is used to serialise write operations.
 	writeMutex sync.Mutex
+
+	// inheritLabels makes modules carry the labels of their ancestors.
+	inheritLabels atomic.Bool
 }
 
 // NewContext returns a new Context with no writers set.
@@ -66,8 +74,8 @@ func (c *Context) GetAllLoggerLabels() []string {
 
 	names := make(map[string]struct{})
 	for _, module := range c.modules {
-		for k, v := range module.labelsLookup {
-			names[k] = v
+		for _, label := range module.ownLabels() {
+			names[label] = struct{}{}
 		}
 	}
 	labels := make([]string, 0, len(names))
@@ -97,45 +105,90 @@ func (c *Context) getLoggerModule(name string, labels []string) *module {
 
 	// Ensure that we create a new logger module for the name, that includes the
 	// label.
-	level := UNSPECIFIED
-	labelMap := make(map[string]struct{})
-	for _, label := range labels {
-		labelMap[label] = struct{}{}
-
-		// First label wins when setting the logger label from the config label
-		// level cache. If there are no label configs, then fallback to
-		// UNSPECIFIED and inherit the level correctly.
-		if configLevel, ok := c.modulesLabelConfig[label]; ok && level == UNSPECIFIED {
-			level = configLevel
-		}
-	}
 	impl = &module{
-		name:         name,
-		level:        level,
-		parent:       parent,
-		context:      c,
-		labels:       labels,
-		labelsLookup: labelMap,
+		name:    name,
+		parent:  parent,
+		context: c,
 	}
+	if labels != nil {
+		impl.labels.Store(newLabelSet(labels))
+	}
+	impl.level = c.labelConfigLevel(impl)
 	c.modules[name] = impl
 	return impl
 }
 
+// labelConfigLevel returns the level configured for the labels of the module.
+// First label wins when setting the logger label from the config label
+// level cache. If there are no label configs, then fallback to UNSPECIFIED
+// and inherit the level correctly.
+func (c *Context) labelConfigLevel(mod *module) Level {
+	for _, label := range mod.getLabels() {
+		if configLevel, ok := c.modulesLabelConfig[labelKey(label)]; ok {
+			return configLevel
+		}
+	}
+	return UNSPECIFIED
+}
+
 // getLoggerModulesByLabel returns modules that have the associated label.
 func (c *Context) getLoggerModulesByLabel(label string) []*module {
 	var modules []*module
 	for _, mod := range c.modules {
-		if mod.labels == nil {
-			continue
-		}
-
-		if _, ok := mod.labelsLookup[label]; ok {
+		if mod.hasLabel(label) {
 			modules = append(modules, mod)
 		}
 	}
 	return modules
 }
 
+// SetInheritLabels sets whether loggers inherit the labels of their
+// ancestors. When enabled, a child of a logger labelled "audit" is selected
+// by "#audit" configuration and its entries carry the "audit" label.
+func (c *Context) SetInheritLabels(inherit bool) {
+	c.inheritLabels.Store(inherit)
+}
+
+// AddLoggerLabels adds labels to the named logger, creating it if necessary.
+// If the logger has no level of its own, a level configured for one of the
+// labels is applied.
+func (c *Context) AddLoggerLabels(name string, labels ...string) {
+	c.ApplyLabelChanges(labelChanges(name, labels, false))
+}
+
+// RemoveLoggerLabels removes labels from the named logger.
+func (c *Context) RemoveLoggerLabels(name string, labels ...string) {
+	c.ApplyLabelChanges(labelChanges(name, labels, true))
+}
+
+func labelChanges(name string, labels []string, remove bool) []LabelChange {
+	changes := make([]LabelChange, 0, len(labels))
+	for _, label := range labels {
+		changes = append(changes, LabelChange{Module: name, Label: label, Remove: remove})
+	}
+	return changes
+}
+
+// ApplyLabelChanges adds and removes logger labels according to the changes.
+func (c *Context) ApplyLabelChanges(changes []LabelChange) {
+	c.modulesMutex.Lock()
+	defer c.modulesMutex.Unlock()
+	for _, change := range changes {
+		name := strings.TrimSpace(strings.ToLower(change.Module))
+		module := c.getLoggerModule(name, nil)
+		if change.Remove {
+			module.updateLabels(nil, []string{change.Label})
+			continue
+		}
+		module.updateLabels([]string{change.Label}, nil)
+		if module.level.get() == UNSPECIFIED {
+			if level := c.labelConfigLevel(module); level != UNSPECIFIED {
+				module.setLevel(level)
+			}
+		}
+	}
+}
+
 // Config returns the current configuration of the Loggers. Loggers
 // with UNSPECIFIED level will not be included.
 func (c *Context) Config() Config {
@@ -201,6 +254,7 @@ func (c *Context) ResetLoggerLevels() {
 }
 
 func (c *Context) write(entry Entry) {
+	entry = c.redact(entry)
 	c.writeMutex.Lock()
 	defer c.writeMutex.Unlock()
 	for _, writer := range c.getWriters() {
@@ -291,15 +345,18 @@ func (c *Context) ResetWriters() {
 // logging levels.  Loggers are colon- or semicolon-separated; each
 // module is specified as <modulename>=<level>.  White space outside of
 // module names and levels is ignored.  The root module is specified
-// with the name "<root>".
+// with the name "<root>". Labels are added to and removed from a module
+// with <modulename>+=#<label> and <modulename>-=#<label>; label changes
+// are applied before the levels.
 //
 // An example specification:
-//	`<root>=ERROR; foo.bar=WARNING`
+//	`<root>=ERROR; foo.bar=WARNING; foo.audit+=#audit; #audit=INFO`
 func (c *Context) ConfigureLoggers(specification string) error {
-	config, err := ParseConfigString(specification)
+	config, changes, err := parseSpecification(specification)
 	if err != nil {
 		return err
 	}
+	c.ApplyLabelChanges(changes)
 	c.ApplyConfig(config)
 	return nil
 }
@@ -18,4 +18,12 @@ type Entry struct {
 	Message string
 	// Labels is the label associated with the log message.
 	Labels []string
+	// Fields holds the structured key/value pairs attached to the message.
+	Fields []Field
+}
+
+// Field is a single structured key/value pair attached to an Entry.
+type Field struct {
+	Key   string
+	Value interface{}
 }
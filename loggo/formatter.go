@@ -1,8 +1,10 @@
 package loggo
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -11,11 +13,87 @@ var DefaultFormatterTimeZone = time.FixedZone("CST", 8*3600) // 东八区
 
 // DefaultFormatter returns the parameters separated by spaces except for
 // filename and line which are separated by a colon.  The timestamp is shown
-// to second resolution in UTC. For example:
+// to second resolution in UTC. Any fields are appended as key=value pairs.
+// For example:
 //   2016-07-02 15:04:05:000
 func DefaultFormatter(entry Entry) string {
 	ts := entry.Timestamp.In(DefaultFormatterTimeZone).Format("2006-01-02 15:04:05.000")
 	// Just get the basename from the filename
 	filename := filepath.Base(entry.Filename)
-	return fmt.Sprintf("%s %s %s %s:%d %s", ts, entry.Level, entry.Module, filename, entry.Line, entry.Message)
+	line := fmt.Sprintf("%s %s %s %s:%d %s", ts, entry.Level, entry.Module, filename, entry.Line, entry.Message)
+	if len(entry.Fields) == 0 {
+		return line
+	}
+	return line + " " + FormatFields(entry.Fields)
+}
+
+// FormatFields renders fields as space separated key=value pairs. Values
+// containing spaces or quotes are quoted.
+func FormatFields(fields []Field) string {
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		value := fmt.Sprint(field.Value)
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		b.WriteString(field.Key)
+		b.WriteByte('=')
+		b.WriteString(value)
+	}
+	return b.String()
+}
+
+type jsonEntry struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Module    string                 `json:"module"`
+	Filename  string                 `json:"filename"`
+	Line      int                    `json:"line"`
+	Message   string                 `json:"message"`
+	Labels    []string               `json:"labels,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// JSONFormatter returns the entry as a single line JSON object. Field values
+// that cannot be encoded as JSON are written using their string form.
+func JSONFormatter(entry Entry) string {
+	je := jsonEntry{
+		Timestamp: entry.Timestamp,
+		Level:     entry.Level.String(),
+		Module:    entry.Module,
+		Filename:  entry.Filename,
+		Line:      entry.Line,
+		Message:   entry.Message,
+		Labels:    entry.Labels,
+	}
+	if len(entry.Fields) > 0 {
+		je.Fields = make(map[string]interface{}, len(entry.Fields))
+		for _, field := range entry.Fields {
+			je.Fields[field.Key] = jsonFieldValue(field.Value)
+		}
+	}
+	data, err := json.Marshal(je)
+	if err != nil {
+		for key, value := range je.Fields {
+			je.Fields[key] = fmt.Sprint(value)
+		}
+		data, _ = json.Marshal(je)
+	}
+	return string(data)
+}
+
+func jsonFieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Time:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return value
+	}
 }
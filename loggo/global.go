@@ -37,6 +37,12 @@ func GetLoggerWithLabels(name string, labels ...string) Logger {
 	return defaultContext.GetLogger(name, labels...)
 }
 
+// SetInheritLabels sets whether loggers in the DefaultContext inherit the
+// labels of their ancestors.
+func SetInheritLabels(inherit bool) {
+	defaultContext.SetInheritLabels(inherit)
+}
+
 // ResetLogging iterates through the known modules and sets the levels of all
 // to UNSPECIFIED, except for <root> which is set to WARNING. The call also
 // removes all writers in the DefaultContext and puts the original default
@@ -71,6 +77,12 @@ func RemoveWriter(name string) (Writer, error) {
 	return defaultContext.RemoveWriter(name)
 }
 
+// AddRedactRules adds redaction rules to the DefaultContext for the module or
+// "#label" selector. See Context.AddRedactRules.
+func AddRedactRules(selector string, rules ...RedactRule) error {
+	return defaultContext.AddRedactRules(selector, rules...)
+}
+
 // ConfigureLoggers configures loggers on the default context according to the
 // given string specification, which specifies a set of modules and their
 // associated logging levels.  Loggers are colon- or semicolon-separated; each
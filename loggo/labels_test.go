@@ -0,0 +1,98 @@
+package loggo
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type entryCollector struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func (c *entryCollector) Write(entry Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, entry)
+}
+
+func (c *entryCollector) messages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var messages []string
+	for _, entry := range c.entries {
+		messages = append(messages, entry.Message)
+	}
+	return messages
+}
+
+func TestLabelConfigIgnoresCase(t *testing.T) {
+	context := NewContext(WARNING)
+	logger := context.GetLogger("app.audit", "Audit")
+	require.NoError(t, context.ConfigureLoggers("#audit=INFO"))
+	require.Equal(t, INFO, logger.LogLevel())
+	// Labels keep the case they were given.
+	require.Equal(t, []string{"Audit"}, logger.Labels())
+
+	// Loggers created after the configuration pick it up as well.
+	require.Equal(t, INFO, context.GetLogger("app.other", "AUDIT").LogLevel())
+}
+
+func TestLabelInheritance(t *testing.T) {
+	context := NewContext(WARNING)
+	parent := context.GetLogger("app", "audit")
+	child := parent.Child("db")
+	require.Empty(t, child.Labels())
+
+	context.SetInheritLabels(true)
+	require.Equal(t, []string{"audit"}, child.Labels())
+	require.NoError(t, context.ConfigureLoggers("#Audit=DEBUG"))
+	require.Equal(t, DEBUG, child.LogLevel())
+
+	// Duplicate labels differing only in case are merged.
+	labelled := parent.ChildWithLabels("cache", "AUDIT", "cache")
+	require.Equal(t, []string{"AUDIT", "cache"}, labelled.Labels())
+}
+
+func TestRuntimeLabelChanges(t *testing.T) {
+	context := NewContext(WARNING)
+	logger := context.GetLogger("app.db")
+	require.NoError(t, context.ConfigureLoggers("#audit=INFO"))
+	require.Equal(t, UNSPECIFIED, logger.LogLevel())
+
+	// Labels from configuration are lower case, like label configs.
+	require.NoError(t, context.ConfigureLoggers("app.db+=#Audit"))
+	require.Equal(t, []string{"audit"}, logger.Labels())
+	require.Equal(t, INFO, logger.LogLevel())
+	require.Equal(t, []string{"audit"}, context.GetAllLoggerLabels())
+
+	context.RemoveLoggerLabels("app.db", "AUDIT")
+	require.Empty(t, logger.Labels())
+	require.Empty(t, context.GetAllLoggerLabels())
+}
+
+func TestParseConfigStringRejectsLabelChanges(t *testing.T) {
+	_, err := ParseConfigString("app=INFO; app.db+=#audit")
+	require.Error(t, err)
+
+	changes, err := ParseLabelChanges("app=INFO; app.db+=#audit; <root>-=#debug")
+	require.NoError(t, err)
+	require.Equal(t, []LabelChange{
+		{Module: "app.db", Label: "audit"},
+		{Module: "", Label: "debug", Remove: true},
+	}, changes)
+}
+
+func TestLabelWriter(t *testing.T) {
+	context := NewContext(INFO)
+	collector := &entryCollector{}
+	require.NoError(t, context.AddWriter("audit", NewLabelWriter(collector, "#audit")))
+
+	context.GetLogger("app", "Audit").Infof("labelled")
+	context.GetLogger("app.db").Infof("unlabelled")
+	context.SetInheritLabels(true)
+	context.GetLogger("app.cache").Infof("inherited")
+	require.Equal(t, []string{"labelled", "inherited"}, collector.messages())
+}
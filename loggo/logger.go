@@ -75,9 +75,10 @@ func (logger Logger) LogLevel() Level {
 	return logger.getModule().level
 }
 
-// Labels returns the configured labels of the logger.
+// Labels returns the configured labels of the logger, including the
+// inherited labels when the context inherits labels.
 func (logger Logger) Labels() []string {
-	return logger.getModule().labels
+	return logger.getModule().getLabels()
 }
 
 // EffectiveLogLevel returns the effective min log level of
@@ -150,7 +151,7 @@ func (logger Logger) LogCallf(calldepth int, level Level, message string, args .
 		Line:      line,
 		Timestamp: now,
 		Message:   formattedMessage,
-		Labels:    module.labels,
+		Labels:    module.getLabels(),
 	})
 }
 
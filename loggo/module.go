@@ -1,5 +1,10 @@
 package loggo
 
+import (
+	"strings"
+	"sync/atomic"
+)
+
 // Do not change rootName: modules.resolve() will misbehave if it isn't "".
 const (
 	rootString = "<root>"
@@ -11,8 +16,107 @@ type module struct {
 	parent  *module
 	context *Context
 
-	labels       []string
-	labelsLookup map[string]struct{}
+	// labels holds the module's own labels. It is replaced as a whole when
+	// labels are added or removed, so it can be read without locking.
+	labels atomic.Pointer[labelSet]
+}
+
+// labelSet holds labels as they were given; lookups ignore case, matching
+// label configuration and label writers.
+type labelSet struct {
+	labels []string
+	lookup map[string]struct{}
+}
+
+// labelKey returns the case-insensitive key of the label.
+func labelKey(label string) string {
+	return strings.ToLower(strings.TrimSpace(label))
+}
+
+func newLabelSet(labels []string) *labelSet {
+	set := &labelSet{lookup: make(map[string]struct{})}
+	for _, label := range labels {
+		key := labelKey(label)
+		if _, found := set.lookup[key]; found || key == "" {
+			continue
+		}
+		set.lookup[key] = struct{}{}
+		set.labels = append(set.labels, strings.TrimSpace(label))
+	}
+	return set
+}
+
+func (s *labelSet) has(label string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.lookup[labelKey(label)]
+	return ok
+}
+
+// ownLabels returns the labels the module was given, excluding any inherited
+// labels.
+func (m *module) ownLabels() []string {
+	if set := m.labels.Load(); set != nil {
+		return set.labels
+	}
+	return nil
+}
+
+// getLabels returns the labels of the module. When the context inherits
+// labels, the labels of all the ancestors are included after the module's
+// own labels.
+func (m *module) getLabels() []string {
+	labels := m.ownLabels()
+	if !m.context.inheritLabels.Load() {
+		return labels
+	}
+	var merged []string
+	seen := make(map[string]struct{})
+	for mod := m; ; mod = mod.parent {
+		for _, label := range mod.ownLabels() {
+			if _, found := seen[labelKey(label)]; !found {
+				seen[labelKey(label)] = struct{}{}
+				merged = append(merged, label)
+			}
+		}
+		if mod.parent == mod {
+			break
+		}
+	}
+	return merged
+}
+
+// hasLabel reports whether the module has the label, taking inheritance into
+// account.
+func (m *module) hasLabel(label string) bool {
+	if !m.context.inheritLabels.Load() {
+		return m.labels.Load().has(label)
+	}
+	for mod := m; ; mod = mod.parent {
+		if mod.labels.Load().has(label) {
+			return true
+		}
+		if mod.parent == mod {
+			return false
+		}
+	}
+}
+
+// updateLabels adds and removes labels from the module's own labels.
+// The caller must hold the context modulesMutex.
+func (m *module) updateLabels(add, remove []string) {
+	drop := make(map[string]struct{}, len(remove))
+	for _, label := range remove {
+		drop[labelKey(label)] = struct{}{}
+	}
+	var labels []string
+	for _, label := range append(m.ownLabels(), add...) {
+		if _, found := drop[labelKey(label)]; !found {
+			labels = append(labels, label)
+		}
+	}
+	m.labels.Store(newLabelSet(labels))
 }
 
 // Name returns the module's name.
@@ -0,0 +1,230 @@
+package loggo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactMaskString replaces values redacted with RedactMask.
+const RedactMaskString = "******"
+
+// defaultRedactKeep is the number of characters RedactPartial leaves visible
+// when the rule does not specify one.
+const defaultRedactKeep = 4
+
+// RedactAction selects how a matched value is sanitised.
+type RedactAction int
+
+const (
+	// RedactMask replaces the whole value with RedactMaskString.
+	RedactMask RedactAction = iota
+	// RedactPartial masks all but the last Keep characters of the value.
+	RedactPartial
+	// RedactHash replaces the value with a truncated HMAC-SHA256 keyed with
+	// the rule's HashKey, so that equal values can still be correlated. The
+	// key keeps low-entropy values such as phone numbers from being recovered
+	// by hashing every candidate.
+	RedactHash
+)
+
+// RedactRule describes values to remove from log entries before they are
+// passed to the writers. At least one of Keys and Pattern must be set.
+type RedactRule struct {
+	// Keys are the field names, matched case-insensitively, whose values are
+	// redacted. Pairs such as key=value or "key":"value" inside the message
+	// are redacted as well.
+	Keys []string
+	// Pattern is matched against the message and string field values, and
+	// every match is redacted.
+	Pattern *regexp.Regexp
+	// Action is how the matched values are sanitised.
+	Action RedactAction
+	// Keep is the number of trailing characters left by RedactPartial.
+	// Zero means 4.
+	Keep int
+	// HashKey is the secret of RedactHash, required by that action.
+	HashKey []byte
+}
+
+type redactRule struct {
+	RedactRule
+	keys       map[string]struct{}
+	keyPattern *regexp.Regexp
+}
+
+type redactSelector struct {
+	selector string
+	rules    []*redactRule
+}
+
+func newRedactRule(rule RedactRule) (*redactRule, error) {
+	if len(rule.Keys) == 0 && rule.Pattern == nil {
+		return nil, fmt.Errorf("redact rule needs keys or a pattern")
+	}
+	if rule.Action < RedactMask || rule.Action > RedactHash {
+		return nil, fmt.Errorf("unknown redact action %d", rule.Action)
+	}
+	if rule.Action == RedactHash && len(rule.HashKey) == 0 {
+		return nil, fmt.Errorf("redact hash rule needs a hash key")
+	}
+	if rule.Keep <= 0 {
+		rule.Keep = defaultRedactKeep
+	}
+	r := &redactRule{RedactRule: rule}
+	if len(rule.Keys) > 0 {
+		r.keys = make(map[string]struct{}, len(rule.Keys))
+		quoted := make([]string, 0, len(rule.Keys))
+		for _, key := range rule.Keys {
+			key = strings.ToLower(strings.TrimSpace(key))
+			if key == "" {
+				return nil, fmt.Errorf("redact rule has an empty key")
+			}
+			r.keys[key] = struct{}{}
+			quoted = append(quoted, regexp.QuoteMeta(key))
+		}
+		// Matches key=value, key: value and "key":"value", capturing the value.
+		// A quoted value runs to the closing quote, so it may hold spaces.
+		r.keyPattern = regexp.MustCompile(`(?i)"?\b(?:` + strings.Join(quoted, "|") + `)\b"?\s*[:=]\s*` +
+			`(?:"(?:(?:bearer|basic)\s+)?((?:[^"\\]|\\.)*)"|(?:(?:bearer|basic)\s+)?([^\s"&,;]+))`)
+	}
+	return r, nil
+}
+
+func (r *redactRule) redactValue(value string) string {
+	switch r.Action {
+	case RedactPartial:
+		n := utf8.RuneCountInString(value)
+		if n <= r.Keep {
+			return RedactMaskString
+		}
+		runes := []rune(value)
+		return strings.Repeat("*", n-r.Keep) + string(runes[n-r.Keep:])
+	case RedactHash:
+		mac := hmac.New(sha256.New, r.HashKey)
+		_, _ = mac.Write([]byte(value))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+	default:
+		return RedactMaskString
+	}
+}
+
+func (r *redactRule) redactString(s string) string {
+	if r.keyPattern != nil {
+		s = replaceSubmatch(r.keyPattern, s, r.redactValue)
+	}
+	if r.Pattern != nil {
+		s = r.Pattern.ReplaceAllStringFunc(s, r.redactValue)
+	}
+	return s
+}
+
+func (r *redactRule) apply(entry *Entry) {
+	entry.Message = r.redactString(entry.Message)
+	for i, field := range entry.Fields {
+		if _, ok := r.keys[strings.ToLower(field.Key)]; ok {
+			entry.Fields[i].Value = r.redactValue(fmt.Sprint(field.Value))
+			continue
+		}
+		if value, ok := field.Value.(string); ok && r.Pattern != nil {
+			entry.Fields[i].Value = r.Pattern.ReplaceAllStringFunc(value, r.redactValue)
+		}
+	}
+}
+
+// replaceSubmatch replaces the submatches of every match of re in s. Only one
+// of the groups is expected to take part in each match.
+func replaceSubmatch(re *regexp.Regexp, s string, replace func(string) string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		for group := 1; 2*group < len(match); group++ {
+			start, end := match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(replace(s[start:end]))
+			last = end
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// matches reports whether the selector applies to the entry. The selector is
+// either empty or "<root>" for every entry, "#label" for entries with the
+// label, or a module name for the module and all of its children.
+func (s *redactSelector) matches(entry *Entry) bool {
+	if s.selector == "" {
+		return true
+	}
+	if label := extractConfigLabel(s.selector); label != "" {
+		for _, entryLabel := range entry.Labels {
+			if strings.ToLower(entryLabel) == label {
+				return true
+			}
+		}
+		return false
+	}
+	return entry.Module == s.selector || strings.HasPrefix(entry.Module, s.selector+".")
+}
+
+// AddRedactRules adds rules that sanitise entries before they reach any
+// writer. The selector restricts the rules to a module and its children, or
+// to the loggers with a label when written as "#label". An empty selector or
+// "<root>" applies the rules to every entry.
+func (c *Context) AddRedactRules(selector string, rules ...RedactRule) error {
+	selector = strings.TrimSpace(strings.ToLower(selector))
+	if selector == rootString {
+		selector = ""
+	}
+	compiled := make([]*redactRule, 0, len(rules))
+	for _, rule := range rules {
+		r, err := newRedactRule(rule)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, r)
+	}
+
+	c.redactMutex.Lock()
+	defer c.redactMutex.Unlock()
+	c.redactors = append(c.redactors, &redactSelector{selector: selector, rules: compiled})
+	return nil
+}
+
+// ResetRedactRules removes all the redaction rules.
+func (c *Context) ResetRedactRules() {
+	c.redactMutex.Lock()
+	defer c.redactMutex.Unlock()
+	c.redactors = nil
+}
+
+func (c *Context) redact(entry Entry) Entry {
+	c.redactMutex.RLock()
+	defer c.redactMutex.RUnlock()
+	copied := false
+	for _, selector := range c.redactors {
+		if !selector.matches(&entry) {
+			continue
+		}
+		if !copied && len(entry.Fields) > 0 {
+			// The fields may be shared with the caller, never modify them in place.
+			entry.Fields = append([]Field(nil), entry.Fields...)
+			copied = true
+		}
+		for _, rule := range selector.rules {
+			rule.apply(&entry)
+		}
+	}
+	return entry
+}
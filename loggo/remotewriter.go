@@ -0,0 +1,558 @@
+package loggo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRemoteBatchSize     = 100
+	defaultRemoteFlushInterval = time.Second
+	defaultRemoteQueueSize     = 10000
+	defaultRemoteTimeout       = 10 * time.Second
+	defaultRemoteMaxRetries    = 3
+	defaultRemoteMinBackoff    = 100 * time.Millisecond
+	defaultRemoteMaxBackoff    = 10 * time.Second
+	defaultRemoteSpoolMaxBytes = 64 << 20
+
+	spoolFileSuffix = ".ndjson"
+)
+
+// RemoteWriterConfig configures a RemoteWriter. Zero values are replaced by
+// the defaults noted on each field.
+type RemoteWriterConfig struct {
+	// Network is "http" or "https" to POST each batch as newline delimited
+	// JSON to the Address URL, or "tcp" to stream the lines to the Address
+	// host:port.
+	Network string
+	// Address is the collector URL or host:port.
+	Address string
+	// BatchSize is the maximum number of entries sent at once. Default 100.
+	BatchSize int
+	// FlushInterval is how often a partial batch is sent. Default 1s.
+	FlushInterval time.Duration
+	// QueueSize is the number of entries buffered in memory; entries written
+	// while the queue is full are dropped. Default 10000.
+	QueueSize int
+	// Timeout bounds each delivery attempt. Default 10s.
+	Timeout time.Duration
+	// MaxRetries is the number of retries after a failed delivery before the
+	// batch is spooled. Default 3, a negative value disables retries.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the delay between retries, which
+	// doubles on every attempt. Defaults 100ms and 10s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// SpoolDir is the directory holding batches that could not be delivered.
+	// They are replayed in order once the collector is reachable again. When
+	// empty, undeliverable batches are dropped.
+	SpoolDir string
+	// SpoolMaxBytes bounds the size of the spool; the oldest batches are
+	// dropped to make room. Default 64MiB.
+	SpoolMaxBytes int64
+	// HTTPClient is used for http delivery. Default http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// RemoteWriterStats holds the counters of a RemoteWriter.
+type RemoteWriterStats struct {
+	// Sent is the number of entries delivered to the collector.
+	Sent uint64
+	// Dropped is the number of entries lost because the queue or the spool
+	// was full, or the spool is disabled.
+	Dropped uint64
+	// Failures is the number of failed delivery attempts.
+	Failures uint64
+	// Spooled is the number of entries written to the spool.
+	Spooled uint64
+	// Replayed is the number of spooled entries delivered later.
+	Replayed uint64
+	// SpoolBytes and SpoolBatches describe the current spool content.
+	SpoolBytes   int64
+	SpoolBatches int
+}
+
+// RemoteWriter is a Writer that ships entries to a central collector in
+// batches. Write never blocks: entries are queued and delivered by a
+// background goroutine, which retries with backoff and spools to disk when
+// the collector is unreachable.
+type RemoteWriter struct {
+	config RemoteWriterConfig
+	queue  chan Entry
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+
+	// closeMutex makes Close wait for the Writes in progress, so that every
+	// entry is either queued before the final drain or counted as dropped.
+	closeMutex sync.RWMutex
+	closed     bool
+
+	// conn is the current tcp connection, only used by the run goroutine.
+	conn net.Conn
+	// replayAfter and replayBackoff delay spool replays after a failure, so
+	// an unreachable collector does not block the run goroutine on every
+	// flush. Only used by the run goroutine.
+	replayAfter   time.Time
+	replayBackoff time.Duration
+
+	sent     uint64
+	dropped  uint64
+	failures uint64
+	spooled  uint64
+	replayed uint64
+
+	spoolMutex sync.Mutex
+	spoolFiles []spoolFile
+	spoolBytes int64
+	spoolSeq   uint64
+}
+
+type spoolFile struct {
+	name  string
+	size  int64
+	count int
+}
+
+// NewRemoteWriter returns a RemoteWriter for the config and starts its
+// delivery goroutine. Batches left in the spool directory by a previous run
+// are replayed first.
+func NewRemoteWriter(config RemoteWriterConfig) (*RemoteWriter, error) {
+	switch config.Network {
+	case "http", "https", "tcp":
+	default:
+		return nil, fmt.Errorf("unsupported remote network %q", config.Network)
+	}
+	if config.Address == "" {
+		return nil, fmt.Errorf("remote address cannot be empty")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultRemoteBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultRemoteFlushInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultRemoteQueueSize
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultRemoteTimeout
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	} else if config.MaxRetries == 0 {
+		config.MaxRetries = defaultRemoteMaxRetries
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = defaultRemoteMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = defaultRemoteMaxBackoff
+	}
+	if config.SpoolMaxBytes <= 0 {
+		config.SpoolMaxBytes = defaultRemoteSpoolMaxBytes
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	w := &RemoteWriter{
+		config: config,
+		queue:  make(chan Entry, config.QueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if config.SpoolDir != "" {
+		if err := w.loadSpool(); err != nil {
+			return nil, err
+		}
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write queues the entry for delivery. The entry is dropped if the queue is
+// full or the writer is closed.
+func (w *RemoteWriter) Write(entry Entry) {
+	w.closeMutex.RLock()
+	defer w.closeMutex.RUnlock()
+	if w.closed {
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	}
+	select {
+	case w.queue <- entry:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// Close flushes the queued entries, spooling them if they cannot be
+// delivered, and stops the delivery goroutine.
+func (w *RemoteWriter) Close() error {
+	w.once.Do(func() {
+		w.closeMutex.Lock()
+		w.closed = true
+		w.closeMutex.Unlock()
+		close(w.stop)
+	})
+	<-w.done
+	return nil
+}
+
+// Stats returns a snapshot of the writer counters.
+func (w *RemoteWriter) Stats() RemoteWriterStats {
+	w.spoolMutex.Lock()
+	spoolBytes, spoolBatches := w.spoolBytes, len(w.spoolFiles)
+	w.spoolMutex.Unlock()
+	return RemoteWriterStats{
+		Sent:         atomic.LoadUint64(&w.sent),
+		Dropped:      atomic.LoadUint64(&w.dropped),
+		Failures:     atomic.LoadUint64(&w.failures),
+		Spooled:      atomic.LoadUint64(&w.spooled),
+		Replayed:     atomic.LoadUint64(&w.replayed),
+		SpoolBytes:   spoolBytes,
+		SpoolBatches: spoolBatches,
+	}
+}
+
+func (w *RemoteWriter) run() {
+	defer close(w.done)
+	defer func() {
+		if w.conn != nil {
+			_ = w.conn.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, w.config.BatchSize)
+	for {
+		select {
+		case entry := <-w.queue:
+			batch = append(batch, entry)
+			if len(batch) >= w.config.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			} else {
+				w.replaySpool()
+			}
+		case <-w.stop:
+			w.drain(batch)
+			return
+		}
+	}
+}
+
+// drain flushes the entries still queued when the writer is closed.
+func (w *RemoteWriter) drain(batch []Entry) {
+	for {
+		select {
+		case entry := <-w.queue:
+			batch = append(batch, entry)
+			if len(batch) >= w.config.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		default:
+			if len(batch) > 0 {
+				w.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// flush delivers the batch. Spooled batches are replayed first so that the
+// collector receives entries in order; while the spool cannot be drained the
+// new batch is appended to it.
+func (w *RemoteWriter) flush(batch []Entry) {
+	payload := encodeBatch(batch)
+	if !w.replaySpool() {
+		w.spool(payload, len(batch))
+		return
+	}
+	rest, err := w.sendWithRetry(payload)
+	count := countLines(rest)
+	atomic.AddUint64(&w.sent, uint64(len(batch)-count))
+	if err != nil {
+		w.spool(rest, count)
+	}
+}
+
+// countLines returns the number of entries in an encoded batch.
+func countLines(payload []byte) int {
+	return bytes.Count(payload, []byte{'\n'})
+}
+
+// unsent returns the part of the payload after the lines that were written
+// completely, so that a retry neither repeats nor splits a line.
+func unsent(payload []byte, written int) []byte {
+	return payload[bytes.LastIndexByte(payload[:written], '\n')+1:]
+}
+
+func encodeBatch(batch []Entry) []byte {
+	var buf bytes.Buffer
+	for _, entry := range batch {
+		buf.WriteString(JSONFormatter(entry))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// sendWithRetry delivers the payload and returns the lines that could not be
+// delivered.
+func (w *RemoteWriter) sendWithRetry(payload []byte) ([]byte, error) {
+	backoff := w.config.MinBackoff
+	for attempt := 0; ; attempt++ {
+		written, err := w.send(payload)
+		if err == nil {
+			return nil, nil
+		}
+		atomic.AddUint64(&w.failures, 1)
+		if payload = unsent(payload, written); len(payload) == 0 {
+			return nil, nil
+		}
+		if attempt >= w.config.MaxRetries {
+			return payload, err
+		}
+		// Full jitter keeps many writers from retrying in lockstep.
+		delay := time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-time.After(delay):
+		case <-w.stop:
+			// Closing: give up quickly and let the batch be spooled.
+			return payload, err
+		}
+		backoff *= 2
+		if backoff > w.config.MaxBackoff {
+			backoff = w.config.MaxBackoff
+		}
+	}
+}
+
+// send delivers the payload once. On failure it returns the number of bytes
+// the collector may have received, which is only non-zero for tcp.
+func (w *RemoteWriter) send(payload []byte) (int, error) {
+	if w.config.Network == "tcp" {
+		return w.sendTCP(payload)
+	}
+	return 0, w.sendHTTP(payload)
+}
+
+func (w *RemoteWriter) sendHTTP(payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.Address, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := w.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (w *RemoteWriter) sendTCP(payload []byte) (int, error) {
+	if w.conn == nil {
+		conn, err := net.DialTimeout("tcp", w.config.Address, w.config.Timeout)
+		if err != nil {
+			return 0, err
+		}
+		w.conn = conn
+	}
+	_ = w.conn.SetWriteDeadline(time.Now().Add(w.config.Timeout))
+	n, err := w.conn.Write(payload)
+	if err != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+	return n, err
+}
+
+// loadSpool picks up the batches left by a previous run.
+func (w *RemoteWriter) loadSpool() error {
+	if err := os.MkdirAll(w.config.SpoolDir, 0755); err != nil {
+		return err
+	}
+	matches, err := filepath.Glob(filepath.Join(w.config.SpoolDir, "*"+spoolFileSuffix))
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+	for _, name := range matches {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		seq, count, ok := parseSpoolName(name)
+		if !ok {
+			continue
+		}
+		if seq >= w.spoolSeq {
+			w.spoolSeq = seq + 1
+		}
+		w.spoolFiles = append(w.spoolFiles, spoolFile{name: name, size: info.Size(), count: count})
+		w.spoolBytes += info.Size()
+	}
+	return nil
+}
+
+// Spool files are named <seq>-<entries>.ndjson, the zero padded sequence
+// keeps them sorted in delivery order.
+func parseSpoolName(name string) (seq uint64, count int, ok bool) {
+	base := strings.TrimSuffix(filepath.Base(name), spoolFileSuffix)
+	parts := strings.SplitN(base, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	count, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return seq, count, true
+}
+
+func (w *RemoteWriter) spool(payload []byte, count int) {
+	if w.config.SpoolDir == "" || int64(len(payload)) > w.config.SpoolMaxBytes {
+		atomic.AddUint64(&w.dropped, uint64(count))
+		return
+	}
+
+	w.spoolMutex.Lock()
+	defer w.spoolMutex.Unlock()
+	// Make room by dropping the oldest batches.
+	for len(w.spoolFiles) > 0 && w.spoolBytes+int64(len(payload)) > w.config.SpoolMaxBytes {
+		w.removeSpoolHead(true)
+	}
+	name := filepath.Join(w.config.SpoolDir, fmt.Sprintf("%020d-%d%s", w.spoolSeq, count, spoolFileSuffix))
+	if err := os.WriteFile(name, payload, 0644); err != nil {
+		atomic.AddUint64(&w.dropped, uint64(count))
+		return
+	}
+	w.spoolSeq++
+	w.spoolFiles = append(w.spoolFiles, spoolFile{name: name, size: int64(len(payload)), count: count})
+	w.spoolBytes += int64(len(payload))
+	atomic.AddUint64(&w.spooled, uint64(count))
+}
+
+// removeSpoolHead deletes the oldest spooled batch. The caller must hold
+// spoolMutex.
+func (w *RemoteWriter) removeSpoolHead(dropped bool) {
+	head := w.spoolFiles[0]
+	w.spoolBytes -= head.size
+	if dropped {
+		atomic.AddUint64(&w.dropped, uint64(head.count))
+	}
+	_ = os.Remove(head.name)
+	w.spoolFiles = w.spoolFiles[1:]
+}
+
+// replaySpool delivers the spooled batches in order, and reports whether the
+// spool is now empty. Every batch is tried once, so an unreachable collector
+// does not stall the writer; after a failure replays are skipped for a
+// growing backoff.
+func (w *RemoteWriter) replaySpool() bool {
+	for {
+		w.spoolMutex.Lock()
+		if len(w.spoolFiles) == 0 {
+			w.spoolMutex.Unlock()
+			return true
+		}
+		head := w.spoolFiles[0]
+		w.spoolMutex.Unlock()
+		if time.Now().Before(w.replayAfter) {
+			return false
+		}
+
+		// A batch that cannot be read is lost, skip it.
+		payload, err := os.ReadFile(head.name)
+		if err == nil {
+			written, err := w.send(payload)
+			if err != nil {
+				atomic.AddUint64(&w.failures, 1)
+				w.delayReplay()
+				w.trimSpoolHead(head, payload, written)
+				return false
+			}
+			atomic.AddUint64(&w.replayed, uint64(head.count))
+			atomic.AddUint64(&w.sent, uint64(head.count))
+		}
+		w.replayBackoff = 0
+
+		w.spoolMutex.Lock()
+		if len(w.spoolFiles) > 0 && w.spoolFiles[0].name == head.name {
+			w.removeSpoolHead(false)
+		}
+		w.spoolMutex.Unlock()
+	}
+}
+
+// delayReplay doubles the delay before the next replay, starting at
+// MinBackoff and bounded by MaxBackoff.
+func (w *RemoteWriter) delayReplay() {
+	if w.replayBackoff *= 2; w.replayBackoff < w.config.MinBackoff {
+		w.replayBackoff = w.config.MinBackoff
+	} else if w.replayBackoff > w.config.MaxBackoff {
+		w.replayBackoff = w.config.MaxBackoff
+	}
+	w.replayAfter = time.Now().Add(w.replayBackoff)
+}
+
+// trimSpoolHead removes the lines of the oldest spooled batch that were
+// written before a replay failed, so they are not sent again.
+func (w *RemoteWriter) trimSpoolHead(head spoolFile, payload []byte, written int) {
+	rest := unsent(payload, written)
+	if len(rest) == len(payload) {
+		return
+	}
+	count := countLines(rest)
+	atomic.AddUint64(&w.replayed, uint64(head.count-count))
+	atomic.AddUint64(&w.sent, uint64(head.count-count))
+
+	w.spoolMutex.Lock()
+	defer w.spoolMutex.Unlock()
+	if len(w.spoolFiles) == 0 || w.spoolFiles[0].name != head.name {
+		return
+	}
+	if len(rest) == 0 {
+		w.removeSpoolHead(false)
+		return
+	}
+	seq, _, _ := parseSpoolName(head.name)
+	name := filepath.Join(w.config.SpoolDir, fmt.Sprintf("%020d-%d%s", seq, count, spoolFileSuffix))
+	if err := os.WriteFile(name, rest, 0644); err != nil {
+		return
+	}
+	_ = os.Remove(head.name)
+	w.spoolBytes += int64(len(rest)) - head.size
+	w.spoolFiles[0] = spoolFile{name: name, size: int64(len(rest)), count: count}
+}
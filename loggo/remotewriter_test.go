@@ -0,0 +1,194 @@
+package loggo
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCollector struct {
+	mu       sync.Mutex
+	messages []string
+	down     int32
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&c.down) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	scanner := bufio.NewScanner(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for scanner.Scan() {
+		var entry jsonEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			c.messages = append(c.messages, entry.Message)
+		}
+	}
+}
+
+func (c *testCollector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.messages...)
+}
+
+func TestRemoteWriterHTTP(t *testing.T) {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriterConfig{
+		Network:       "http",
+		Address:       server.URL,
+		BatchSize:     2,
+		FlushInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	for _, msg := range []string{"one", "two", "three"} {
+		writer.Write(Entry{Level: INFO, Message: msg, Timestamp: time.Now()})
+	}
+	require.NoError(t, writer.Close())
+	require.Equal(t, []string{"one", "two", "three"}, collector.received())
+	require.Equal(t, uint64(3), writer.Stats().Sent)
+}
+
+func TestRemoteWriterSpoolAndReplay(t *testing.T) {
+	collector := &testCollector{down: 1}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	config := RemoteWriterConfig{
+		Network:       "http",
+		Address:       server.URL,
+		BatchSize:     1,
+		FlushInterval: 10 * time.Millisecond,
+		MaxRetries:    -1,
+		SpoolDir:      t.TempDir(),
+	}
+	writer, err := NewRemoteWriter(config)
+	require.NoError(t, err)
+	writer.Write(Entry{Level: INFO, Message: "one"})
+	writer.Write(Entry{Level: INFO, Message: "two"})
+	require.NoError(t, writer.Close())
+
+	stats := writer.Stats()
+	require.Equal(t, uint64(2), stats.Spooled)
+	require.Equal(t, 2, stats.SpoolBatches)
+	require.Empty(t, collector.received())
+
+	// A new writer on the same spool replays it once the collector is back.
+	atomic.StoreInt32(&collector.down, 0)
+	writer, err = NewRemoteWriter(config)
+	require.NoError(t, err)
+	writer.Write(Entry{Level: INFO, Message: "three"})
+	require.Eventually(t, func() bool {
+		return len(collector.received()) == 3
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, writer.Close())
+
+	require.Equal(t, []string{"one", "two", "three"}, collector.received())
+	stats = writer.Stats()
+	require.Equal(t, uint64(2), stats.Replayed)
+	require.Zero(t, stats.SpoolBatches)
+	require.Zero(t, stats.SpoolBytes)
+}
+
+func TestRemoteWriterSpoolLimit(t *testing.T) {
+	collector := &testCollector{down: 1}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriterConfig{
+		Network:       "http",
+		Address:       server.URL,
+		BatchSize:     1,
+		MaxRetries:    -1,
+		SpoolDir:      t.TempDir(),
+		SpoolMaxBytes: 400,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		writer.Write(Entry{Level: INFO, Message: "a message that takes up some room"})
+	}
+	require.NoError(t, writer.Close())
+
+	stats := writer.Stats()
+	require.LessOrEqual(t, stats.SpoolBytes, int64(400))
+	require.Equal(t, uint64(10), stats.Dropped+uint64(stats.SpoolBatches))
+}
+
+func TestRemoteWriterUnsent(t *testing.T) {
+	payload := []byte("one\ntwo\nthree\n")
+	require.Equal(t, payload, unsent(payload, 0))
+	require.Equal(t, payload, unsent(payload, 2))
+	require.Equal(t, []byte("two\nthree\n"), unsent(payload, 4))
+	require.Equal(t, []byte("two\nthree\n"), unsent(payload, 6))
+	require.Empty(t, unsent(payload, len(payload)))
+	require.Equal(t, 2, countLines(unsent(payload, 6)))
+}
+
+func TestRemoteWriterCloseRace(t *testing.T) {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriterConfig{Network: "http", Address: server.URL})
+	require.NoError(t, err)
+	const writers, count = 8, 200
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				writer.Write(Entry{Level: INFO, Message: "m"})
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	require.NoError(t, writer.Close())
+	wg.Wait()
+
+	// Every entry is either delivered or counted as dropped.
+	stats := writer.Stats()
+	require.Equal(t, uint64(writers*count), stats.Sent+stats.Dropped)
+	require.Equal(t, int(stats.Sent), len(collector.received()))
+}
+
+func TestRemoteWriterReplayBackoff(t *testing.T) {
+	collector := &testCollector{down: 1}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriterConfig{
+		Network:       "http",
+		Address:       server.URL,
+		BatchSize:     1,
+		FlushInterval: time.Millisecond,
+		MaxRetries:    -1,
+		MinBackoff:    time.Hour,
+		MaxBackoff:    time.Hour,
+		SpoolDir:      t.TempDir(),
+	})
+	require.NoError(t, err)
+	writer.Write(Entry{Level: INFO, Message: "one"})
+	writer.Write(Entry{Level: INFO, Message: "two"})
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, writer.Close())
+
+	// The first batch fails, the failed replay before the second starts the
+	// backoff and later ticks do not retry.
+	stats := writer.Stats()
+	require.Equal(t, uint64(2), stats.Failures)
+	require.Equal(t, 2, stats.SpoolBatches)
+}
@@ -0,0 +1,228 @@
+package loggo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// The slog levels used for the loggo levels that have no slog equivalent.
+const (
+	SlogLevelTrace    = slog.LevelDebug - 4
+	SlogLevelCritical = slog.LevelError + 4
+)
+
+// LevelFromSlog converts a slog level to the closest loggo level.
+func LevelFromSlog(level slog.Level) Level {
+	switch {
+	case level >= SlogLevelCritical:
+		return CRITICAL
+	case level >= slog.LevelError:
+		return ERROR
+	case level >= slog.LevelWarn:
+		return WARNING
+	case level >= slog.LevelInfo:
+		return INFO
+	case level >= slog.LevelDebug:
+		return DEBUG
+	default:
+		return TRACE
+	}
+}
+
+// SlogLevel converts the level to the equivalent slog level.
+func (level Level) SlogLevel() slog.Level {
+	switch level {
+	case TRACE:
+		return SlogLevelTrace
+	case DEBUG:
+		return slog.LevelDebug
+	case WARNING:
+		return slog.LevelWarn
+	case ERROR:
+		return slog.LevelError
+	case CRITICAL:
+		return SlogLevelCritical
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// NewSlogHandler returns a slog.Handler that writes records through the given
+// logger. Record levels are mapped with LevelFromSlog and filtered by the
+// effective log level of the logger, and attributes become entry fields.
+// Attributes inside groups are flattened with dotted keys, so the attribute
+// "id" in the group "req" becomes the field "req.id".
+func NewSlogHandler(logger Logger) slog.Handler {
+	return &slogHandler{module: logger.getModule()}
+}
+
+type slogHandler struct {
+	module *module
+	fields []Field
+	prefix string
+}
+
+// Enabled implements slog.Handler.
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.module.willWrite(LevelFromSlog(level))
+}
+
+// Handle implements slog.Handler.
+func (h *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	level := LevelFromSlog(record.Level)
+	if !h.module.willWrite(level) {
+		return nil
+	}
+	file, line := "???", 0
+	if record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		file, line = frame.File, frame.Line
+	}
+	timestamp := record.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	fields := make([]Field, len(h.fields), len(h.fields)+record.NumAttrs())
+	copy(fields, h.fields)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendSlogAttr(fields, h.prefix, attr)
+		return true
+	})
+
+	h.module.write(Entry{
+		Level:     level,
+		Filename:  file,
+		Line:      line,
+		Timestamp: timestamp,
+		Message:   record.Message,
+		Labels:    h.module.getLabels(),
+		Fields:    fields,
+	})
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	fields := make([]Field, len(h.fields), len(h.fields)+len(attrs))
+	copy(fields, h.fields)
+	for _, attr := range attrs {
+		fields = appendSlogAttr(fields, h.prefix, attr)
+	}
+	return &slogHandler{module: h.module, fields: fields, prefix: h.prefix}
+}
+
+// WithGroup implements slog.Handler.
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{module: h.module, fields: h.fields, prefix: h.prefix + name + "."}
+}
+
+func appendSlogAttr(fields []Field, prefix string, attr slog.Attr) []Field {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		group := value.Group()
+		if len(group) == 0 {
+			return fields
+		}
+		// A group with an empty key is inlined into the parent.
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, inner := range group {
+			fields = appendSlogAttr(fields, prefix, inner)
+		}
+		return fields
+	}
+	if attr.Key == "" {
+		return fields
+	}
+	return append(fields, Field{Key: prefix + attr.Key, Value: value.Any()})
+}
+
+// NewSlogWriter returns a Writer that emits log entries through the given
+// slog handler. The entry module, labels and location are added as the
+// "module", "labels" and "caller" attributes, followed by the entry fields.
+func NewSlogWriter(handler slog.Handler) Writer {
+	return &slogWriter{handler: handler}
+}
+
+type slogWriter struct {
+	handler slog.Handler
+}
+
+func (w *slogWriter) Write(entry Entry) {
+	ctx := context.Background()
+	level := entry.Level.SlogLevel()
+	if !w.handler.Enabled(ctx, level) {
+		return
+	}
+	record := slog.NewRecord(entry.Timestamp, level, entry.Message, 0)
+	record.AddAttrs(
+		slog.String("module", entry.Module),
+		slog.String("caller", fmt.Sprintf("%s:%d", filepath.Base(entry.Filename), entry.Line)),
+	)
+	if len(entry.Labels) > 0 {
+		record.AddAttrs(slog.Any("labels", entry.Labels))
+	}
+	for _, field := range entry.Fields {
+		record.AddAttrs(slog.Any(field.Key, field.Value))
+	}
+	_ = w.handler.Handle(ctx, record)
+}
+
+// stdlibCallDepth is the number of frames between the Write method of the
+// log writer and the caller of the standard library log functions.
+const stdlibCallDepth = 3
+
+// NewLogWriter returns an io.Writer that logs every line written to it
+// through the given logger at the given level. It is intended to be used as
+// the output of a standard library log.Logger, in which case the reported
+// location is the caller of the log.Logger method.
+func NewLogWriter(logger Logger, level Level) io.Writer {
+	return &logWriter{logger: logger, level: level}
+}
+
+type logWriter struct {
+	logger Logger
+	level  Level
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(p, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		// No args are passed, so the message is never used as a format.
+		w.logger.LogCallf(stdlibCallDepth, w.level, string(line))
+	}
+	return len(p), nil
+}
+
+// RedirectStdLog sends the output of the standard library log package to the
+// given logger at the given level. The log flags and prefix are cleared as
+// loggo adds its own timestamp and location. The returned function restores
+// the previous output, flags and prefix.
+func RedirectStdLog(logger Logger, level Level) func() {
+	output, flags, prefix := log.Writer(), log.Flags(), log.Prefix()
+	log.SetOutput(NewLogWriter(logger, level))
+	log.SetFlags(0)
+	log.SetPrefix("")
+	return func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	}
+}
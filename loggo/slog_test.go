@@ -0,0 +1,139 @@
+package loggo
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLevels(t *testing.T) {
+	for _, level := range []Level{TRACE, DEBUG, INFO, WARNING, ERROR, CRITICAL} {
+		require.Equal(t, level, LevelFromSlog(level.SlogLevel()), level.String())
+	}
+	// Levels between the slog constants map to the level below them.
+	require.Equal(t, TRACE, LevelFromSlog(slog.LevelDebug-1))
+	require.Equal(t, INFO, LevelFromSlog(slog.LevelInfo+2))
+	require.Equal(t, ERROR, LevelFromSlog(SlogLevelCritical-1))
+	require.Equal(t, CRITICAL, LevelFromSlog(SlogLevelCritical+4))
+	require.Equal(t, slog.LevelInfo, UNSPECIFIED.SlogLevel())
+}
+
+func TestSlogHandler(t *testing.T) {
+	context := NewContext(INFO)
+	collector := &entryCollector{}
+	require.NoError(t, context.AddWriter("collector", collector))
+	logger := slog.New(NewSlogHandler(context.GetLogger("app")))
+
+	logger.Debug("filtered")
+	_, file, line, _ := runtime.Caller(0)
+	logger.With("a", 1).WithGroup("req").With("id", 7).Warn("handled",
+		slog.Group("user", slog.String("name", "alice")),
+		slog.Group("", slog.Int("inline", 3)),
+		slog.Group("empty"),
+		"n", 2,
+	)
+
+	require.Len(t, collector.entries, 1)
+	entry := collector.entries[0]
+	require.Equal(t, WARNING, entry.Level)
+	require.Equal(t, "app", entry.Module)
+	require.Equal(t, "handled", entry.Message)
+	require.Equal(t, []Field{
+		{Key: "a", Value: int64(1)},
+		{Key: "req.id", Value: int64(7)},
+		{Key: "req.user.name", Value: "alice"},
+		{Key: "req.inline", Value: int64(3)},
+		{Key: "req.n", Value: int64(2)},
+	}, entry.Fields)
+	// The location is the line the slog call starts on.
+	require.Equal(t, file, entry.Filename)
+	require.Equal(t, line+1, entry.Line)
+}
+
+func TestSlogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewSlogWriter(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	writer.Write(Entry{Level: DEBUG, Message: "filtered"})
+	require.Zero(t, buf.Len())
+
+	writer.Write(Entry{
+		Level:     CRITICAL,
+		Module:    "app.db",
+		Filename:  "/src/app/db.go",
+		Line:      42,
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Message:   "down",
+		Labels:    []string{"audit"},
+		Fields:    []Field{{Key: "retries", Value: 3}},
+	})
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	require.Equal(t, "ERROR+4", record["level"])
+	require.Equal(t, "2024-01-02T03:04:05Z", record["time"])
+	require.Equal(t, "down", record["msg"])
+	require.Equal(t, "app.db", record["module"])
+	require.Equal(t, "db.go:42", record["caller"])
+	require.Equal(t, []interface{}{"audit"}, record["labels"])
+	require.Equal(t, float64(3), record["retries"])
+}
+
+func TestLogWriter(t *testing.T) {
+	context := NewContext(INFO)
+	collector := &entryCollector{}
+	require.NoError(t, context.AddWriter("collector", collector))
+	std := log.New(NewLogWriter(context.GetLogger("std"), WARNING), "", 0)
+
+	_, file, line, _ := runtime.Caller(0)
+	std.Print("first\nsecond")
+
+	require.Equal(t, []string{"first", "second"}, collector.messages())
+	for _, entry := range collector.entries {
+		require.Equal(t, WARNING, entry.Level)
+		require.Equal(t, "std", entry.Module)
+		// stdlibCallDepth reports the caller of the log.Logger method.
+		require.Equal(t, file, entry.Filename)
+		require.Equal(t, line+1, entry.Line)
+	}
+}
+
+func TestRedirectStdLog(t *testing.T) {
+	output, flags, prefix := log.Writer(), log.Flags(), log.Prefix()
+	t.Cleanup(func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	})
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(log.Lshortfile)
+	log.SetPrefix("std: ")
+
+	context := NewContext(INFO)
+	collector := &entryCollector{}
+	require.NoError(t, context.AddWriter("collector", collector))
+	restore := RedirectStdLog(context.GetLogger("std"), INFO)
+	require.Zero(t, log.Flags())
+	require.Empty(t, log.Prefix())
+	_, file, line, _ := runtime.Caller(0)
+	log.Print("redirected")
+	require.Equal(t, []string{"redirected"}, collector.messages())
+	require.Equal(t, file, collector.entries[0].Filename)
+	require.Equal(t, line+1, collector.entries[0].Line)
+	require.Zero(t, buf.Len())
+
+	restore()
+	require.Equal(t, &buf, log.Writer())
+	require.Equal(t, log.Lshortfile, log.Flags())
+	require.Equal(t, "std: ", log.Prefix())
+	log.Print("direct")
+	require.Contains(t, buf.String(), "std: slog_test.go:")
+	require.Contains(t, buf.String(), "direct")
+	require.Len(t, collector.entries, 1)
+}
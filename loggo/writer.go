@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
@@ -45,6 +46,38 @@ func (w minLevelWriter) Write(entry Entry) {
 	w.writer.Write(entry)
 }
 
+// NewLabelWriter returns a Writer that only passes on the Write calls to the
+// provided writer for entries carrying at least one of the labels. Labels may
+// be given with or without the leading '#' and are matched case-insensitively.
+// For example, all "#audit" loggers can be sent to a dedicated file with
+//	ctx.AddWriter("audit", loggo.NewLabelWriter(fileWriter, "audit"))
+func NewLabelWriter(writer Writer, labels ...string) Writer {
+	lookup := make(map[string]struct{}, len(labels))
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(label), "#"))
+		lookup[label] = struct{}{}
+	}
+	return &labelWriter{
+		writer: writer,
+		labels: lookup,
+	}
+}
+
+type labelWriter struct {
+	writer Writer
+	labels map[string]struct{}
+}
+
+// Write writes the log record if it carries one of the labels.
+func (w *labelWriter) Write(entry Entry) {
+	for _, label := range entry.Labels {
+		if _, ok := w.labels[strings.ToLower(label)]; ok {
+			w.writer.Write(entry)
+			return
+		}
+	}
+}
+
 type simpleWriter struct {
 	writer    io.Writer
 	formatter func(entry Entry) string
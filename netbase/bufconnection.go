@@ -38,3 +38,8 @@ func (conn bufferConnection) Read(b []byte) (n int, err error) {
 func (conn bufferConnection) Write(b []byte) (n int, err error) {
 	return conn.rwBuffer.Write(b)
 }
+
+// ReadByte 从读缓存读取一个字节,供按字节解析的协议(如uvarint)直接使用缓存
+func (conn bufferConnection) ReadByte() (byte, error) {
+	return conn.rwBuffer.ReadByte()
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"errors"
+	"github.com/lngwu11/toolgo/utils"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleConn 空闲超时连接
+// 每次读写时把读写截止时间延长到now+timeout,阻塞中的读写超过空闲时间返回超时错误;
+// 没有读写时由定时器在空闲时间到达后关闭连接。
+// 读写会覆盖调用者通过SetDeadline设置的截止时间
+type idleConn struct {
+	utils.ConnReadWriteCloser
+	timeout time.Duration
+	// 最后一次读写的unix纳秒时间
+	last atomic.Int64
+
+	mu     sync.Mutex
+	timer  *time.Timer
+	closed bool
+}
+
+func newIdleConn(conn utils.ConnReadWriteCloser, timeout time.Duration) *idleConn {
+	c := &idleConn{ConnReadWriteCloser: conn, timeout: timeout}
+	c.touch()
+	c.mu.Lock()
+	c.timer = time.AfterFunc(timeout, c.check)
+	c.mu.Unlock()
+	return c
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	c.touch()
+	n, err := c.ConnReadWriteCloser.Read(p)
+	if n > 0 {
+		c.touch()
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		_ = c.Close()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	c.touch()
+	n, err := c.ConnReadWriteCloser.Write(p)
+	if n > 0 {
+		c.touch()
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		_ = c.Close()
+	}
+	return n, err
+}
+
+func (c *idleConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.timer.Stop()
+	c.mu.Unlock()
+	return c.ConnReadWriteCloser.Close()
+}
+
+// touch 记录读写并延长截止时间
+func (c *idleConn) touch() {
+	now := time.Now()
+	c.last.Store(now.UnixNano())
+	_ = c.ConnReadWriteCloser.SetDeadline(now.Add(c.timeout))
+}
+
+// check 定时器回调,空闲超时则关闭连接,否则在剩余时间后再次检查
+func (c *idleConn) check() {
+	idle := time.Since(time.Unix(0, c.last.Load()))
+	if idle >= c.timeout {
+		_ = c.ConnReadWriteCloser.Close()
+		return
+	}
+	c.mu.Lock()
+	if !c.closed {
+		c.timer.Reset(c.timeout - idle)
+	}
+	c.mu.Unlock()
+}
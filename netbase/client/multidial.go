@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认并发拨号的间隔,参考RFC 8305
+	defaultFallbackDelay = 300 * time.Millisecond
+	// 默认失败地址的冷却时间
+	defaultFailureCooldown = 30 * time.Second
+	// 最多记录的冷却地址数
+	maxCooldownEntries = 1024
+)
+
+// DialStrategy 多地址拨号的顺序
+type DialStrategy int
+
+const (
+	// DialInOrder 按传入的顺序尝试
+	DialInOrder DialStrategy = iota
+	// DialRandom 随机打乱后尝试,用于在多个后端间分散连接
+	DialRandom
+)
+
+// 最近拨号失败的地址,所有多地址拨号共享
+var failures = &cooldownCache{until: make(map[string]time.Time)}
+
+// WithDialStrategy 设置多地址拨号的顺序,默认DialInOrder
+func WithDialStrategy(strategy DialStrategy) Option {
+	return func(opts *Options) {
+		opts.dialStrategy = strategy
+	}
+}
+
+// WithFallbackDelay 设置多地址拨号时启动下一个尝试前的等待时间,默认300ms,
+// 先完成的连接胜出;负数表示前一个尝试失败后才尝试下一个
+func WithFallbackDelay(delay time.Duration) Option {
+	return func(opts *Options) {
+		opts.fallbackDelay = delay
+	}
+}
+
+// WithFailureCooldown 设置拨号失败的地址的冷却时间,默认30s,负数表示不记录
+// 冷却中的地址排在其他地址之后,全部地址都在冷却中时仍然按顺序尝试
+func WithFailureCooldown(cooldown time.Duration) Option {
+	return func(opts *Options) {
+		opts.failureCooldown = cooldown
+	}
+}
+
+// NewMultiConnection 连接多个地址中的一个,返回连接和选中的地址
+// 主机名解析为多个地址时IPv6和IPv4交替排列,按WithFallbackDelay的间隔依次发起拨号,
+// 第一个建立的连接胜出,其他尝试被取消
+func NewMultiConnection(endpoints []string, options ...Option) (utils.ConnReadWriteCloser, string, error) {
+	return dialMulti(context.Background(), endpoints, loadOptions(options...))
+}
+
+// candidate 一个待拨号的地址
+type candidate struct {
+	addr string
+	opts *Options
+}
+
+type dialResult struct {
+	addr string
+	conn utils.ConnReadWriteCloser
+	err  error
+}
+
+func dialMulti(ctx context.Context, endpoints []string, opts *Options) (utils.ConnReadWriteCloser, string, error) {
+	if len(endpoints) == 0 {
+		return nil, "", errors.Errorf("没有可连接的地址")
+	}
+	endpoints = append([]string(nil), endpoints...)
+	if opts.dialStrategy == DialRandom {
+		rand.Shuffle(len(endpoints), func(i, j int) {
+			endpoints[i], endpoints[j] = endpoints[j], endpoints[i]
+		})
+	}
+
+	candidates, err := resolveCandidates(ctx, endpoints, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.failureCooldown >= 0 {
+		candidates = failures.sort(candidates)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(candidates))
+	next, inflight := 0, 0
+	start := func() {
+		c := candidates[next]
+		next++
+		inflight++
+		go func() {
+			conn, err := dial(ctx, c.addr, c.opts)
+			results <- dialResult{addr: c.addr, conn: conn, err: err}
+		}()
+	}
+
+	start()
+	var lastErr error
+	for inflight > 0 {
+		var timer *time.Timer
+		var fallback <-chan time.Time
+		if next < len(candidates) && opts.fallbackDelay >= 0 {
+			timer = time.NewTimer(opts.fallbackDelay)
+			fallback = timer.C
+		}
+
+		select {
+		case r := <-results:
+			stopTimer(timer)
+			inflight--
+			if r.err == nil {
+				failures.remove(r.addr)
+				cancel()
+				go closeLosers(results, inflight)
+				return r.conn, r.addr, nil
+			}
+			lastErr = r.err
+			if opts.failureCooldown >= 0 {
+				failures.add(r.addr, opts.failureCooldown)
+			}
+			// 失败后立即尝试下一个地址
+			if next < len(candidates) {
+				start()
+			}
+		case <-fallback:
+			start()
+		}
+	}
+	return nil, "", errors.Wrapf(lastErr, "无法连接任何地址 [endpoints=%v]", endpoints)
+}
+
+// closeLosers 关闭胜出之后建立的连接
+func closeLosers(results <-chan dialResult, count int) {
+	for i := 0; i < count; i++ {
+		if r := <-results; r.conn != nil {
+			_ = r.conn.Close()
+		}
+	}
+}
+
+// resolveCandidates 解析主机名,返回按顺序排列的地址
+func resolveCandidates(ctx context.Context, endpoints []string, opts *Options) ([]candidate, error) {
+	var candidates []candidate
+	var lastErr error
+	for _, endpoint := range endpoints {
+		host, port, err := net.SplitHostPort(endpoint)
+		// socks5h和http代理由代理解析主机名
+		if opts.network == "unix" || proxyResolvesHost(opts.proxy) || err != nil || net.ParseIP(host) != nil {
+			candidates = append(candidates, candidate{addr: endpoint, opts: opts})
+			continue
+		}
+
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "无法解析主机名 [endpoint=%v]", endpoint)
+			continue
+		}
+		hostOpts := withServerName(opts, host)
+		for _, ip := range interleave(addrs, opts.network) {
+			candidates = append(candidates, candidate{addr: net.JoinHostPort(ip.String(), port), opts: hostOpts})
+		}
+	}
+	if len(candidates) == 0 {
+		if lastErr == nil {
+			lastErr = errors.Errorf("没有可连接的地址 [endpoints=%v]", endpoints)
+		}
+		return nil, lastErr
+	}
+	return candidates, nil
+}
+
+// interleave 按network过滤地址,IPv6优先与IPv4交替排列
+func interleave(addrs []net.IPAddr, network string) []net.IP {
+	var v6, v4 []net.IP
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			if network != "tcp6" {
+				v4 = append(v4, addr.IP)
+			}
+		} else if network != "tcp4" {
+			v6 = append(v6, addr.IP)
+		}
+	}
+	ips := make([]net.IP, 0, len(v6)+len(v4))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ips = append(ips, v6[i])
+		}
+		if i < len(v4) {
+			ips = append(ips, v4[i])
+		}
+	}
+	return ips
+}
+
+// withServerName 按解析出的地址拨号时tls仍然校验原来的主机名
+func withServerName(opts *Options, host string) *Options {
+	if opts.tlsConfig == nil || opts.tlsConfig.ServerName != "" {
+		return opts
+	}
+	o := *opts
+	o.tlsConfig = opts.tlsConfig.Clone()
+	o.tlsConfig.ServerName = host
+	return &o
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// cooldownCache 记录地址冷却结束的时间
+type cooldownCache struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// add 记录失败的地址,记录数达到上限时先清理过期的地址,仍然达到上限时移除最早到期的地址
+func (c *cooldownCache) add(addr string, cooldown time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if _, ok := c.until[addr]; !ok && len(c.until) >= maxCooldownEntries {
+		oldest := ""
+		for a, until := range c.until {
+			if now.After(until) {
+				delete(c.until, a)
+			} else if oldest == "" || until.Before(c.until[oldest]) {
+				oldest = a
+			}
+		}
+		if len(c.until) >= maxCooldownEntries {
+			delete(c.until, oldest)
+		}
+	}
+	c.until[addr] = now.Add(cooldown)
+}
+
+func (c *cooldownCache) remove(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.until, addr)
+}
+
+func (c *cooldownCache) cooling(addr string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.until[addr]
+	if ok && time.Now().After(until) {
+		delete(c.until, addr)
+		return false
+	}
+	return ok
+}
+
+// sort 把冷却中的地址移到最后,保持原有顺序
+func (c *cooldownCache) sort(candidates []candidate) []candidate {
+	sorted := make([]candidate, 0, len(candidates))
+	var cooling []candidate
+	for _, candidate := range candidates {
+		if c.cooling(candidate.addr) {
+			cooling = append(cooling, candidate)
+		} else {
+			sorted = append(sorted, candidate)
+		}
+	}
+	return append(sorted, cooling...)
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// closedAddr 返回一个没有监听的地址
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	return addr
+}
+
+func TestMultiConnectionFallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	dead := closedAddr(t)
+	conn, addr, err := NewMultiConnection([]string{dead, ln.Addr().String()}, WithFallbackDelay(-1))
+	require.NoError(t, err)
+	_ = conn.Close()
+	require.Equal(t, ln.Addr().String(), addr)
+
+	// 失败的地址进入冷却,排在其他地址之后
+	require.True(t, failures.cooling(dead))
+	candidates := failures.sort([]candidate{{addr: dead}, {addr: ln.Addr().String()}})
+	require.Equal(t, ln.Addr().String(), candidates[0].addr)
+
+	// 主机名解析为多个地址时逐个尝试
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	conn, addr, err = NewMultiConnection([]string{net.JoinHostPort("localhost", port)}, WithNetwork("tcp"))
+	require.NoError(t, err)
+	_ = conn.Close()
+	require.Equal(t, ln.Addr().String(), addr)
+
+	// 全部失败
+	_, _, err = NewMultiConnection([]string{closedAddr(t), closedAddr(t)})
+	require.Error(t, err)
+}
+
+func TestMultiConnectionRace(t *testing.T) {
+	// 接受连接但不完成tls握手的地址
+	slow, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer slow.Close()
+	go func() {
+		for {
+			conn, err := slow.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	start := time.Now()
+	conn, addr, err := NewMultiConnection(
+		[]string{slow.Addr().String(), server.Listener.Addr().String()},
+		WithTLSConfig(&tls.Config{RootCAs: pool, ServerName: "example.com"}),
+		WithFallbackDelay(50*time.Millisecond),
+		WithDialTimeout(5*time.Second),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, server.Listener.Addr().String(), addr)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestInterleave(t *testing.T) {
+	addrs := []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("::1")},
+	}
+	require.Equal(t, []net.IP{net.ParseIP("::1"), net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4()},
+		toIP4(interleave(addrs, "tcp")))
+	require.Len(t, interleave(addrs, "tcp4"), 2)
+	require.Len(t, interleave(addrs, "tcp6"), 1)
+}
+
+func toIP4(ips []net.IP) []net.IP {
+	for i, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			ips[i] = ip4
+		}
+	}
+	return ips
+}
+
+func TestCooldownCacheBounded(t *testing.T) {
+	c := &cooldownCache{until: make(map[string]time.Time)}
+	// 过期的地址在记录数达到上限时被清理
+	for i := 0; i < maxCooldownEntries; i++ {
+		c.add(fmt.Sprintf("expired:%v", i), -time.Second)
+	}
+	c.add("live:0", time.Minute)
+	require.Len(t, c.until, 1)
+
+	// 全部未过期时移除最早到期的地址
+	for i := 1; i < maxCooldownEntries+10; i++ {
+		c.add(fmt.Sprintf("live:%v", i), time.Minute+time.Duration(i)*time.Millisecond)
+	}
+	require.Len(t, c.until, maxCooldownEntries)
+	require.False(t, c.cooling("live:0"))
+	require.True(t, c.cooling(fmt.Sprintf("live:%v", maxCooldownEntries+9)))
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WithLocalAddr 设置数据包连接的本地地址
+// unixgram未设置时在临时目录创建socket文件,连接关闭时删除
+func WithLocalAddr(addr string) Option {
+	return func(opts *Options) {
+		opts.localAddr = addr
+	}
+}
+
+// NewPacketConnection 建立数据包连接,每次Write发送一个数据包,每次Read读取一个数据包
+// network通过WithNetwork设置,默认udp
+func NewPacketConnection(endpoint string, options ...Option) (net.Conn, error) {
+	opts := loadOptions(append([]Option{WithNetwork("udp")}, options...)...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.dialTimeout)
+	defer cancel()
+
+	switch opts.network {
+	case "udp", "udp4", "udp6":
+		dialer := net.Dialer{}
+		if opts.localAddr != "" {
+			laddr, err := net.ResolveUDPAddr(opts.network, opts.localAddr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "无效的本地地址 [address=%v]", opts.localAddr)
+			}
+			dialer.LocalAddr = laddr
+		}
+		conn, err := dialer.DialContext(ctx, opts.network, endpoint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "无法连接服务器 [endpoint=%v]", endpoint)
+		}
+		return conn, nil
+	case "unixgram":
+		return dialUnixgram(endpoint, opts)
+	default:
+		return nil, net.UnknownNetworkError(opts.network)
+	}
+}
+
+func dialUnixgram(endpoint string, opts *Options) (net.Conn, error) {
+	// 服务端需要知道本地地址才能回复
+	localPath, temporary := opts.localAddr, false
+	if localPath == "" {
+		localPath = filepath.Join(os.TempDir(), fmt.Sprintf("netbase-%d-%d.sock", os.Getpid(), rand.Int63()))
+		temporary = true
+	}
+
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: localPath, Net: "unixgram"},
+		&net.UnixAddr{Name: endpoint, Net: "unixgram"},
+	)
+	if err != nil {
+		if temporary {
+			_ = os.Remove(localPath)
+		}
+		return nil, errors.Wrapf(err, "无法连接服务器 [endpoint=%v]", endpoint)
+	}
+	if !temporary {
+		return conn, nil
+	}
+	return &unixgramConn{UnixConn: conn, path: localPath}, nil
+}
+
+// unixgramConn 关闭时删除临时socket文件
+type unixgramConn struct {
+	*net.UnixConn
+	path      string
+	closeOnce sync.Once
+}
+
+func (c *unixgramConn) Close() error {
+	err := c.UnixConn.Close()
+	c.closeOnce.Do(func() {
+		_ = os.Remove(c.path)
+	})
+	return err
+}
@@ -0,0 +1,452 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SOCKS5协议常量
+const (
+	socks5Version        = 0x05
+	socks5AuthNone       = 0x00
+	socks5AuthPassword   = 0x02
+	socks5AuthNoAccept   = 0xff
+	socks5PasswordVer    = 0x01
+	socks5CmdConnect     = 0x01
+	socks5CmdBind        = 0x02
+	socks5AddrIPv4       = 0x01
+	socks5AddrDomain     = 0x03
+	socks5AddrIPv6       = 0x04
+	socks5ReplySucceeded = 0x00
+)
+
+var socks5Replies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// WithProxy 通过代理建立连接,支持socks5://、socks5h://和http://(CONNECT),格式为scheme://[user:pass@]host:port
+// socks5在本地解析目标主机名,socks5h和http由代理解析;与WithTLSConfig同时使用时tls在隧道内与目标端到端握手
+// 通过SOCKS5代理监听使用ListenProxy
+func WithProxy(proxyURL string) Option {
+	return func(opts *Options) {
+		opts.proxy = proxyURL
+	}
+}
+
+// ListenProxy 通过WithProxy设置的SOCKS5代理监听(BIND命令),http代理不支持监听
+// 返回的listener的Addr为代理上的监听地址,只接受一个连接,之后Accept返回net.ErrClosed;
+// endpoint为预期连入的地址,代理可能据此检查连入的连接,未知时可以为"0.0.0.0:0"
+func ListenProxy(endpoint string, options ...Option) (net.Listener, error) {
+	opts := loadOptions(options...)
+	if opts.proxy == "" {
+		return nil, errors.Errorf("没有设置代理")
+	}
+	ctx := context.Background()
+	if opts.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.dialTimeout)
+		defer cancel()
+	}
+	dialer := net.Dialer{
+		KeepAlive: opts.dialKeepAlive,
+	}
+
+	var listener *proxyListener
+	err := handshakeProxy(ctx, &dialer, endpoint, opts, true, func(conn net.Conn, u *url.URL, target string) (err error) {
+		listener, err = socks5Bind(conn, u, target)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
+
+// dialProxy 连接代理并建立到endpoint的隧道
+func dialProxy(ctx context.Context, dialer *net.Dialer, endpoint string, opts *Options) (net.Conn, error) {
+	var tunnel net.Conn
+	err := handshakeProxy(ctx, dialer, endpoint, opts, false, func(conn net.Conn, u *url.URL, target string) (err error) {
+		if u.Scheme == "http" {
+			tunnel, err = httpConnect(conn, u, target)
+		} else {
+			tunnel, err = socks5Connect(conn, u, target)
+		}
+		return err
+	})
+	return tunnel, err
+}
+
+// handshakeProxy 连接代理并执行handshake,握手受ctx限制,失败时关闭代理连接
+// listen为true时只支持SOCKS5代理
+func handshakeProxy(ctx context.Context, dialer *net.Dialer, endpoint string, opts *Options, listen bool,
+	handshake func(conn net.Conn, u *url.URL, target string) error) error {
+	if opts.network == "unix" {
+		return errors.Errorf("unix socket不支持代理")
+	}
+	u, err := url.Parse(opts.proxy)
+	if err != nil {
+		return errors.Wrapf(err, "无效的代理地址 [proxy=%v]", opts.proxy)
+	}
+	defaultPort := ""
+	switch {
+	case u.Scheme == "socks5" || u.Scheme == "socks5h":
+		defaultPort = "1080"
+	case u.Scheme == "http" && !listen:
+		defaultPort = "80"
+	case u.Scheme == "http":
+		return errors.Errorf("http代理不支持监听 [proxy=%v]", u.Redacted())
+	default:
+		return errors.Errorf("不支持的代理协议 [proxy=%v]", u.Redacted())
+	}
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		proxyAddr = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	target := endpoint
+	if u.Scheme == "socks5" {
+		if target, err = resolveEndpoint(ctx, endpoint); err != nil {
+			return err
+		}
+	}
+
+	conn, err := dialer.DialContext(ctx, opts.network, proxyAddr)
+	if err != nil {
+		return errors.Wrapf(err, "无法连接代理 [proxy=%v]", u.Redacted())
+	}
+	// 握手受拨号超时限制
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	err = handshake(conn, u, target)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		if listen {
+			return errors.Wrapf(err, "无法通过代理监听 [proxy=%v, endpoint=%v]", u.Redacted(), endpoint)
+		}
+		return errors.Wrapf(err, "无法通过代理连接服务器 [proxy=%v, endpoint=%v]", u.Redacted(), endpoint)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return nil
+}
+
+// resolveEndpoint 在本地解析endpoint的主机名,返回第一个地址
+func resolveEndpoint(ctx context.Context, endpoint string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || net.ParseIP(host) != nil {
+		return endpoint, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", errors.Wrapf(err, "无法解析主机名 [endpoint=%v]", endpoint)
+	}
+	return net.JoinHostPort(addrs[0].IP.String(), port), nil
+}
+
+// proxyResolvesHost 是否由代理解析目标主机名
+func proxyResolvesHost(proxy string) bool {
+	if proxy == "" {
+		return false
+	}
+	u, err := url.Parse(proxy)
+	return err != nil || u.Scheme != "socks5"
+}
+
+func socks5Connect(conn net.Conn, u *url.URL, endpoint string) (net.Conn, error) {
+	if err := socks5Negotiate(conn, u); err != nil {
+		return nil, err
+	}
+	if _, err := socks5Request(conn, socks5CmdConnect, endpoint); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// socks5Bind 请求代理监听,返回的listener等待代理的第二个响应
+func socks5Bind(conn net.Conn, u *url.URL, endpoint string) (*proxyListener, error) {
+	if err := socks5Negotiate(conn, u); err != nil {
+		return nil, err
+	}
+	bound, err := socks5Request(conn, socks5CmdBind, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// 代理返回未指定的地址时使用代理自身的地址
+	if addr, ok := bound.(*net.TCPAddr); ok && addr.IP.IsUnspecified() {
+		if proxy, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			addr.IP = proxy.IP
+		}
+	}
+	return &proxyListener{conn: conn, addr: bound}, nil
+}
+
+// socks5Negotiate 协商认证方式并认证
+func socks5Negotiate(conn net.Conn, u *url.URL) error {
+	methods := []byte{socks5AuthNone}
+	if u.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return errors.Errorf("无效的SOCKS版本 [version=%v]", reply[0])
+	}
+	switch reply[1] {
+	case socks5AuthNone:
+		return nil
+	case socks5AuthPassword:
+		if u.User == nil {
+			return errors.Errorf("代理要求认证")
+		}
+		return socks5Authenticate(conn, u.User)
+	case socks5AuthNoAccept:
+		return errors.Errorf("代理不接受认证方式")
+	default:
+		return errors.Errorf("不支持的认证方式 [method=%v]", reply[1])
+	}
+}
+
+// socks5Request 发送命令并读取响应,返回响应中的地址
+func socks5Request(conn net.Conn, cmd byte, endpoint string) (net.Addr, error) {
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errors.Errorf("无效的端口 [endpoint=%v]", endpoint)
+	}
+
+	req := []byte{socks5Version, cmd, 0}
+	if host == "" {
+		host = net.IPv4zero.String()
+	}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, errors.Errorf("主机名过长 [host=%v]", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5AddrIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5AddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err = conn.Write(req); err != nil {
+		return nil, err
+	}
+	return socks5ReadReply(conn)
+}
+
+// socks5ReadReply 读取响应: | 版本 | 状态 | 保留 | 地址类型 | 地址 | 端口 |
+func socks5ReadReply(conn net.Conn) (net.Addr, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return nil, err
+	}
+	if head[1] != socks5ReplySucceeded {
+		msg, ok := socks5Replies[head[1]]
+		if !ok {
+			msg = "unknown error"
+		}
+		return nil, errors.Errorf("代理拒绝连接 [reply=%v]", msg)
+	}
+	var size int
+	switch head[3] {
+	case socks5AddrIPv4:
+		size = net.IPv4len
+	case socks5AddrIPv6:
+		size = net.IPv6len
+	case socks5AddrDomain:
+		var b [1]byte
+		if _, err := io.ReadFull(conn, b[:]); err != nil {
+			return nil, err
+		}
+		size = int(b[0])
+	default:
+		return nil, errors.Errorf("无效的地址类型 [type=%v]", head[3])
+	}
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	port := int(binary.BigEndian.Uint16(buf[size:]))
+	if head[3] == socks5AddrDomain {
+		return &endpointAddr{network: "tcp", address: net.JoinHostPort(string(buf[:size]), strconv.Itoa(port))}, nil
+	}
+	return &net.TCPAddr{IP: net.IP(buf[:size]), Port: port}, nil
+}
+
+// proxyListener 通过SOCKS5 BIND在代理上的监听,只接受一个连接
+type proxyListener struct {
+	conn net.Conn
+	addr net.Addr
+
+	mu sync.Mutex
+	// Accept已经调用
+	accepting bool
+	// 连接已经交给调用方或listener已关闭
+	done bool
+}
+
+// Accept 等待代理发送连入的连接,只能成功一次
+func (l *proxyListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.accepting || l.done {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	l.accepting = true
+	l.mu.Unlock()
+
+	remote, err := socks5ReadReply(l.conn)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return nil, net.ErrClosed
+	}
+	l.done = true
+	if err != nil {
+		_ = l.conn.Close()
+		return nil, errors.Wrapf(err, "代理没有接受连接 [addr=%v]", l.addr)
+	}
+	return &boundConn{ConnReadWriteCloser: utils.ToConnReadWriteCloser(l.conn), remote: remote}, nil
+}
+
+// Close 关闭listener,已经接受的连接不受影响
+func (l *proxyListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return nil
+	}
+	l.done = true
+	return l.conn.Close()
+}
+
+func (l *proxyListener) Addr() net.Addr {
+	return l.addr
+}
+
+// boundConn 通过代理连入的连接,RemoteAddr为连入方的地址
+type boundConn struct {
+	utils.ConnReadWriteCloser
+	remote net.Addr
+}
+
+func (c *boundConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// socks5Authenticate 用户名密码认证,RFC 1929
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.Errorf("用户名或密码过长")
+	}
+	req := []byte{socks5PasswordVer, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.Errorf("代理认证失败 [user=%v]", username)
+	}
+	return nil
+}
+
+func httpConnect(conn net.Conn, u *url.URL, endpoint string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: endpoint},
+		Host:   endpoint,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credential := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credential)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("代理拒绝连接 [status=%v]", resp.Status)
+	}
+	// 代理可能已经转发了目标发送的数据
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn 先读取缓存中的数据,保留底层连接的半关闭
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *bufferedConn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return utils.ErrHalfCloseUnsupported
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return utils.ErrHalfCloseUnsupported
+}
@@ -0,0 +1,318 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase/internal/nettest"
+	"github.com/stretchr/testify/require"
+)
+
+// pipe 双向转发直到任一方向结束
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	_ = a.Close()
+	_ = b.Close()
+}
+
+// socks5Proxy 只支持CONNECT和用户名密码认证的SOCKS5代理,返回地址和请求的目标
+func socks5Proxy(t *testing.T, username, password string) (string, *atomic.Value) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	target := new(atomic.Value)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, username, password, target)
+		}
+	}()
+	return ln.Addr().String(), target
+}
+
+func serveSOCKS5(conn net.Conn, username, password string, target *atomic.Value) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return
+	}
+	_, _ = conn.Write([]byte{5, 2})
+
+	// 用户名密码
+	ver, _ := r.ReadByte()
+	ulen, _ := r.ReadByte()
+	user := make([]byte, ulen)
+	_, _ = io.ReadFull(r, user)
+	plen, _ := r.ReadByte()
+	pass := make([]byte, plen)
+	_, _ = io.ReadFull(r, pass)
+	if ver != 1 || string(user) != username || string(pass) != password {
+		_, _ = conn.Write([]byte{1, 1})
+		return
+	}
+	_, _ = conn.Write([]byte{1, 0})
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		_, _ = io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		size, _ := r.ReadByte()
+		name := make([]byte, size)
+		_, _ = io.ReadFull(r, name)
+		host = string(name)
+	case 4:
+		ip := make([]byte, 16)
+		_, _ = io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	}
+	port := make([]byte, 2)
+	_, _ = io.ReadFull(r, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	target.Store(addr)
+
+	if req[1] == 2 {
+		serveBind(conn, r)
+		return
+	}
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(&bufferedConn{Conn: conn, reader: r}, upstream)
+}
+
+// serveBind 在127.0.0.1上监听,依次回复监听地址和连入方的地址后转发
+func serveBind(conn net.Conn, r *bufio.Reader) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer ln.Close()
+	reply := func(addr net.Addr) error {
+		tcp := addr.(*net.TCPAddr)
+		_, err := conn.Write(binary.BigEndian.AppendUint16(append([]byte{5, 0, 0, 1}, tcp.IP.To4()...), uint16(tcp.Port)))
+		return err
+	}
+	// 回复未指定的地址,由客户端替换为代理的地址
+	if reply(&net.TCPAddr{IP: net.IPv4zero, Port: ln.Addr().(*net.TCPAddr).Port}) != nil {
+		return
+	}
+	peer, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	if reply(peer.RemoteAddr()) != nil {
+		_ = peer.Close()
+		return
+	}
+	pipe(&bufferedConn{Conn: conn, reader: r}, peer)
+}
+
+// httpProxy 要求基本认证的HTTP CONNECT代理
+func httpProxy(t *testing.T, username, password string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := parseProxyAuth(r)
+		if !ok || user != username || pass != password {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = upstream.Close()
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		pipe(&bufferedConn{Conn: conn, reader: rw.Reader}, upstream)
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func parseProxyAuth(r *http.Request) (string, string, bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		return "", "", false
+	}
+	req := &http.Request{Header: http.Header{"Authorization": {auth}}}
+	return req.BasicAuth()
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	target := nettest.EchoServer(t)
+	proxyAddr, requested := socks5Proxy(t, "user", "secret")
+
+	conn, err := NewTCPConnection(target, WithProxy("socks5://user:secret@"+proxyAddr))
+	require.NoError(t, err)
+	defer conn.Close()
+	nettest.RoundTripEcho(t, conn)
+	require.Equal(t, target, requested.Load())
+
+	// socks5h的主机名由代理解析
+	_, port, _ := net.SplitHostPort(target)
+	conn, err = NewTCPConnection(net.JoinHostPort("localhost", port), WithProxy("socks5h://user:secret@"+proxyAddr))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, net.JoinHostPort("localhost", port), requested.Load())
+
+	// socks5在本地解析主机名,localhost可能解析为代理连接不到的::1,只检查请求的地址
+	conn, err = NewTCPConnection(net.JoinHostPort("localhost", port), WithProxy("socks5://user:secret@"+proxyAddr))
+	if err == nil {
+		defer conn.Close()
+	}
+	host, _, err := net.SplitHostPort(requested.Load().(string))
+	require.NoError(t, err)
+	require.True(t, net.ParseIP(host).IsLoopback())
+
+	_, err = NewTCPConnection(target, WithProxy("socks5://user:wrong@"+proxyAddr))
+	require.Error(t, err)
+}
+
+func TestListenProxy(t *testing.T) {
+	proxyAddr, requested := socks5Proxy(t, "user", "secret")
+	ln, err := ListenProxy("0.0.0.0:0", WithProxy("socks5://user:secret@"+proxyAddr))
+	require.NoError(t, err)
+	defer ln.Close()
+	require.Equal(t, "0.0.0.0:0", requested.Load())
+	addr := ln.Addr().(*net.TCPAddr)
+	require.True(t, addr.IP.IsLoopback())
+
+	peer, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer peer.Close()
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, peer.LocalAddr().String(), conn.RemoteAddr().String())
+
+	_, err = peer.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(peer, buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf))
+
+	// 只接受一个连接,关闭listener不影响已接受的连接
+	_, err = ln.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.NoError(t, ln.Close())
+	_, err = conn.Write([]byte("more"))
+	require.NoError(t, err)
+
+	_, err = ListenProxy("0.0.0.0:0", WithProxy("http://"+proxyAddr))
+	require.ErrorContains(t, err, "不支持监听")
+}
+
+func TestListenProxyClose(t *testing.T) {
+	proxyAddr, _ := socks5Proxy(t, "user", "secret")
+	ln, err := ListenProxy("0.0.0.0:0", WithProxy("socks5h://user:secret@"+proxyAddr))
+	require.NoError(t, err)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, ln.Close())
+	select {
+	case err = <-errc:
+		require.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Accept not interrupted by Close")
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	target := nettest.EchoServer(t)
+	proxyAddr := httpProxy(t, "user", "secret")
+
+	conn, err := NewTCPConnection(target, WithProxy("http://user:secret@"+proxyAddr))
+	require.NoError(t, err)
+	defer conn.Close()
+	nettest.RoundTripEcho(t, conn)
+
+	_, err = NewTCPConnection(target, WithProxy("http://"+proxyAddr))
+	require.ErrorContains(t, err, "407")
+
+	_, err = NewTCPConnection(target, WithProxy("ftp://"+proxyAddr))
+	require.Error(t, err)
+}
+
+func TestProxyTLS(t *testing.T) {
+	// tls在隧道内与目标握手,代理只转发密文
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secure"))
+	}))
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	socksAddr, _ := socks5Proxy(t, "user", "secret")
+	for _, proxyURL := range []string{
+		"socks5://user:secret@" + socksAddr,
+		"http://user:secret@" + httpProxy(t, "user", "secret"),
+	} {
+		conn, err := NewTCPConnection(server.Listener.Addr().String(),
+			WithProxy(proxyURL),
+			WithTLSConfig(&tls.Config{RootCAs: pool}),
+		)
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodGet, "https://"+server.Listener.Addr().String(), nil)
+		require.NoError(t, req.Write(conn))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "secure", string(body))
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"github.com/lngwu11/toolgo/netbase/proxyproto"
+	"github.com/pkg/errors"
+	"net"
+)
+
+// WithProxyHeader 连接建立后先发送PROXY协议头部,用于代理把原始连接的地址传给服务端
+// 头部的地址为nil时使用本连接的本地地址和远端地址
+func WithProxyHeader(header *proxyproto.Header) Option {
+	return func(opts *Options) {
+		opts.proxyHeader = header
+	}
+}
+
+func writeProxyHeader(conn net.Conn, header *proxyproto.Header) error {
+	h := *header
+	if h.SourceAddr == nil && h.DestinationAddr == nil {
+		h.SourceAddr, h.DestinationAddr = conn.LocalAddr(), conn.RemoteAddr()
+	}
+	if _, err := h.WriteTo(conn); err != nil {
+		return errors.Wrapf(err, "无法发送PROXY协议头部 [remote=%v]", conn.RemoteAddr())
+	}
+	return nil
+}
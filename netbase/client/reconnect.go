@@ -0,0 +1,455 @@
+package client
+
+import (
+	"context"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认最小重连间隔
+	defaultMinBackoff = 100 * time.Millisecond
+	// 默认最大重连间隔
+	defaultMaxBackoff = 30 * time.Second
+	// 连接保持超过该时间后断开才重置重连间隔
+	stableConnDuration = 5 * time.Second
+)
+
+// ErrConnClosed 连接已关闭
+var ErrConnClosed = errors.New("reconnecting connection closed")
+
+// ConnState 重连连接的状态
+type ConnState int
+
+const (
+	StateConnecting ConnState = iota
+	StateConnected
+	StateDisconnected
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// HandshakeFunc 每次连接建立后调用,返回错误时关闭连接并重连
+type HandshakeFunc func(conn utils.ConnReadWriteCloser) error
+
+// StateListener 状态变化通知,err为进入该状态的原因,可能为nil
+// 同步调用,不能阻塞
+type StateListener func(state ConnState, err error)
+
+// WithReconnectBackoff 设置重连间隔,从min开始指数增长到max,实际间隔在[d/2, d]之间随机
+// 连接建立后很快断开(如服务端接受后立即关闭)也按重连间隔等待,保持5s以上才重置为min
+// 默认100ms到30s
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(opts *Options) {
+		opts.minBackoff = min
+		opts.maxBackoff = max
+	}
+}
+
+// WithHandshake 设置每次连接建立后执行的握手函数
+func WithHandshake(handshake HandshakeFunc) Option {
+	return func(opts *Options) {
+		opts.handshake = handshake
+	}
+}
+
+// WithStateListener 设置状态变化通知
+func WithStateListener(listener StateListener) Option {
+	return func(opts *Options) {
+		opts.stateListener = listener
+	}
+}
+
+var _ utils.ConnReadWriteCloser = (*ReconnectingConn)(nil)
+
+// ReconnectingConn 断线自动重连的连接
+// 读写出错时关闭当前连接并在后台重连,错误仍返回给调用者,读写超时不触发重连;
+// 重连期间的读写阻塞到连接建立、截止时间或Close。
+// 截止时间同时作用于之后建立的连接,半关闭只作用于当前连接
+type ReconnectingConn struct {
+	endpoint string
+	opts     *Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	conn   utils.ConnReadWriteCloser
+	ready  chan struct{}
+	lost   chan struct{}
+	state  ConnState
+	closed bool
+	// 最近建立的连接,用于获取地址
+	last utils.ConnReadWriteCloser
+	// 调用者设置的截止时间
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// 下一次重连的间隔,只在run中使用
+	backoff time.Duration
+}
+
+// NewReconnectingConn 创建重连连接,立即在后台开始连接
+func NewReconnectingConn(endpoint string, options ...Option) *ReconnectingConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ReconnectingConn{
+		endpoint: endpoint,
+		opts:     loadOptions(options...),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		ready:    make(chan struct{}),
+		// 使首次进入connecting状态时也会通知
+		state: StateDisconnected,
+	}
+	c.backoff = c.opts.minBackoff
+	go c.run()
+	return c
+}
+
+// State 返回当前状态
+func (c *ReconnectingConn) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Conn 返回当前连接,连接未建立时等待到建立、ctx取消或Close
+func (c *ReconnectingConn) Conn(ctx context.Context) (utils.ConnReadWriteCloser, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return nil, ErrConnClosed
+		}
+		if c.conn != nil {
+			conn := c.conn
+			c.mu.Unlock()
+			return conn, nil
+		}
+		ready := c.ready
+		c.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.ctx.Done():
+			return nil, ErrConnClosed
+		}
+	}
+}
+
+// waitConn 等待连接建立,deadline不为零时最多等待到deadline
+func (c *ReconnectingConn) waitConn(deadline time.Time) (utils.ConnReadWriteCloser, error) {
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	conn, err := c.Conn(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = os.ErrDeadlineExceeded
+	}
+	return conn, err
+}
+
+// Read 从当前连接读取
+func (c *ReconnectingConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	conn, err := c.waitConn(deadline)
+	if err != nil {
+		return 0, err
+	}
+	n, err := conn.Read(p)
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		c.invalidate(conn, err)
+	}
+	return n, err
+}
+
+// Write 写入当前连接,出错时已写入的数据可能丢失
+func (c *ReconnectingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+	conn, err := c.waitConn(deadline)
+	if err != nil {
+		return 0, err
+	}
+	n, err := conn.Write(p)
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		c.invalidate(conn, err)
+	}
+	return n, err
+}
+
+// CloseRead 关闭当前连接的读方向,重连后的连接不受影响
+func (c *ReconnectingConn) CloseRead() error {
+	conn, err := c.current()
+	if err != nil {
+		return err
+	}
+	return conn.CloseRead()
+}
+
+// CloseWrite 关闭当前连接的写方向,重连后的连接不受影响
+func (c *ReconnectingConn) CloseWrite() error {
+	conn, err := c.current()
+	if err != nil {
+		return err
+	}
+	return conn.CloseWrite()
+}
+
+// current 返回当前连接,不等待重连
+func (c *ReconnectingConn) current() (utils.ConnReadWriteCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrConnClosed
+	}
+	if c.conn == nil {
+		return nil, errors.Errorf("连接未建立 [endpoint=%v]", c.endpoint)
+	}
+	return c.conn, nil
+}
+
+// LocalAddr 返回最近建立的连接的本地地址,没有建立过连接时返回空地址
+func (c *ReconnectingConn) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return &endpointAddr{network: c.opts.network}
+	}
+	return c.last.LocalAddr()
+}
+
+// RemoteAddr 返回最近建立的连接的远端地址,没有建立过连接时返回连接的endpoint
+func (c *ReconnectingConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return &endpointAddr{network: c.opts.network, address: c.endpoint}
+	}
+	return c.last.RemoteAddr()
+}
+
+func (c *ReconnectingConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *ReconnectingConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	if c.conn != nil {
+		return c.conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *ReconnectingConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	if c.conn != nil {
+		return c.conn.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// endpointAddr 没有建立连接时使用的地址
+type endpointAddr struct {
+	network string
+	address string
+}
+
+func (a *endpointAddr) Network() string {
+	return a.network
+}
+
+func (a *endpointAddr) String() string {
+	return a.address
+}
+
+// Reconnect 主动断开当前连接并重连,如协议出错时
+func (c *ReconnectingConn) Reconnect() {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.invalidate(conn, nil)
+	}
+}
+
+// Close 关闭连接并停止重连
+func (c *ReconnectingConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+// invalidate 连接出错时调用,conn仍是当前连接时关闭并触发重连
+func (c *ReconnectingConn) invalidate(conn utils.ConnReadWriteCloser, err error) {
+	c.mu.Lock()
+	if c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	c.ready = make(chan struct{})
+	lost := c.lost
+	c.mu.Unlock()
+
+	_ = conn.Close()
+	c.setState(StateDisconnected, err)
+	close(lost)
+}
+
+func (c *ReconnectingConn) setState(state ConnState, err error) {
+	c.mu.Lock()
+	if c.state == StateClosed || c.state == state {
+		c.mu.Unlock()
+		return
+	}
+	c.state = state
+	c.mu.Unlock()
+
+	if c.opts.stateListener != nil {
+		c.opts.stateListener(state, err)
+	}
+}
+
+func (c *ReconnectingConn) run() {
+	defer close(c.done)
+	defer c.setState(StateClosed, nil)
+
+	for {
+		conn := c.connect()
+		if conn == nil {
+			return
+		}
+
+		lost := make(chan struct{})
+		c.mu.Lock()
+		_ = conn.SetReadDeadline(c.readDeadline)
+		_ = conn.SetWriteDeadline(c.writeDeadline)
+		c.conn = conn
+		c.last = conn
+		c.lost = lost
+		close(c.ready)
+		c.mu.Unlock()
+		c.setState(StateConnected, nil)
+		connected := time.Now()
+
+		select {
+		case <-lost:
+		case <-c.ctx.Done():
+			c.mu.Lock()
+			c.conn = nil
+			c.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+
+		if time.Since(connected) >= stableConnDuration {
+			c.backoff = c.opts.minBackoff
+		} else if !c.sleepBackoff() {
+			return
+		}
+	}
+}
+
+// connect 按退避间隔拨号直到成功,Close时返回nil
+func (c *ReconnectingConn) connect() utils.ConnReadWriteCloser {
+	for {
+		c.setState(StateConnecting, nil)
+		conn, err := c.dialOnce()
+		if err == nil {
+			return conn
+		}
+		if c.ctx.Err() != nil {
+			return nil
+		}
+		c.setState(StateDisconnected, err)
+		if !c.sleepBackoff() {
+			return nil
+		}
+	}
+}
+
+// sleepBackoff 等待重连间隔后把间隔加倍,Close时返回false
+func (c *ReconnectingConn) sleepBackoff() bool {
+	timer := time.NewTimer(jitter(c.backoff))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.ctx.Done():
+		return false
+	}
+	if c.backoff *= 2; c.backoff > c.opts.maxBackoff {
+		c.backoff = c.opts.maxBackoff
+	}
+	return true
+}
+
+func (c *ReconnectingConn) dialOnce() (utils.ConnReadWriteCloser, error) {
+	conn, err := dial(c.ctx, c.endpoint, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.handshake != nil {
+		// Close时关闭连接以中断握手
+		stop := context.AfterFunc(c.ctx, func() { _ = conn.Close() })
+		defer stop()
+		if err = c.opts.handshake(conn); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrapf(err, "握手失败 [endpoint=%v]", c.endpoint)
+		}
+	}
+	return conn, nil
+}
+
+// jitter 返回[d/2, d]之间的随机时间
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectingConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// 第一个连接读到数据后立即断开,之后的连接回显
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if i == 0 {
+				buf := make([]byte, 1)
+				_, _ = conn.Read(buf)
+				_ = conn.Close()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	var handshakes int32
+	states := make(chan ConnState, 16)
+	c := NewReconnectingConn(ln.Addr().String(),
+		WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond),
+		WithHandshake(func(conn utils.ConnReadWriteCloser) error {
+			atomic.AddInt32(&handshakes, 1)
+			return nil
+		}),
+		WithStateListener(func(state ConnState, err error) {
+			states <- state
+		}),
+	)
+
+	_, err = c.Write([]byte("x"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = c.Read(buf)
+	require.Error(t, err)
+
+	// 重连后可以继续使用
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.Conn(ctx)
+	require.NoError(t, err)
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	require.EqualValues(t, 2, atomic.LoadInt32(&handshakes))
+
+	require.NoError(t, c.Close())
+	require.Equal(t, StateClosed, c.State())
+	_, err = c.Write([]byte("x"))
+	require.ErrorIs(t, err, ErrConnClosed)
+
+	close(states)
+	var got []ConnState
+	for state := range states {
+		got = append(got, state)
+	}
+	require.Equal(t, []ConnState{
+		StateConnecting, StateConnected, StateDisconnected,
+		StateConnecting, StateConnected, StateClosed,
+	}, got)
+}
+
+func TestReconnectingConnBackoff(t *testing.T) {
+	// 没有服务端监听,一直重连直到Close
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	var failures int32
+	c := NewReconnectingConn(addr,
+		WithReconnectBackoff(5*time.Millisecond, 20*time.Millisecond),
+		WithStateListener(func(state ConnState, err error) {
+			if state == StateDisconnected {
+				require.Error(t, err)
+				atomic.AddInt32(&failures, 1)
+			}
+		}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = c.Conn(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, c.Close())
+	require.Greater(t, atomic.LoadInt32(&failures), int32(1))
+}
+
+func TestReconnectingConnDroppedByServer(t *testing.T) {
+	// 服务端接受连接后立即关闭
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			_ = conn.Close()
+		}
+	}()
+
+	c := NewReconnectingConn(ln.Addr().String(), WithReconnectBackoff(20*time.Millisecond, 100*time.Millisecond))
+	// 一直读取以发现连接断开
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := c.Read(buf); errors.Is(err, ErrConnClosed) {
+				return
+			}
+		}
+	}()
+	time.Sleep(300 * time.Millisecond)
+	require.NoError(t, c.Close())
+	// 按退避间隔重连,不会立即重连
+	require.Greater(t, atomic.LoadInt32(&accepted), int32(1))
+	require.Less(t, atomic.LoadInt32(&accepted), int32(10))
+}
+
+func TestReconnectingConnDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	c := NewReconnectingConn(ln.Addr().String())
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := c.Conn(ctx)
+	require.NoError(t, err)
+	require.Equal(t, conn.RemoteAddr(), c.RemoteAddr())
+	require.Equal(t, conn.LocalAddr(), c.LocalAddr())
+
+	// 读超时不触发重连
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	_, err = c.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, StateConnected, c.State())
+	require.NoError(t, c.SetReadDeadline(time.Time{}))
+
+	// 半关闭后对端回显的数据仍可以读取
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, c.CloseWrite())
+	got, err := io.ReadAll(c)
+	require.Equal(t, "ping", string(got))
+	require.NoError(t, err)
+}
@@ -1,7 +1,9 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
+	"github.com/lngwu11/toolgo/netbase/proxyproto"
 	"github.com/lngwu11/toolgo/utils"
 	"github.com/pkg/errors"
 	"net"
@@ -13,8 +15,6 @@ const (
 	defaultDialTimeout = 15 * time.Second
 	// 默认保活探测时间
 	defaultDialKeepAlive = 15 * time.Second
-	// 默认连接空闲超时时间
-	defaultConnIdleTimout = 60 * time.Second
 	// 默认network
 	defaultNetwork = "tcp"
 )
@@ -30,9 +30,25 @@ type Options struct {
 	dialKeepAlive time.Duration
 	// tls配置
 	tlsConfig *tls.Config
+	// 数据包连接的本地地址
+	localAddr string
+	// 代理地址
+	proxy string
+	// 连接建立后发送的PROXY协议头部
+	proxyHeader *proxyproto.Header
+	// 多地址拨号配置
+	dialStrategy    DialStrategy
+	fallbackDelay   time.Duration
+	failureCooldown time.Duration
+	// 重连配置
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	handshake     HandshakeFunc
+	stateListener StateListener
 }
 
-// WithNetwork .
+// WithNetwork 设置network,支持tcp、tcp4、tcp6和unix,默认tcp;
+// NewPacketConnection支持udp、udp4、udp6和unixgram,默认udp
 func WithNetwork(network string) Option {
 	return func(opts *Options) {
 		opts.network = network
@@ -46,7 +62,21 @@ func WithTLSConfig(tlsConfig *tls.Config) Option {
 	}
 }
 
-// WithIdleTimeout 。
+// WithDialTimeout 设置拨号超时时间,默认15s
+func WithDialTimeout(dialTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.dialTimeout = dialTimeout
+	}
+}
+
+// WithDialKeepAlive 设置tcp保活探测间隔,默认15s,负数表示关闭保活
+func WithDialKeepAlive(dialKeepAlive time.Duration) Option {
+	return func(opts *Options) {
+		opts.dialKeepAlive = dialKeepAlive
+	}
+}
+
+// WithIdleTimeout 设置连接空闲超时时间,连接超过该时间没有读写时关闭,默认不超时
 func WithIdleTimeout(idleTimeout time.Duration) Option {
 	return func(opts *Options) {
 		opts.idleTimeout = idleTimeout
@@ -61,60 +91,95 @@ func loadOptions(options ...Option) *Options {
 	if len(opts.network) == 0 {
 		opts.network = defaultNetwork
 	}
-	if opts.idleTimeout == 0 {
-		opts.idleTimeout = defaultConnIdleTimout
-	}
 	if opts.dialTimeout == 0 {
 		opts.dialTimeout = defaultDialTimeout
 	}
 	if opts.dialKeepAlive == 0 {
 		opts.dialKeepAlive = defaultDialKeepAlive
 	}
+	if opts.fallbackDelay == 0 {
+		opts.fallbackDelay = defaultFallbackDelay
+	}
+	if opts.failureCooldown == 0 {
+		opts.failureCooldown = defaultFailureCooldown
+	}
+	if opts.minBackoff <= 0 {
+		opts.minBackoff = defaultMinBackoff
+	}
+	if opts.maxBackoff < opts.minBackoff {
+		opts.maxBackoff = defaultMaxBackoff
+		if opts.maxBackoff < opts.minBackoff {
+			opts.maxBackoff = opts.minBackoff
+		}
+	}
 	return opts
 }
 
+// NewTCPConnection 建立流连接,通过WithNetwork("unix")连接unix socket
 func NewTCPConnection(endpoint string, options ...Option) (c utils.ConnReadWriteCloser, err error) {
-	opts := loadOptions(options...)
+	return dial(context.Background(), endpoint, loadOptions(options...))
+}
 
+func dial(ctx context.Context, endpoint string, opts *Options) (c utils.ConnReadWriteCloser, err error) {
 	switch opts.network {
-	case "tcp", "tcp4", "tcp6":
+	case "tcp", "tcp4", "tcp6", "unix":
 	default:
 		return nil, net.UnknownNetworkError(opts.network)
 	}
 
-	var conn net.Conn
+	if opts.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.dialTimeout)
+		defer cancel()
+	}
 
-	if opts.tlsConfig != nil {
-		// tls connection
-		dialer := tls.Dialer{
-			NetDialer: &net.Dialer{
-				Timeout:   opts.dialTimeout,
-				KeepAlive: opts.dialKeepAlive,
-				Deadline:  time.Now().Add(opts.idleTimeout),
-			},
-			Config: opts.tlsConfig,
-		}
+	dialer := net.Dialer{
+		KeepAlive: opts.dialKeepAlive,
+	}
+	var conn net.Conn
+	if opts.proxy != "" {
+		conn, err = dialProxy(ctx, &dialer, endpoint, opts)
+	} else {
+		conn, err = dialer.DialContext(ctx, opts.network, endpoint)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "无法连接服务器 [endpoint=%v]", endpoint)
+		return
+	}
 
-		conn, err = dialer.Dial(opts.network, endpoint)
-		if err != nil {
-			err = errors.Wrapf(err, "无法连接服务器 [endpoint=%v]", endpoint)
+	// PROXY协议头部在tls握手之前发送
+	if opts.proxyHeader != nil {
+		if err = writeProxyHeader(conn, opts.proxyHeader); err != nil {
+			_ = conn.Close()
 			return
 		}
-	} else {
-		// normal connection
-		dialer := net.Dialer{
-			Timeout:   opts.dialTimeout,
-			KeepAlive: opts.dialKeepAlive,
-			Deadline:  time.Now().Add(opts.idleTimeout),
-		}
+	}
 
-		conn, err = dialer.Dial(opts.network, endpoint)
-		if err != nil {
+	if opts.tlsConfig != nil {
+		// tls connection
+		config := opts.tlsConfig
+		if config.ServerName == "" {
+			// 与tls.Dialer一致,默认使用endpoint的主机名
+			config = config.Clone()
+			if host, _, splitErr := net.SplitHostPort(endpoint); splitErr == nil {
+				config.ServerName = host
+			} else {
+				config.ServerName = endpoint
+			}
+		}
+		tlsConn := tls.Client(conn, config)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
 			err = errors.Wrapf(err, "无法连接服务器 [endpoint=%v]", endpoint)
 			return
 		}
+		conn = tlsConn
 	}
 
-	c = conn.(utils.ConnReadWriteCloser)
+	// *tls.Conn没有CloseRead,不能直接断言
+	c = utils.ToConnReadWriteCloser(conn)
+	if opts.idleTimeout > 0 {
+		c = newIdleConn(c, opts.idleTimeout)
+	}
 	return
 }
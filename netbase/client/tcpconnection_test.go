@@ -0,0 +1,52 @@
+package client
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(conn, conn)
+		close(closed)
+	}()
+
+	conn, err := NewTCPConnection(ln.Addr().String(),
+		WithIdleTimeout(100*time.Millisecond),
+		WithDialTimeout(time.Second),
+		WithDialKeepAlive(-1),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// 持续有读写时不会超时
+	buf := make([]byte, 4)
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+	}
+
+	// 空闲超过超时时间后连接被关闭
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection not closed")
+	}
+	_, err = conn.Read(buf)
+	require.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package codec
+
+import (
+	"encoding/binary"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"io"
+)
+
+const (
+	// 默认长度字段字节数
+	defaultLengthFieldSize = 4
+	// 默认最大帧长度
+	defaultMaxFrameSize = 4 * 1024 * 1024
+)
+
+var (
+	// ErrFrameTooLarge 帧长度超过最大值
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrDelimiterInFrame 写入的帧包含分隔符
+	ErrDelimiterInFrame = errors.New("frame contains delimiter")
+)
+
+// Codec 在连接上读写完整的消息帧,不支持并发读或并发写
+// WriteFrame写入连接的缓存,需要调用Flush发送,
+// 连接不是utils.Flusher(如未经netbase.NewBufferConnection包装)时Flush为空操作
+type Codec interface {
+	// ReadFrame 读取一个完整帧,返回的数据归调用方所有
+	ReadFrame() ([]byte, error)
+	// WriteFrame 写入一个完整帧
+	WriteFrame(frame []byte) error
+	// Flush 发送已写入的帧
+	Flush() error
+}
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 长度字段字节数,1/2/4/8
+	lengthFieldSize int
+	// 长度字段字节序
+	byteOrder binary.ByteOrder
+	// 最大帧长度
+	maxFrameSize int
+	// 每次WriteFrame后自动Flush
+	autoFlush bool
+}
+
+// WithLengthFieldSize 设置长度字段字节数,仅支持1/2/4/8
+func WithLengthFieldSize(size int) Option {
+	return func(opts *Options) {
+		opts.lengthFieldSize = size
+	}
+}
+
+// WithByteOrder 设置长度字段字节序,默认binary.BigEndian
+func WithByteOrder(order binary.ByteOrder) Option {
+	return func(opts *Options) {
+		opts.byteOrder = order
+	}
+}
+
+// WithMaxFrameSize 设置最大帧长度,读写超过时返回ErrFrameTooLarge
+func WithMaxFrameSize(size int) Option {
+	return func(opts *Options) {
+		opts.maxFrameSize = size
+	}
+}
+
+// WithAutoFlush 每次WriteFrame后自动Flush
+func WithAutoFlush(autoFlush bool) Option {
+	return func(opts *Options) {
+		opts.autoFlush = autoFlush
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.lengthFieldSize == 0 {
+		opts.lengthFieldSize = defaultLengthFieldSize
+	}
+	if opts.byteOrder == nil {
+		opts.byteOrder = binary.BigEndian
+	}
+	if opts.maxFrameSize <= 0 {
+		opts.maxFrameSize = defaultMaxFrameSize
+	}
+	return opts
+}
+
+// base 保存连接和Flusher
+type base struct {
+	conn    io.ReadWriter
+	flusher utils.Flusher
+	opts    *Options
+}
+
+func newBase(conn io.ReadWriter, opts *Options) base {
+	flusher, ok := conn.(utils.Flusher)
+	if !ok {
+		flusher = utils.EmptyFlusher
+	}
+	return base{conn: conn, flusher: flusher, opts: opts}
+}
+
+func (b *base) Flush() error {
+	return b.flusher.Flush()
+}
+
+func (b *base) afterWrite() error {
+	if b.opts.autoFlush {
+		return b.flusher.Flush()
+	}
+	return nil
+}
+
+func (b *base) write(header, frame []byte) error {
+	if len(header) > 0 {
+		if _, err := b.conn.Write(header); err != nil {
+			return err
+		}
+	}
+	if _, err := b.conn.Write(frame); err != nil {
+		return err
+	}
+	return b.afterWrite()
+}
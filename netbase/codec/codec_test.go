@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flushBuffer 记录Flush调用次数
+type flushBuffer struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (b *flushBuffer) Flush() error {
+	b.flushes++
+	return nil
+}
+
+func roundTrip(t *testing.T, c Codec, frames ...[]byte) {
+	for _, frame := range frames {
+		require.NoError(t, c.WriteFrame(frame))
+	}
+	require.NoError(t, c.Flush())
+	for _, frame := range frames {
+		got, err := c.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, frame, got)
+	}
+	_, err := c.ReadFrame()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestLengthFieldCodec(t *testing.T) {
+	frames := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 300)}
+	for _, size := range []int{2, 4, 8} {
+		for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			buf := new(flushBuffer)
+			c, err := NewLengthFieldCodec(buf, WithLengthFieldSize(size), WithByteOrder(order))
+			require.NoError(t, err)
+			roundTrip(t, c, frames...)
+			require.Equal(t, 1, buf.flushes)
+		}
+	}
+
+	c, err := NewLengthFieldCodec(new(bytes.Buffer), WithLengthFieldSize(1))
+	require.NoError(t, err)
+	require.ErrorIs(t, c.WriteFrame(make([]byte, 256)), ErrFrameTooLarge)
+
+	_, err = NewLengthFieldCodec(new(bytes.Buffer), WithLengthFieldSize(3))
+	require.Error(t, err)
+
+	buf := bytes.NewBuffer([]byte{0, 0, 0, 10, 'x'})
+	c, err = NewLengthFieldCodec(buf, WithMaxFrameSize(5))
+	require.NoError(t, err)
+	_, err = c.ReadFrame()
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+}
+
+func TestVarintCodec(t *testing.T) {
+	buf := new(flushBuffer)
+	c, err := NewVarintCodec(buf, WithAutoFlush(true))
+	require.NoError(t, err)
+	roundTrip(t, c, []byte("a"), bytes.Repeat([]byte("y"), 1000))
+	require.Equal(t, 3, buf.flushes)
+}
+
+func TestVarintCodecUsesConnBuffer(t *testing.T) {
+	// 连接支持按字节读取时不再额外缓存,读取一帧后剩余数据仍留在连接中
+	buf := bytes.NewBuffer([]byte{1, 'a', 1, 'b'})
+	c, err := NewVarintCodec(buf)
+	require.NoError(t, err)
+	frame, err := c.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, "a", string(frame))
+	require.Equal(t, []byte{1, 'b'}, buf.Bytes())
+}
+
+func TestDelimiterCodec(t *testing.T) {
+	c, err := NewDelimiterCodec(new(bytes.Buffer), []byte("\r\n"), WithMaxFrameSize(8192))
+	require.NoError(t, err)
+	roundTrip(t, c, []byte("GET /"), []byte("a\rb\nc"), bytes.Repeat([]byte("z"), 5000))
+	require.ErrorIs(t, c.WriteFrame([]byte("a\r\nb")), ErrDelimiterInFrame)
+
+	c, err = NewDelimiterCodec(bytes.NewBufferString("toolong\n"), []byte("\n"), WithMaxFrameSize(4))
+	require.NoError(t, err)
+	_, err = c.ReadFrame()
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+}
@@ -0,0 +1,211 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+)
+
+// lengthFieldCodec 长度前缀帧: | length | payload |
+type lengthFieldCodec struct {
+	base
+	header []byte
+	limit  uint64
+}
+
+// NewLengthFieldCodec 创建长度前缀编解码器,长度字段不包含自身
+// 长度字段字节数由WithLengthFieldSize设置,默认4字节大端
+func NewLengthFieldCodec(conn io.ReadWriter, options ...Option) (Codec, error) {
+	opts := loadOptions(options...)
+
+	var limit uint64
+	switch opts.lengthFieldSize {
+	case 1:
+		limit = math.MaxUint8
+	case 2:
+		limit = math.MaxUint16
+	case 4:
+		limit = math.MaxUint32
+	case 8:
+		limit = math.MaxUint64
+	default:
+		return nil, errors.Errorf("不支持的长度字段字节数 [size=%v]", opts.lengthFieldSize)
+	}
+	if uint64(opts.maxFrameSize) < limit {
+		limit = uint64(opts.maxFrameSize)
+	}
+
+	return &lengthFieldCodec{
+		base:   newBase(conn, opts),
+		header: make([]byte, opts.lengthFieldSize),
+		limit:  limit,
+	}, nil
+}
+
+func (c *lengthFieldCodec) ReadFrame() ([]byte, error) {
+	if _, err := io.ReadFull(c.conn, c.header); err != nil {
+		return nil, err
+	}
+
+	var length uint64
+	switch len(c.header) {
+	case 1:
+		length = uint64(c.header[0])
+	case 2:
+		length = uint64(c.opts.byteOrder.Uint16(c.header))
+	case 4:
+		length = uint64(c.opts.byteOrder.Uint32(c.header))
+	case 8:
+		length = c.opts.byteOrder.Uint64(c.header)
+	}
+	if length > c.limit {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, frame); err != nil {
+		return nil, noEOF(err)
+	}
+	return frame, nil
+}
+
+func (c *lengthFieldCodec) WriteFrame(frame []byte) error {
+	length := uint64(len(frame))
+	if length > c.limit {
+		return ErrFrameTooLarge
+	}
+
+	header := make([]byte, len(c.header))
+	switch len(header) {
+	case 1:
+		header[0] = byte(length)
+	case 2:
+		c.opts.byteOrder.PutUint16(header, uint16(length))
+	case 4:
+		c.opts.byteOrder.PutUint32(header, uint32(length))
+	case 8:
+		c.opts.byteOrder.PutUint64(header, length)
+	}
+	return c.write(header, frame)
+}
+
+// varintCodec uvarint长度前缀帧: | uvarint length | payload |
+type varintCodec struct {
+	base
+	reader io.ByteReader
+	source io.Reader
+}
+
+// NewVarintCodec 创建uvarint长度前缀编解码器
+// 连接支持按字节读取(如netbase.NewBufferConnection包装的连接)时直接使用连接的读缓存,
+// 否则创建读缓存,之后连接上的数据只能通过该编解码器读取
+func NewVarintCodec(conn io.ReadWriter, options ...Option) (Codec, error) {
+	opts := loadOptions(options...)
+	c := &varintCodec{base: newBase(conn, opts)}
+	if br, ok := conn.(io.ByteReader); ok {
+		c.reader, c.source = br, conn
+	} else {
+		buffered := bufio.NewReader(conn)
+		c.reader, c.source = buffered, buffered
+	}
+	return c, nil
+}
+
+func (c *varintCodec) ReadFrame() ([]byte, error) {
+	length, err := binary.ReadUvarint(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(c.opts.maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, length)
+	if _, err = io.ReadFull(c.source, frame); err != nil {
+		return nil, noEOF(err)
+	}
+	return frame, nil
+}
+
+func (c *varintCodec) WriteFrame(frame []byte) error {
+	if len(frame) > c.opts.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	header := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, uint64(len(frame)))
+	return c.write(header[:n], frame)
+}
+
+// delimiterCodec 分隔符帧: | payload | delimiter |
+type delimiterCodec struct {
+	base
+	delimiter []byte
+	reader    *bufio.Reader
+}
+
+// NewDelimiterCodec 创建分隔符编解码器,如"\n"或"\r\n"
+// 读取的帧不包含分隔符,写入的帧不能包含分隔符
+func NewDelimiterCodec(conn io.ReadWriter, delimiter []byte, options ...Option) (Codec, error) {
+	if len(delimiter) == 0 {
+		return nil, errors.New("分隔符不能为空")
+	}
+	opts := loadOptions(options...)
+	return &delimiterCodec{
+		base:      newBase(conn, opts),
+		delimiter: append([]byte(nil), delimiter...),
+		reader:    bufio.NewReader(conn),
+	}, nil
+}
+
+func (c *delimiterCodec) ReadFrame() ([]byte, error) {
+	last := c.delimiter[len(c.delimiter)-1]
+	limit := c.opts.maxFrameSize + len(c.delimiter)
+
+	var frame []byte
+	for {
+		chunk, err := c.reader.ReadSlice(last)
+		if len(frame)+len(chunk) > limit {
+			return nil, ErrFrameTooLarge
+		}
+		frame = append(frame, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(frame) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if bytes.HasSuffix(frame, c.delimiter) {
+			return frame[:len(frame)-len(c.delimiter)], nil
+		}
+	}
+}
+
+func (c *delimiterCodec) WriteFrame(frame []byte) error {
+	if len(frame) > c.opts.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	if bytes.Contains(frame, c.delimiter) {
+		return ErrDelimiterInFrame
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(c.delimiter); err != nil {
+		return err
+	}
+	return c.afterWrite()
+}
+
+// noEOF 帧读取到一半时连接关闭视为异常
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
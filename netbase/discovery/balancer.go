@@ -0,0 +1,180 @@
+package discovery
+
+import (
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// 一致性哈希默认每个地址的虚拟节点数
+	defaultReplicas = 100
+)
+
+// ErrNoEndpoints 没有可用的地址
+var ErrNoEndpoints = errors.New("no endpoints available")
+
+// Balancer 从Resolver的地址中选择一个
+type Balancer interface {
+	// Pick 选择一个地址,key只用于一致性哈希;连接关闭后调用done
+	Pick(key string) (endpoint string, done func(), err error)
+	// Contains 判断地址是否仍在Resolver的地址中
+	Contains(endpoint string) bool
+	// Close 取消订阅Resolver,之后地址不再更新
+	Close() error
+}
+
+// endpointSet 跟随Resolver更新的地址,各Balancer实现共用
+type endpointSet struct {
+	mu        sync.RWMutex
+	endpoints []string
+	// 取消订阅Resolver
+	unsubscribe func()
+}
+
+func (s *endpointSet) Close() error {
+	s.unsubscribe()
+	return nil
+}
+
+func (s *endpointSet) Contains(endpoint string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.SearchStrings(s.endpoints, endpoint)
+	return i < len(s.endpoints) && s.endpoints[i] == endpoint
+}
+
+func noop() {}
+
+type roundRobin struct {
+	endpointSet
+	next atomic.Uint64
+}
+
+// NewRoundRobin 按顺序轮流选择地址
+func NewRoundRobin(resolver Resolver) Balancer {
+	b := new(roundRobin)
+	b.unsubscribe = resolver.Subscribe(func(endpoints []string) {
+		b.mu.Lock()
+		b.endpoints = endpoints
+		b.mu.Unlock()
+	})
+	return b
+}
+
+func (b *roundRobin) Pick(string) (string, func(), error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.endpoints) == 0 {
+		return "", nil, ErrNoEndpoints
+	}
+	n := b.next.Add(1) - 1
+	return b.endpoints[n%uint64(len(b.endpoints))], noop, nil
+}
+
+type leastConn struct {
+	endpointSet
+	// 每个地址未关闭的连接数,地址移除后仍保留到连接全部关闭
+	active map[string]int
+	next   int
+}
+
+// NewLeastConn 选择未关闭连接最少的地址,数量相同时轮流选择
+func NewLeastConn(resolver Resolver) Balancer {
+	b := &leastConn{active: make(map[string]int)}
+	b.unsubscribe = resolver.Subscribe(func(endpoints []string) {
+		b.mu.Lock()
+		b.endpoints = endpoints
+		b.mu.Unlock()
+	})
+	return b
+}
+
+func (b *leastConn) Pick(string) (string, func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.endpoints) == 0 {
+		return "", nil, ErrNoEndpoints
+	}
+
+	best := ""
+	for i := range b.endpoints {
+		endpoint := b.endpoints[(b.next+i)%len(b.endpoints)]
+		if best == "" || b.active[endpoint] < b.active[best] {
+			best = endpoint
+		}
+	}
+	b.next++
+	b.active[best]++
+
+	var once sync.Once
+	return best, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.active[best]--; b.active[best] <= 0 {
+				delete(b.active, best)
+			}
+		})
+	}, nil
+}
+
+type consistentHash struct {
+	endpointSet
+	replicas int
+	// 虚拟节点的哈希值,升序
+	ring  []uint32
+	nodes map[uint32]string
+}
+
+// NewConsistentHash 按key的一致性哈希选择地址,地址变化时只有少量key改变映射
+// replicas为每个地址的虚拟节点数,<=0时为100
+func NewConsistentHash(resolver Resolver, replicas int) Balancer {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	b := &consistentHash{replicas: replicas}
+	b.unsubscribe = resolver.Subscribe(b.rebuild)
+	return b
+}
+
+func (b *consistentHash) rebuild(endpoints []string) {
+	ring := make([]uint32, 0, len(endpoints)*b.replicas)
+	nodes := make(map[uint32]string, len(endpoints)*b.replicas)
+	for _, endpoint := range endpoints {
+		for i := 0; i < b.replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + endpoint))
+			if _, ok := nodes[h]; ok {
+				continue
+			}
+			nodes[h] = endpoint
+			ring = append(ring, h)
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i] < ring[j]
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.endpoints, b.ring, b.nodes = endpoints, ring, nodes
+}
+
+func (b *consistentHash) Pick(key string) (string, func(), error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.ring) == 0 {
+		return "", nil, ErrNoEndpoints
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i] >= h
+	})
+	if i == len(b.ring) {
+		i = 0
+	}
+	return b.nodes[b.ring[i]], noop, nil
+}
@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/lngwu11/toolgo/utils/connpool"
+	"sync"
+	"sync/atomic"
+)
+
+// Dial 由balancer选择地址并建立连接,连接关闭时通知balancer
+func Dial(balancer Balancer, key string, options ...client.Option) (*Conn, error) {
+	endpoint, done, err := balancer.Pick(key)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.NewTCPConnection(endpoint, options...)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	return &Conn{
+		ConnReadWriteCloser: conn,
+		endpoint:            endpoint,
+		balancer:            balancer,
+		done:                done,
+	}, nil
+}
+
+// NewPoolFactory 返回connpool.Config的Factory,由balancer选择地址建立连接
+// Config.Param为string时作为一致性哈希的key;地址从Resolver中移除后连接不再被复用
+func NewPoolFactory(balancer Balancer, options ...client.Option) func(p interface{}) (connpool.IConn, error) {
+	return func(p interface{}) (connpool.IConn, error) {
+		key, _ := p.(string)
+		return Dial(balancer, key, options...)
+	}
+}
+
+var _ connpool.IConn = (*Conn)(nil)
+
+// Conn 通过Balancer建立的连接,可以放入connpool
+type Conn struct {
+	connpool.Connection
+	utils.ConnReadWriteCloser
+	endpoint string
+	balancer Balancer
+	done     func()
+
+	closeOnce sync.Once
+	closed    atomic.Bool
+}
+
+// Endpoint 返回选中的地址
+func (c *Conn) Endpoint() string {
+	return c.endpoint
+}
+
+func (c *Conn) Close() error {
+	err := c.ConnReadWriteCloser.Close()
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		c.done()
+	})
+	return err
+}
+
+func (c *Conn) Closed() bool {
+	return c.closed.Load()
+}
+
+// Reusable 地址仍然可用时可以复用;地址已被移除时关闭连接,connpool丢弃不可复用的连接时不会关闭它
+func (c *Conn) Reusable() bool {
+	if c.balancer.Contains(c.endpoint) {
+		return true
+	}
+	_ = c.Close()
+	return false
+}
+
+func (c *Conn) Detector() {}
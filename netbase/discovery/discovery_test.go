@@ -0,0 +1,173 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/utils/connpool"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticResolver(t *testing.T) {
+	r := NewStaticResolver("b:1", "a:1", "b:1")
+	require.Equal(t, []string{"a:1", "b:1"}, r.Endpoints())
+
+	updates := make(chan []string, 4)
+	cancel := r.Subscribe(func(endpoints []string) {
+		updates <- endpoints
+	})
+	require.Equal(t, []string{"a:1", "b:1"}, <-updates)
+
+	// 没有变化时不通知
+	r.Update("a:1", "b:1")
+	r.Update("c:1")
+	require.Equal(t, []string{"c:1"}, <-updates)
+
+	cancel()
+	r.Update("d:1")
+	require.Empty(t, updates)
+}
+
+func TestFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints")
+	require.NoError(t, os.WriteFile(path, []byte("# backends\na:1\n\nb:1\n"), 0o644))
+
+	r, err := NewFileResolver(path)
+	require.NoError(t, err)
+	defer r.Close()
+	require.Equal(t, []string{"a:1", "b:1"}, r.Endpoints())
+
+	// 通过重命名替换文件
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte("c:1\n"), 0o644))
+	require.NoError(t, os.Rename(tmp, path))
+	require.Eventually(t, func() bool {
+		return fmt.Sprint(r.Endpoints()) == "[c:1]"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, err = NewFileResolver(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestDNSResolver(t *testing.T) {
+	r, err := NewDNSResolver("localhost", 8080, WithPollInterval(time.Second))
+	require.NoError(t, err)
+	defer r.Close()
+	require.Contains(t, r.Endpoints(), "127.0.0.1:8080")
+}
+
+func TestBalancers(t *testing.T) {
+	r := NewStaticResolver("a:1", "b:1", "c:1")
+
+	rr := NewRoundRobin(r)
+	var picked []string
+	for i := 0; i < 4; i++ {
+		endpoint, _, err := rr.Pick("")
+		require.NoError(t, err)
+		picked = append(picked, endpoint)
+	}
+	require.Equal(t, []string{"a:1", "b:1", "c:1", "a:1"}, picked)
+
+	// 连接关闭前不会选择同一个地址
+	lc := NewLeastConn(r)
+	seen := make(map[string]func())
+	for i := 0; i < 3; i++ {
+		endpoint, done, err := lc.Pick("")
+		require.NoError(t, err)
+		require.NotContains(t, seen, endpoint)
+		seen[endpoint] = done
+	}
+	seen["b:1"]()
+	endpoint, _, err := lc.Pick("")
+	require.NoError(t, err)
+	require.Equal(t, "b:1", endpoint)
+
+	// 移除地址时只有映射到该地址的key改变
+	ch := NewConsistentHash(r, 0)
+	before := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		before[key], _, _ = ch.Pick(key)
+	}
+	r.Update("a:1", "c:1")
+	require.False(t, ch.Contains("b:1"))
+	for key, old := range before {
+		endpoint, _, err := ch.Pick(key)
+		require.NoError(t, err)
+		if old != "b:1" {
+			require.Equal(t, old, endpoint)
+		}
+	}
+
+	r.Update()
+	_, _, err = rr.Pick("")
+	require.ErrorIs(t, err, ErrNoEndpoints)
+}
+
+func listen(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	return ln
+}
+
+func TestPool(t *testing.T) {
+	a, b := listen(t), listen(t)
+	r := NewStaticResolver(a.Addr().String())
+	pool := connpool.GetPool(fmt.Sprintf("%v-%v", t.Name(), time.Now().UnixNano()), &connpool.Config{
+		Factory: NewPoolFactory(NewLeastConn(r)),
+		MaxIdle: 4,
+	})
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	require.NoError(t, err)
+	require.Equal(t, a.Addr().String(), conn.(*Conn).Endpoint())
+	require.NoError(t, pool.Put(conn))
+
+	// 地址被移除后空闲连接不再复用
+	r.Update(b.Addr().String())
+	next, err := pool.Get()
+	require.NoError(t, err)
+	require.Equal(t, b.Addr().String(), next.(*Conn).Endpoint())
+	require.True(t, conn.Closed())
+	require.NoError(t, next.Close())
+}
+
+func TestBalancerClose(t *testing.T) {
+	r := NewStaticResolver("a:1")
+	rr := NewRoundRobin(r)
+	require.NoError(t, rr.Close())
+	r.Update("b:1")
+	endpoint, _, err := rr.Pick("")
+	require.NoError(t, err)
+	require.Equal(t, "a:1", endpoint)
+}
+
+func TestSubscriberCallsEndpoints(t *testing.T) {
+	r := NewStaticResolver("a:1")
+	var got [][]string
+	cancel := r.Subscribe(func([]string) {
+		// 回调中可以读取当前地址
+		got = append(got, r.Endpoints())
+	})
+	defer cancel()
+	r.Update("b:1")
+	require.Equal(t, [][]string{{"a:1"}, {"b:1"}}, got)
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSResolver 定时查询DNS的Resolver,支持A/AAAA和SRV记录
+type DNSResolver struct {
+	notifier
+	opts   *Options
+	lookup func(ctx context.Context) ([]string, error)
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewDNSResolver 定时查询host的A/AAAA记录,地址为ip:port
+// 首次查询失败时返回错误,之后查询失败时保留上一次的地址
+func NewDNSResolver(host string, port int, options ...Option) (*DNSResolver, error) {
+	opts := loadOptions(options...)
+	portStr := strconv.Itoa(port)
+	return newDNSResolver(opts, func(ctx context.Context) ([]string, error) {
+		addrs, err := opts.netResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, errors.Wrapf(err, "无法解析主机名 [host=%v]", host)
+		}
+		endpoints := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			endpoints = append(endpoints, net.JoinHostPort(addr.IP.String(), portStr))
+		}
+		return endpoints, nil
+	})
+}
+
+// NewSRVResolver 定时查询_service._proto.name的SRV记录,地址为target:port
+// service和proto为空时直接查询name
+func NewSRVResolver(service, proto, name string, options ...Option) (*DNSResolver, error) {
+	opts := loadOptions(options...)
+	return newDNSResolver(opts, func(ctx context.Context) ([]string, error) {
+		_, records, err := opts.netResolver.LookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "无法查询SRV记录 [service=%v, proto=%v, name=%v]", service, proto, name)
+		}
+		endpoints := make([]string, 0, len(records))
+		for _, record := range records {
+			target := strings.TrimSuffix(record.Target, ".")
+			endpoints = append(endpoints, net.JoinHostPort(target, strconv.Itoa(int(record.Port))))
+		}
+		return endpoints, nil
+	})
+}
+
+func newDNSResolver(opts *Options, lookup func(ctx context.Context) ([]string, error)) (*DNSResolver, error) {
+	r := &DNSResolver{
+		opts:   opts,
+		lookup: lookup,
+		done:   make(chan struct{}),
+	}
+	if err := r.refresh(); err != nil {
+		return nil, err
+	}
+	go r.poll()
+	return r, nil
+}
+
+// refresh 查询一次,没有地址时视为失败
+func (r *DNSResolver) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.pollInterval)
+	defer cancel()
+	endpoints, err := r.lookup(ctx)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return errors.Errorf("DNS查询没有返回地址")
+	}
+	r.update(endpoints)
+	return nil
+}
+
+func (r *DNSResolver) poll() {
+	ticker := time.NewTicker(r.opts.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.refresh(); err != nil {
+				r.opts.reportError(err)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *DNSResolver) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.clear()
+	})
+	return nil
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"bufio"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileResolver 从文件读取地址并在文件变化时重新加载
+// 每行一个地址,忽略空行和#开头的注释
+type FileResolver struct {
+	notifier
+	path    string
+	opts    *Options
+	watcher *fsnotify.Watcher
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewFileResolver 读取文件并监听变化,首次读取失败时返回错误
+// 监听文件所在的目录,编辑器通过重命名替换文件时也能重新加载
+func NewFileResolver(path string, options ...Option) (*FileResolver, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &FileResolver{
+		path: path,
+		opts: loadOptions(options...),
+		done: make(chan struct{}),
+	}
+	if err = r.reload(); err != nil {
+		return nil, err
+	}
+
+	r.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法监听文件 [path=%v]", path)
+	}
+	if err = r.watcher.Add(filepath.Dir(path)); err != nil {
+		_ = r.watcher.Close()
+		return nil, errors.Wrapf(err, "无法监听文件 [path=%v]", path)
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (r *FileResolver) reload() error {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return errors.Wrapf(err, "无法读取地址文件 [path=%v]", r.path)
+	}
+	defer f.Close()
+
+	var endpoints []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		endpoints = append(endpoints, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return errors.Wrapf(err, "无法读取地址文件 [path=%v]", r.path)
+	}
+	r.update(endpoints)
+	return nil
+}
+
+func (r *FileResolver) watch() {
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != r.path || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				r.opts.reportError(err)
+			}
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			r.opts.reportError(err)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *FileResolver) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+		err = r.watcher.Close()
+		r.clear()
+	})
+	return err
+}
@@ -0,0 +1,189 @@
+package discovery
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认DNS轮询间隔
+	defaultPollInterval = 30 * time.Second
+)
+
+// Resolver 提供一组实时更新的服务地址
+type Resolver interface {
+	// Endpoints 返回当前的地址,已去重并排序
+	Endpoints() []string
+	// Subscribe 注册地址变化的回调并立即以当前地址调用一次,返回取消注册的函数
+	// 回调按地址变化的顺序同步调用,不能阻塞,可以调用Endpoints,不能调用Subscribe
+	Subscribe(callback func(endpoints []string)) (cancel func())
+	// Close 停止更新
+	Close() error
+}
+
+type Option func(opts *Options)
+
+type Options struct {
+	// DNS轮询间隔
+	pollInterval time.Duration
+	// DNS解析器
+	netResolver *net.Resolver
+	// 更新失败时的回调,失败时保留上一次的地址
+	errorHandler func(err error)
+}
+
+// WithPollInterval 设置DNS轮询间隔,默认30s
+func WithPollInterval(interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.pollInterval = interval
+	}
+}
+
+// WithNetResolver 设置DNS解析器,默认net.DefaultResolver
+func WithNetResolver(resolver *net.Resolver) Option {
+	return func(opts *Options) {
+		opts.netResolver = resolver
+	}
+}
+
+// WithErrorHandler 设置更新失败时的回调,失败时保留上一次的地址
+func WithErrorHandler(handler func(err error)) Option {
+	return func(opts *Options) {
+		opts.errorHandler = handler
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.pollInterval <= 0 {
+		opts.pollInterval = defaultPollInterval
+	}
+	if opts.netResolver == nil {
+		opts.netResolver = net.DefaultResolver
+	}
+	return opts
+}
+
+func (opts *Options) reportError(err error) {
+	if opts.errorHandler != nil {
+		opts.errorHandler(err)
+	}
+}
+
+// notifier 保存当前地址并通知订阅者,各Resolver实现共用
+type notifier struct {
+	// 串行通知保证回调的顺序,回调不持有mu
+	notifyMu    sync.Mutex
+	mu          sync.Mutex
+	endpoints   []string
+	nextID      int
+	subscribers map[int]func(endpoints []string)
+}
+
+func (n *notifier) Endpoints() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.endpoints...)
+}
+
+func (n *notifier) Subscribe(callback func(endpoints []string)) (cancel func()) {
+	n.notifyMu.Lock()
+	defer n.notifyMu.Unlock()
+	n.mu.Lock()
+	if n.subscribers == nil {
+		n.subscribers = make(map[int]func(endpoints []string))
+	}
+	id := n.nextID
+	n.nextID++
+	n.subscribers[id] = callback
+	endpoints := append([]string(nil), n.endpoints...)
+	n.mu.Unlock()
+
+	callback(endpoints)
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.subscribers, id)
+	}
+}
+
+// update 地址有变化时通知订阅者,复制订阅者后在mu外调用回调
+func (n *notifier) update(endpoints []string) {
+	endpoints = normalize(endpoints)
+	n.notifyMu.Lock()
+	defer n.notifyMu.Unlock()
+	n.mu.Lock()
+	if equal(n.endpoints, endpoints) {
+		n.mu.Unlock()
+		return
+	}
+	n.endpoints = endpoints
+	callbacks := make([]func(endpoints []string), 0, len(n.subscribers))
+	for _, callback := range n.subscribers {
+		callbacks = append(callbacks, callback)
+	}
+	n.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(append([]string(nil), endpoints...))
+	}
+}
+
+func (n *notifier) clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subscribers = nil
+}
+
+// normalize 去重并排序
+func normalize(endpoints []string) []string {
+	set := make(map[string]struct{}, len(endpoints))
+	result := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if _, ok := set[endpoint]; !ok && endpoint != "" {
+			set[endpoint] = struct{}{}
+			result = append(result, endpoint)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// StaticResolver 固定的地址列表,可以手动更新
+type StaticResolver struct {
+	notifier
+}
+
+// NewStaticResolver 创建固定地址的Resolver
+func NewStaticResolver(endpoints ...string) *StaticResolver {
+	r := new(StaticResolver)
+	r.update(endpoints)
+	return r
+}
+
+// Update 替换地址列表
+func (r *StaticResolver) Update(endpoints ...string) {
+	r.update(endpoints)
+}
+
+func (r *StaticResolver) Close() error {
+	r.clear()
+	return nil
+}
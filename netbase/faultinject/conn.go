@@ -0,0 +1,163 @@
+package faultinject
+
+import (
+	"errors"
+	"github.com/lngwu11/toolgo/netbase/throttle"
+	"github.com/lngwu11/toolgo/utils"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReset 连接被故障注入重置
+var ErrReset = errors.New("connection reset by fault injection")
+
+// Wrap 包装连接,读取时注入read的故障,写入时注入write的故障,nil表示该方向不注入故障
+// 连接被重置时tcp连接发送RST,之后的读写返回ErrReset;等待延迟或带宽时连接关闭返回net.ErrClosed
+func Wrap(conn utils.ConnReadWriteCloser, read, write *Faults) utils.ConnReadWriteCloser {
+	if read == nil {
+		read = NewFaults()
+	}
+	if write == nil {
+		write = NewFaults()
+	}
+	return &faultConn{
+		ConnReadWriteCloser: throttle.Wrap(conn,
+			throttle.WithReadLimiter(read.limiter),
+			throttle.WithWriteLimiter(write.limiter),
+		),
+		raw:    conn,
+		read:   read,
+		write:  write,
+		closed: make(chan struct{}),
+	}
+}
+
+type faultConn struct {
+	utils.ConnReadWriteCloser
+	raw         utils.ConnReadWriteCloser
+	read, write *Faults
+
+	// 每个方向已传输的字节数
+	readBytes  atomic.Int64
+	writeBytes atomic.Int64
+	reset      atomic.Bool
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	if c.reset.Load() {
+		return 0, ErrReset
+	}
+	opts := c.read.options()
+	if opts.fragmentSize > 0 && len(p) > opts.fragmentSize {
+		p = p[:opts.fragmentSize]
+	}
+
+	for {
+		n, err := c.ConnReadWriteCloser.Read(p)
+		if n <= 0 {
+			return n, err
+		}
+		// 等待期间连接关闭时已读取的数据仍然返回
+		waitErr := c.wait(c.read.delay(opts))
+		reset := exceeds(&c.readBytes, opts.resetAfter, &n)
+		n = len(c.read.mangle(p, p[:n], opts))
+		if reset {
+			c.abort()
+			return n, ErrReset
+		}
+		if waitErr != nil {
+			return n, waitErr
+		}
+		// 数据全部被丢弃时继续读取
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	if c.reset.Load() {
+		return 0, ErrReset
+	}
+	opts := c.write.options()
+
+	written := 0
+	for len(p) > 0 {
+		size := len(p)
+		if opts.fragmentSize > 0 && size > opts.fragmentSize {
+			size = opts.fragmentSize
+		}
+		if err := c.wait(c.write.delay(opts)); err != nil {
+			return written, err
+		}
+		n := size
+		reset := exceeds(&c.writeBytes, opts.resetAfter, &n)
+		// 丢弃的字节对调用方来说已经写入
+		if data := c.write.mangle(nil, p[:n], opts); len(data) > 0 {
+			if _, err := c.ConnReadWriteCloser.Write(data); err != nil {
+				return written, err
+			}
+		}
+		written += n
+		if reset {
+			c.abort()
+			return written, ErrReset
+		}
+		p = p[size:]
+	}
+	return written, nil
+}
+
+func (c *faultConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.ConnReadWriteCloser.Close()
+}
+
+// exceeds 累加n字节,达到重置阈值时把n截断到阈值并返回true
+func exceeds(counter *atomic.Int64, resetAfter int64, n *int) bool {
+	if resetAfter <= 0 {
+		counter.Add(int64(*n))
+		return false
+	}
+	total := counter.Add(int64(*n))
+	if total < resetAfter {
+		return false
+	}
+	if over := total - resetAfter; over < int64(*n) {
+		*n -= int(over)
+	} else {
+		*n = 0
+	}
+	return true
+}
+
+// abort 重置连接,tcp连接设置SO_LINGER为0后关闭以发送RST
+func (c *faultConn) abort() {
+	c.reset.Store(true)
+	if l, ok := c.raw.(interface{ SetLinger(sec int) error }); ok {
+		_ = l.SetLinger(0)
+	}
+	_ = c.Close()
+}
+
+// wait 等待d,连接关闭时返回net.ErrClosed
+func (c *faultConn) wait(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
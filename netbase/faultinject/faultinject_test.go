@@ -0,0 +1,168 @@
+package faultinject
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase/internal/nettest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFragmentAndLatency(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	defer b.Close()
+	faults := NewFaults(WithFragmentSize(3), WithLatency(20*time.Millisecond, 0))
+	conn := Wrap(a, faults, nil)
+	defer conn.Close()
+
+	_, err := b.Write([]byte("hello world"))
+	require.NoError(t, err)
+	start := time.Now()
+	buf := make([]byte, 64)
+	n, err := io.ReadAtLeast(conn, buf, 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// 调整后立即生效
+	faults.Clear()
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "lo world", string(buf[:n]))
+}
+
+func TestDropAndCorrupt(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	defer b.Close()
+	faults := NewFaults(WithDropRate(0.5), WithSeed(1))
+	conn := Wrap(a, nil, faults)
+	defer conn.Close()
+
+	payload := bytes.Repeat([]byte("x"), 1000)
+	n, err := conn.Write(payload)
+	require.NoError(t, err)
+	require.Equal(t, len(payload), n)
+	require.NoError(t, conn.CloseWrite())
+	got, err := io.ReadAll(b)
+	require.NoError(t, err)
+	require.Greater(t, len(got), 300)
+	require.Less(t, len(got), 700)
+
+	faults.Update(WithDropRate(0), WithCorruptRate(1))
+	c, d := nettest.TCPPair(t)
+	defer d.Close()
+	conn = Wrap(c, nil, faults)
+	defer conn.Close()
+	_, err = conn.Write([]byte("abc"))
+	require.NoError(t, err)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(d, buf)
+	require.NoError(t, err)
+	for i, ch := range []byte("abc") {
+		require.NotEqual(t, ch, buf[i])
+	}
+}
+
+func TestResetAfter(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	defer b.Close()
+	conn := Wrap(a, nil, NewFaults(WithResetAfter(5)))
+
+	n, err := conn.Write([]byte("hello world"))
+	require.ErrorIs(t, err, ErrReset)
+	require.Equal(t, 5, n)
+	_, err = conn.Write([]byte("again"))
+	require.ErrorIs(t, err, ErrReset)
+
+	// 对端先读到前5个字节,然后收到RST
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(b, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+	_, err = b.Read(buf)
+	require.True(t, errors.Is(err, syscall.ECONNRESET), "err=%v", err)
+}
+
+func TestBandwidth(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	defer b.Close()
+	conn := Wrap(a, nil, NewFaults(WithBandwidth(64*1024)))
+	defer conn.Close()
+
+	go func() {
+		_, _ = io.Copy(io.Discard, b)
+	}()
+	// 令牌桶容量4KB,写入32KB至少需要(32-4)/64秒
+	start := time.Now()
+	_, err := conn.Write(make([]byte, 32*1024))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestProxy(t *testing.T) {
+	p, err := NewProxy(nettest.EchoServer(t), nil, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	conn, err := net.Dial("tcp", p.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+
+	// 客户端收到回复中的前3个字节后连接被重置
+	p.Down().Update(WithResetAfter(8))
+	_, err = conn.Write([]byte("world"))
+	require.NoError(t, err)
+	got, err := io.ReadAll(conn)
+	require.Equal(t, "wor", string(got))
+	require.True(t, errors.Is(err, syscall.ECONNRESET), "err=%v", err)
+	require.Eventually(t, func() bool {
+		return p.ActiveConnections() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestReadClosedDuringLatency(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	defer b.Close()
+	conn := Wrap(a, NewFaults(WithLatency(time.Minute, 0)), nil)
+
+	_, err := b.Write([]byte("hello"))
+	require.NoError(t, err)
+	time.AfterFunc(50*time.Millisecond, func() {
+		_ = conn.Close()
+	})
+	buf := make([]byte, 5)
+	n, err := conn.Read(buf)
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.Equal(t, "hello", string(buf[:n]))
+}
+
+func TestProxyCloseWakesWaiters(t *testing.T) {
+	p, err := NewProxy(nettest.EchoServer(t), nil, NewFaults(WithLatency(time.Minute, 0)))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", p.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return p.ActiveConnections() == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	// 转发的写入正在等待延迟,关闭不需要等到延迟结束
+	start := time.Now()
+	require.NoError(t, p.Close())
+	require.Less(t, time.Since(start), 5*time.Second)
+}
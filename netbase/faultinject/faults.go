@@ -0,0 +1,161 @@
+package faultinject
+
+import (
+	"github.com/lngwu11/toolgo/netbase/throttle"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 每次读写的延迟和随机抖动
+	latency time.Duration
+	jitter  time.Duration
+	// 带宽,字节/秒
+	bandwidth float64
+	// 每个字节被丢弃和修改的概率
+	dropRate    float64
+	corruptRate float64
+	// 单次读写的最大字节数
+	fragmentSize int
+	// 传输的字节数达到该值后重置连接
+	resetAfter int64
+	// 随机数种子
+	seed int64
+}
+
+// WithLatency 设置每次读写的延迟,实际延迟在[latency, latency+jitter)之间
+// 写入在发送前等待,读取在数据到达后等待
+func WithLatency(latency, jitter time.Duration) Option {
+	return func(opts *Options) {
+		opts.latency = latency
+		opts.jitter = jitter
+	}
+}
+
+// WithBandwidth 设置带宽,单位字节/秒,使用同一个Faults的连接共享带宽,0表示不限制
+func WithBandwidth(bytesPerSecond float64) Option {
+	return func(opts *Options) {
+		opts.bandwidth = bytesPerSecond
+	}
+}
+
+// WithDropRate 设置每个字节被丢弃的概率,取值[0, 1]
+func WithDropRate(rate float64) Option {
+	return func(opts *Options) {
+		opts.dropRate = rate
+	}
+}
+
+// WithCorruptRate 设置每个字节被修改的概率,取值[0, 1]
+func WithCorruptRate(rate float64) Option {
+	return func(opts *Options) {
+		opts.corruptRate = rate
+	}
+}
+
+// WithFragmentSize 设置单次读写的最大字节数,写入被拆分成多次发送,读取每次最多返回size字节,0表示不拆分
+func WithFragmentSize(size int) Option {
+	return func(opts *Options) {
+		opts.fragmentSize = size
+	}
+}
+
+// WithResetAfter 设置连接在这个方向传输n字节后被重置,0表示不重置
+// 已传输的字节数超过n时下一次读写立即重置
+func WithResetAfter(n int64) Option {
+	return func(opts *Options) {
+		opts.resetAfter = n
+	}
+}
+
+// WithSeed 设置丢弃和修改字节、延迟抖动使用的随机数种子,用于复现测试结果
+func WithSeed(seed int64) Option {
+	return func(opts *Options) {
+		opts.seed = seed
+	}
+}
+
+// Faults 一个方向的故障配置,可以被多个连接共享,测试运行中通过Update调整
+type Faults struct {
+	limiter *throttle.Limiter
+
+	mu   sync.Mutex
+	opts Options
+	rand *rand.Rand
+}
+
+// NewFaults 创建故障配置,没有选项时不注入故障
+func NewFaults(options ...Option) *Faults {
+	f := &Faults{
+		limiter: throttle.NewLimiter(0, 0),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	f.Update(options...)
+	return f
+}
+
+// Update 在当前配置上应用选项,对已经包装的连接立即生效
+func (f *Faults) Update(options ...Option) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seed, bandwidth := f.opts.seed, f.opts.bandwidth
+	for _, option := range options {
+		option(&f.opts)
+	}
+	if f.opts.seed != seed {
+		f.rand.Seed(f.opts.seed)
+	}
+	if f.opts.bandwidth != bandwidth {
+		f.limiter.SetRate(f.opts.bandwidth, 0)
+	}
+}
+
+// Clear 清除所有故障
+func (f *Faults) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opts = Options{seed: f.opts.seed}
+	f.limiter.SetRate(0, 0)
+}
+
+func (f *Faults) options() Options {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.opts
+}
+
+// delay 返回本次读写的延迟
+func (f *Faults) delay(opts Options) time.Duration {
+	d := opts.latency
+	if opts.jitter > 0 {
+		f.mu.Lock()
+		d += time.Duration(f.rand.Int63n(int64(opts.jitter)))
+		f.mu.Unlock()
+	}
+	return d
+}
+
+// mangle 按概率丢弃和修改p中的字节,结果写入dst并返回,dst可以与p相同
+// 不丢弃和修改字节时直接返回p
+func (f *Faults) mangle(dst, p []byte, opts Options) []byte {
+	if opts.dropRate <= 0 && opts.corruptRate <= 0 {
+		return p
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, b := range p {
+		if opts.dropRate > 0 && f.rand.Float64() < opts.dropRate {
+			continue
+		}
+		if opts.corruptRate > 0 && f.rand.Float64() < opts.corruptRate {
+			b ^= byte(1 + f.rand.Intn(255))
+		}
+		dst = append(dst[:n], b)
+		n++
+	}
+	return dst[:n]
+}
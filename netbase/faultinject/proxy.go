@@ -0,0 +1,124 @@
+package faultinject
+
+import (
+	"github.com/lngwu11/toolgo/netbase"
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+)
+
+// Proxy 本地故障注入代理,把接收的连接转发到上游地址
+// 故障注入在面向客户端的连接上,连接被重置时客户端收到RST
+type Proxy struct {
+	listener net.Listener
+	upstream string
+	up, down *Faults
+	options  []client.Option
+
+	mu     sync.Mutex
+	conns  map[utils.ConnReadWriteCloser]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewProxy 在127.0.0.1的随机端口上启动代理,up为客户端到上游方向的故障,down为上游到客户端方向的故障,
+// nil时创建不注入故障的配置,可以通过Up和Down调整;options为连接上游的配置
+func NewProxy(upstream string, up, down *Faults, options ...client.Option) (*Proxy, error) {
+	if up == nil {
+		up = NewFaults()
+	}
+	if down == nil {
+		down = NewFaults()
+	}
+	// 不使用server.Server,重置连接需要直接访问tcp连接
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法绑定地址")
+	}
+	p := &Proxy{
+		listener: listener,
+		upstream: upstream,
+		up:       up,
+		down:     down,
+		options:  options,
+		conns:    make(map[utils.ConnReadWriteCloser]struct{}),
+	}
+	p.wg.Add(1)
+	go p.acceptLoop()
+	return p, nil
+}
+
+// Addr 返回代理的监听地址
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Up 返回客户端到上游方向的故障配置
+func (p *Proxy) Up() *Faults {
+	return p.up
+}
+
+// Down 返回上游到客户端方向的故障配置
+func (p *Proxy) Down() *Faults {
+	return p.down
+}
+
+// ActiveConnections 返回正在转发的连接数
+func (p *Proxy) ActiveConnections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Close 停止代理并关闭所有连接
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.mu.Unlock()
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) acceptLoop() {
+	defer p.wg.Done()
+	for {
+		c, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		// 保存包装后的连接,关闭时唤醒等待延迟或带宽的读写
+		conn := Wrap(utils.ToConnReadWriteCloser(c), p.up, p.down)
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			_ = conn.Close()
+			continue
+		}
+		p.conns[conn] = struct{}{}
+		p.wg.Add(1)
+		p.mu.Unlock()
+		go p.handle(conn)
+	}
+}
+
+func (p *Proxy) handle(conn utils.ConnReadWriteCloser) {
+	defer p.wg.Done()
+	defer func() {
+		_ = conn.Close()
+		p.mu.Lock()
+		delete(p.conns, conn)
+		p.mu.Unlock()
+	}()
+
+	upstream, err := client.NewTCPConnection(p.upstream, p.options...)
+	if err != nil {
+		return
+	}
+	_, _ = netbase.Relay(conn, upstream)
+}
@@ -0,0 +1,168 @@
+package netbase
+
+import (
+	"context"
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/lngwu11/toolgo/netbase/server"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+type ForwarderOption func(opts *ForwarderOptions)
+
+type ForwarderOptions struct {
+	// 监听配置
+	serverOptions []server.Option
+	// 连接上游的配置
+	clientOptions []client.Option
+	// 转发空闲超时时间
+	idleTimeout time.Duration
+	// 连接上游或转发出错时的回调
+	errorHandler func(err error)
+}
+
+// WithForwardServerOptions 设置监听配置,如TLS、连接数限制
+func WithForwardServerOptions(options ...server.Option) ForwarderOption {
+	return func(opts *ForwarderOptions) {
+		opts.serverOptions = append(opts.serverOptions, options...)
+	}
+}
+
+// WithForwardClientOptions 设置连接上游的配置,如TLS、拨号超时
+func WithForwardClientOptions(options ...client.Option) ForwarderOption {
+	return func(opts *ForwarderOptions) {
+		opts.clientOptions = append(opts.clientOptions, options...)
+	}
+}
+
+// WithForwardIdleTimeout 设置转发空闲超时时间,默认不超时
+func WithForwardIdleTimeout(idleTimeout time.Duration) ForwarderOption {
+	return func(opts *ForwarderOptions) {
+		opts.idleTimeout = idleTimeout
+	}
+}
+
+// WithForwardErrorHandler 设置连接上游或转发出错时的回调
+func WithForwardErrorHandler(handler func(err error)) ForwarderOption {
+	return func(opts *ForwarderOptions) {
+		opts.errorHandler = handler
+	}
+}
+
+func loadForwarderOptions(options ...ForwarderOption) *ForwarderOptions {
+	opts := new(ForwarderOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+func (opts *ForwarderOptions) reportError(err error) {
+	if opts.errorHandler != nil {
+		opts.errorHandler(err)
+	}
+}
+
+// ForwarderStats 转发统计
+type ForwarderStats struct {
+	server.Stats
+	// 客户端到上游的字节数
+	BytesUp int64
+	// 上游到客户端的字节数
+	BytesDown int64
+	// 连接上游失败次数
+	DialFailures uint64
+}
+
+// Forwarder tcp转发,把接收的连接轮询转发到上游地址
+type Forwarder struct {
+	server    *server.Server
+	upstreams []string
+	opts      *ForwarderOptions
+
+	next         atomic.Uint64
+	bytesUp      atomic.Int64
+	bytesDown    atomic.Int64
+	dialFailures atomic.Uint64
+}
+
+// NewForwarder 创建转发,调用Start后开始监听
+func NewForwarder(endpoint string, upstreams []string, options ...ForwarderOption) (*Forwarder, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("upstreams cannot be empty")
+	}
+	opts := loadForwarderOptions(options...)
+	return &Forwarder{
+		server:    server.NewServer(endpoint, opts.serverOptions...),
+		upstreams: append([]string(nil), upstreams...),
+		opts:      opts,
+	}, nil
+}
+
+// Start 绑定地址并在后台转发
+func (f *Forwarder) Start() error {
+	return f.server.Serve(f.handle)
+}
+
+// Addr 返回监听地址
+func (f *Forwarder) Addr() net.Addr {
+	return f.server.Addr()
+}
+
+// Stats 返回转发统计
+func (f *Forwarder) Stats() ForwarderStats {
+	return ForwarderStats{
+		Stats:        f.server.Stats(),
+		BytesUp:      f.bytesUp.Load(),
+		BytesDown:    f.bytesDown.Load(),
+		DialFailures: f.dialFailures.Load(),
+	}
+}
+
+// Shutdown 停止接收新连接并等待转发中的连接结束,ctx到期时强制关闭
+func (f *Forwarder) Shutdown(ctx context.Context) error {
+	return f.server.Shutdown(ctx)
+}
+
+// Close 立即停止转发
+func (f *Forwarder) Close() error {
+	return f.server.Close()
+}
+
+func (f *Forwarder) handle(ctx context.Context, conn utils.ConnReadWriteCloser) {
+	upstream, err := f.dialUpstream(ctx)
+	if err != nil {
+		f.opts.reportError(errors.Wrapf(err, "无法连接上游 [remote=%v]", conn.RemoteAddr()))
+		return
+	}
+
+	_, err = Relay(conn, upstream,
+		WithRelayIdleTimeout(f.opts.idleTimeout),
+		WithRelayCounters(&f.bytesUp, &f.bytesDown),
+	)
+	if err != nil {
+		f.opts.reportError(errors.Wrapf(err, "转发出错 [remote=%v, upstream=%v]", conn.RemoteAddr(), upstream.RemoteAddr()))
+	}
+}
+
+// dialUpstream 从轮询位置开始依次尝试上游地址,全部失败时返回最后一个错误
+func (f *Forwarder) dialUpstream(ctx context.Context) (utils.ConnReadWriteCloser, error) {
+	start := f.next.Add(1) - 1
+	var lastErr error
+	for i := 0; i < len(f.upstreams); i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		endpoint := f.upstreams[(start+uint64(i))%uint64(len(f.upstreams))]
+		conn, err := client.NewTCPConnection(endpoint, f.opts.clientOptions...)
+		if err == nil {
+			return conn, nil
+		}
+		f.dialFailures.Add(1)
+		lastErr = err
+	}
+	return nil, lastErr
+}
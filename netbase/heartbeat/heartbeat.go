@@ -0,0 +1,275 @@
+package heartbeat
+
+import (
+	"encoding/binary"
+	"github.com/lngwu11/toolgo/netbase/codec"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 默认心跳间隔
+	defaultInterval = 15 * time.Second
+	// 默认等待pong的超时时间
+	defaultTimeout = 5 * time.Second
+)
+
+// 帧类型,位于每帧的第一个字节
+const (
+	typeData byte = iota
+	typePing
+	typePong
+)
+
+var (
+	// ErrTimeout 超时没有收到pong,对端可能已经失效
+	ErrTimeout = errors.New("heartbeat timeout")
+	// ErrClosed 心跳已停止
+	ErrClosed = errors.New("heartbeat closed")
+	// errInvalidFrame 帧类型错误
+	errInvalidFrame = errors.New("invalid heartbeat frame")
+)
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 发送ping的间隔
+	interval time.Duration
+	// 等待pong的超时时间
+	timeout time.Duration
+	// 收到pong时回调往返时间
+	rttHandler func(rtt time.Duration)
+}
+
+// WithInterval 设置发送ping的间隔,默认15s,负数表示不主动发送ping,只响应对端的ping
+func WithInterval(interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.interval = interval
+	}
+}
+
+// WithTimeout 设置等待pong的超时时间,默认5s,超时后关闭连接
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.timeout = timeout
+	}
+}
+
+// WithRTTHandler 设置收到pong时的回调,参数为往返时间
+func WithRTTHandler(handler func(rtt time.Duration)) Option {
+	return func(opts *Options) {
+		opts.rttHandler = handler
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.interval == 0 {
+		opts.interval = defaultInterval
+	}
+	if opts.timeout <= 0 {
+		opts.timeout = defaultTimeout
+	}
+	return opts
+}
+
+// Heartbeat 在编解码器上增加应用层心跳,实现codec.Codec
+// 每帧增加1字节的类型: | 类型 1 | 数据 |,ping和pong的数据为8字节序号。
+// ping和pong在ReadFrame中处理,因此需要持续调用ReadFrame;超时没有收到pong时关闭连接。
+// 双方都需要使用Heartbeat包装编解码器,可以一端主动发送ping,另一端只响应
+type Heartbeat struct {
+	codec  codec.Codec
+	closer io.Closer
+	opts   *Options
+
+	// 保护codec的写入,心跳协程和调用者并发写
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	pendingSeq uint64
+	sentAt     time.Time
+	seq        uint64
+	pong       chan struct{}
+	rtt        atomic.Int64
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// New 包装编解码器并开始发送ping,closer在心跳失败或Close时关闭,通常为连接
+func New(c codec.Codec, closer io.Closer, options ...Option) *Heartbeat {
+	h := &Heartbeat{
+		codec:  c,
+		closer: closer,
+		opts:   loadOptions(options...),
+		pong:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if h.opts.interval > 0 {
+		go h.loop()
+	}
+	return h
+}
+
+// ReadFrame 读取一个数据帧,期间响应ping和处理pong
+func (h *Heartbeat) ReadFrame() ([]byte, error) {
+	for {
+		frame, err := h.codec.ReadFrame()
+		if err != nil {
+			// 心跳失败关闭连接导致的错误
+			if hbErr := h.Err(); hbErr != nil {
+				return nil, hbErr
+			}
+			return nil, err
+		}
+		if len(frame) == 0 {
+			return nil, errInvalidFrame
+		}
+
+		switch frame[0] {
+		case typeData:
+			return frame[1:], nil
+		case typePing:
+			if len(frame) != 9 {
+				return nil, errInvalidFrame
+			}
+			if err = h.writeControl(typePong, binary.BigEndian.Uint64(frame[1:])); err != nil {
+				return nil, err
+			}
+		case typePong:
+			if len(frame) != 9 {
+				return nil, errInvalidFrame
+			}
+			h.handlePong(binary.BigEndian.Uint64(frame[1:]))
+		default:
+			return nil, errInvalidFrame
+		}
+	}
+}
+
+// WriteFrame 写入一个数据帧
+func (h *Heartbeat) WriteFrame(frame []byte) error {
+	data := make([]byte, 1+len(frame))
+	data[0] = typeData
+	copy(data[1:], frame)
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.codec.WriteFrame(data)
+}
+
+func (h *Heartbeat) Flush() error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.codec.Flush()
+}
+
+// RTT 返回最近一次测得的往返时间,还没有收到pong时返回0
+func (h *Heartbeat) RTT() time.Duration {
+	return time.Duration(h.rtt.Load())
+}
+
+// Done 返回心跳停止时关闭的通道
+func (h *Heartbeat) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err 返回心跳停止的原因,超时为ErrTimeout,调用Close为ErrClosed
+func (h *Heartbeat) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Close 停止心跳并关闭连接
+func (h *Heartbeat) Close() error {
+	h.stop(ErrClosed)
+	return nil
+}
+
+func (h *Heartbeat) stop(err error) {
+	h.closeOnce.Do(func() {
+		h.err = err
+		close(h.done)
+		_ = h.closer.Close()
+	})
+}
+
+func (h *Heartbeat) writeControl(msgType byte, seq uint64) error {
+	frame := make([]byte, 9)
+	frame[0] = msgType
+	binary.BigEndian.PutUint64(frame[1:], seq)
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	if err := h.codec.WriteFrame(frame); err != nil {
+		return err
+	}
+	return h.codec.Flush()
+}
+
+func (h *Heartbeat) handlePong(seq uint64) {
+	h.mu.Lock()
+	if seq != h.pendingSeq || h.sentAt.IsZero() {
+		// 已经超时的pong
+		h.mu.Unlock()
+		return
+	}
+	rtt := time.Since(h.sentAt)
+	h.sentAt = time.Time{}
+	h.mu.Unlock()
+
+	h.rtt.Store(int64(rtt))
+	if h.opts.rttHandler != nil {
+		h.opts.rttHandler(rtt)
+	}
+	select {
+	case h.pong <- struct{}{}:
+	default:
+	}
+}
+
+// loop 每个间隔发送一个ping,并等待pong
+func (h *Heartbeat) loop() {
+	ticker := time.NewTicker(h.opts.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.done:
+			return
+		}
+
+		h.mu.Lock()
+		h.seq++
+		seq := h.seq
+		h.pendingSeq, h.sentAt = seq, time.Now()
+		h.mu.Unlock()
+		if err := h.writeControl(typePing, seq); err != nil {
+			h.stop(errors.Wrapf(err, "无法发送ping"))
+			return
+		}
+
+		timer := time.NewTimer(h.opts.timeout)
+		select {
+		case <-h.pong:
+			timer.Stop()
+		case <-timer.C:
+			h.stop(ErrTimeout)
+			return
+		case <-h.done:
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package heartbeat
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase"
+	"github.com/lngwu11/toolgo/netbase/codec"
+	"github.com/lngwu11/toolgo/netbase/internal/nettest"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func newCodec(t *testing.T, conn utils.ConnReadWriteCloser) codec.Codec {
+	c, err := codec.NewLengthFieldCodec(netbase.NewBufferConnection(conn, 0))
+	require.NoError(t, err)
+	return c
+}
+
+func TestHeartbeat(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	rtts := make(chan time.Duration, 16)
+	client := New(newCodec(t, a), a, WithInterval(20*time.Millisecond), WithTimeout(time.Second),
+		WithRTTHandler(func(rtt time.Duration) {
+			select {
+			case rtts <- rtt:
+			default:
+			}
+		}))
+	defer client.Close()
+	// 服务端只响应ping
+	server := New(newCodec(t, b), b, WithInterval(-1))
+	defer server.Close()
+
+	// 服务端回显数据帧
+	go func() {
+		for {
+			frame, err := server.ReadFrame()
+			if err != nil {
+				return
+			}
+			_ = server.WriteFrame(frame)
+			_ = server.Flush()
+		}
+	}()
+
+	for _, msg := range []string{"hello", "", "world"} {
+		require.NoError(t, client.WriteFrame([]byte(msg)))
+		require.NoError(t, client.Flush())
+		frame, err := client.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, msg, string(frame))
+	}
+
+	// 持续读取时处理pong
+	go func() {
+		for {
+			if _, err := client.ReadFrame(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case rtt := <-rtts:
+		require.Greater(t, rtt, time.Duration(0))
+	case <-time.After(time.Second):
+		t.Fatal("no pong received")
+	}
+	require.Greater(t, client.RTT(), time.Duration(0))
+
+	require.NoError(t, client.Close())
+	require.ErrorIs(t, client.Err(), ErrClosed)
+}
+
+func TestDeadPeer(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	// 对端只读取不响应
+	go func() {
+		_, _ = io.Copy(io.Discard, b)
+	}()
+
+	hb := New(newCodec(t, a), a, WithInterval(20*time.Millisecond), WithTimeout(50*time.Millisecond))
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := hb.ReadFrame()
+		readErr <- err
+	}()
+
+	select {
+	case <-hb.Done():
+		require.ErrorIs(t, hb.Err(), ErrTimeout)
+	case <-time.After(time.Second):
+		t.Fatal("dead peer not detected")
+	}
+	// 阻塞中的读取因连接关闭返回
+	require.ErrorIs(t, <-readErr, ErrTimeout)
+	_, err := a.Write([]byte("x"))
+	require.Error(t, err)
+}
@@ -0,0 +1,62 @@
+// Package nettest 提供netbase各个包测试共用的连接工具
+package nettest
+
+import (
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+)
+
+// TCPPair 返回一对相连的tcp连接,测试结束时关闭
+func TCPPair(t *testing.T) (utils.ConnReadWriteCloser, utils.ConnReadWriteCloser) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	a, b := utils.ToConnReadWriteCloser(c), utils.ToConnReadWriteCloser(<-accepted)
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+	return a, b
+}
+
+// EchoServer 启动原样返回数据的tcp服务,测试结束时关闭,返回监听地址
+func EchoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// RoundTripEcho 通过conn发送数据,检查回显的内容一致
+func RoundTripEcho(t *testing.T, conn io.ReadWriter) {
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+}
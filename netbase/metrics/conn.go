@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"github.com/lngwu11/toolgo/netbase/server"
+	"github.com/lngwu11/toolgo/utils"
+	"io"
+	"sync"
+	"time"
+)
+
+// Wrap 包装连接,读写和关闭计入key分组
+func (r *Registry) Wrap(conn utils.ConnReadWriteCloser, key string) utils.ConnReadWriteCloser {
+	g := r.group(key)
+	g.opened.Add(1)
+	return &meteredConn{
+		ConnReadWriteCloser: conn,
+		group:               g,
+		opened:              time.Now(),
+	}
+}
+
+// WrapByRemote 包装连接,按远端主机地址分组
+func (r *Registry) WrapByRemote(conn utils.ConnReadWriteCloser) utils.ConnReadWriteCloser {
+	return r.Wrap(conn, RemoteKey(conn))
+}
+
+// Handler 包装server.Handler,连接计入key分组,handler返回时关闭连接
+func (r *Registry) Handler(key string, handler server.Handler) server.Handler {
+	return func(ctx context.Context, conn utils.ConnReadWriteCloser) {
+		metered := r.Wrap(conn, key)
+		defer func() {
+			_ = metered.Close()
+		}()
+		handler(ctx, metered)
+	}
+}
+
+// meteredConn 统计读写的连接
+type meteredConn struct {
+	utils.ConnReadWriteCloser
+	group     *group
+	opened    time.Time
+	closeOnce sync.Once
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.ConnReadWriteCloser.Read(b)
+	c.group.readLatency.observe(time.Since(start))
+	c.group.bytesRead.Add(uint64(n))
+	if err != nil && err != io.EOF {
+		c.group.readErrors.Add(1)
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.ConnReadWriteCloser.Write(b)
+	c.group.writeLatency.observe(time.Since(start))
+	c.group.bytesWritten.Add(uint64(n))
+	if err != nil {
+		c.group.writeErrors.Add(1)
+	}
+	return n, err
+}
+
+func (c *meteredConn) Close() error {
+	err := c.ConnReadWriteCloser.Close()
+	c.closeOnce.Do(func() {
+		c.group.lifetime.observe(time.Since(c.opened))
+		c.group.closed.Add(1)
+	})
+	return err
+}
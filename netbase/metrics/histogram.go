@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// histogram 时间分布,counts最后一项为超过所有上界的计数
+type histogram struct {
+	bounds []time.Duration
+	counts []atomic.Uint64
+	sum    atomic.Int64
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// Bucket 分布中的一个区间,Count为不超过UpperBound的累计次数
+type Bucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// HistogramSnapshot 时间分布快照
+type HistogramSnapshot struct {
+	// 观测次数
+	Count uint64
+	// 观测值总和
+	Sum time.Duration
+	// 按上界递增的累计计数,不包含超过所有上界的部分
+	Buckets []Bucket
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Sum:     time.Duration(h.sum.Load()),
+		Buckets: make([]Bucket, len(h.bounds)),
+	}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		s.Buckets[i] = Bucket{UpperBound: bound, Count: cumulative}
+	}
+	// 由各区间计数求和,保证与累计计数一致
+	s.Count = cumulative + h.counts[len(h.bounds)].Load()
+	return s
+}
@@ -0,0 +1,219 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 默认指标名前缀
+	defaultNamespace = "netbase"
+	// 超过最大分组数后使用的分组名
+	OverflowKey = "other"
+)
+
+var (
+	// 默认读写耗时分布
+	defaultLatencyBuckets = []time.Duration{
+		100 * time.Microsecond, time.Millisecond, 10 * time.Millisecond,
+		100 * time.Millisecond, time.Second, 10 * time.Second,
+	}
+	// 默认连接存活时间分布
+	defaultLifetimeBuckets = []time.Duration{
+		time.Second, 10 * time.Second, time.Minute, 10 * time.Minute, time.Hour,
+	}
+)
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 指标名前缀
+	namespace string
+	// 读写耗时分布
+	latencyBuckets []time.Duration
+	// 连接存活时间分布
+	lifetimeBuckets []time.Duration
+	// 最大分组数
+	maxKeys int
+}
+
+// WithNamespace 设置Prometheus指标名前缀,默认netbase
+func WithNamespace(namespace string) Option {
+	return func(opts *Options) {
+		opts.namespace = namespace
+	}
+}
+
+// WithLatencyBuckets 设置读写耗时分布的上界
+func WithLatencyBuckets(buckets ...time.Duration) Option {
+	return func(opts *Options) {
+		opts.latencyBuckets = buckets
+	}
+}
+
+// WithLifetimeBuckets 设置连接存活时间分布的上界
+func WithLifetimeBuckets(buckets ...time.Duration) Option {
+	return func(opts *Options) {
+		opts.lifetimeBuckets = buckets
+	}
+}
+
+// WithMaxKeys 设置最大分组数(包含OverflowKey),超过后新分组合并到OverflowKey,
+// 按远端地址分组时用于限制指标数量,默认不限制
+func WithMaxKeys(max int) Option {
+	return func(opts *Options) {
+		opts.maxKeys = max
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if len(opts.namespace) == 0 {
+		opts.namespace = defaultNamespace
+	}
+	if len(opts.latencyBuckets) == 0 {
+		opts.latencyBuckets = defaultLatencyBuckets
+	}
+	if len(opts.lifetimeBuckets) == 0 {
+		opts.lifetimeBuckets = defaultLifetimeBuckets
+	}
+	opts.latencyBuckets = sortedBuckets(opts.latencyBuckets)
+	opts.lifetimeBuckets = sortedBuckets(opts.lifetimeBuckets)
+	return opts
+}
+
+func sortedBuckets(buckets []time.Duration) []time.Duration {
+	sorted := append([]time.Duration(nil), buckets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// Registry 按分组汇总连接指标,分组可以是监听名、上游名或远端地址
+type Registry struct {
+	opts *Options
+
+	mu     sync.RWMutex
+	groups map[string]*group
+}
+
+// NewRegistry 创建指标注册表
+func NewRegistry(options ...Option) *Registry {
+	return &Registry{
+		opts:   loadOptions(options...),
+		groups: make(map[string]*group),
+	}
+}
+
+// group 一个分组的计数
+type group struct {
+	opened       atomic.Uint64
+	closed       atomic.Uint64
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	readErrors   atomic.Uint64
+	writeErrors  atomic.Uint64
+	readLatency  *histogram
+	writeLatency *histogram
+	lifetime     *histogram
+}
+
+func (r *Registry) group(key string) *group {
+	r.mu.RLock()
+	g, ok := r.groups[key]
+	r.mu.RUnlock()
+	if ok {
+		return g
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g, ok = r.groups[key]; ok {
+		return g
+	}
+	if r.opts.maxKeys > 0 && key != OverflowKey {
+		// OverflowKey也计入最大分组数,其他分组最多maxKeys-1个
+		n := len(r.groups)
+		if _, ok = r.groups[OverflowKey]; ok {
+			n--
+		}
+		if n >= r.opts.maxKeys-1 {
+			if g, ok = r.groups[OverflowKey]; ok {
+				return g
+			}
+			key = OverflowKey
+		}
+	}
+	g = &group{
+		readLatency:  newHistogram(r.opts.latencyBuckets),
+		writeLatency: newHistogram(r.opts.latencyBuckets),
+		lifetime:     newHistogram(r.opts.lifetimeBuckets),
+	}
+	r.groups[key] = g
+	return g
+}
+
+// Snapshot 一个分组的指标快照
+type Snapshot struct {
+	// 分组名
+	Key string
+	// 已建立和已关闭的连接数
+	Opened uint64
+	Closed uint64
+	// 当前连接数
+	Active int64
+	// 读写字节数
+	BytesRead    uint64
+	BytesWritten uint64
+	// 读写错误数,不包含io.EOF
+	ReadErrors  uint64
+	WriteErrors uint64
+	// 读写耗时和连接存活时间分布
+	ReadLatency  HistogramSnapshot
+	WriteLatency HistogramSnapshot
+	Lifetime     HistogramSnapshot
+}
+
+// Snapshot 返回所有分组的指标快照,按分组名排序
+func (r *Registry) Snapshot() []Snapshot {
+	r.mu.RLock()
+	snapshots := make([]Snapshot, 0, len(r.groups))
+	for key, g := range r.groups {
+		closed := g.closed.Load()
+		opened := g.opened.Load()
+		snapshots = append(snapshots, Snapshot{
+			Key:          key,
+			Opened:       opened,
+			Closed:       closed,
+			Active:       int64(opened) - int64(closed),
+			BytesRead:    g.bytesRead.Load(),
+			BytesWritten: g.bytesWritten.Load(),
+			ReadErrors:   g.readErrors.Load(),
+			WriteErrors:  g.writeErrors.Load(),
+			ReadLatency:  g.readLatency.snapshot(),
+			WriteLatency: g.writeLatency.snapshot(),
+			Lifetime:     g.lifetime.snapshot(),
+		})
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Key < snapshots[j].Key })
+	return snapshots
+}
+
+// RemoteKey 返回连接远端的主机地址,不含端口,用于按远端地址分组
+func RemoteKey(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/lngwu11/toolgo/netbase/server"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	srv := server.NewServer("127.0.0.1:0")
+	require.NoError(t, srv.Serve(registry.Handler("echo", func(_ context.Context, conn utils.ConnReadWriteCloser) {
+		_, _ = io.Copy(conn, conn)
+	})))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", srv.Addr().String())
+		require.NoError(t, err)
+		_, err = c.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, c.(*net.TCPConn).CloseWrite())
+		got, err := io.ReadAll(c)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(got))
+		_ = c.Close()
+	}
+	require.NoError(t, srv.Shutdown(context.Background()))
+
+	snapshots := registry.Snapshot()
+	require.Len(t, snapshots, 1)
+	s := snapshots[0]
+	require.Equal(t, "echo", s.Key)
+	require.EqualValues(t, 2, s.Opened)
+	require.EqualValues(t, 2, s.Closed)
+	require.Zero(t, s.Active)
+	require.EqualValues(t, 10, s.BytesRead)
+	require.EqualValues(t, 10, s.BytesWritten)
+	require.Zero(t, s.ReadErrors)
+	require.EqualValues(t, 2, s.Lifetime.Count)
+	require.NotZero(t, s.ReadLatency.Count)
+
+	var buf bytes.Buffer
+	require.NoError(t, registry.WritePrometheus(&buf))
+	text := buf.String()
+	require.Contains(t, text, "# TYPE netbase_read_bytes_total counter\n")
+	require.Contains(t, text, `netbase_read_bytes_total{endpoint="echo"} 10`)
+	require.Contains(t, text, `netbase_connection_lifetime_seconds_bucket{endpoint="echo",le="+Inf"} 2`)
+	require.Contains(t, text, `netbase_connection_lifetime_seconds_count{endpoint="echo"} 2`)
+}
+
+func TestRegistryMaxKeys(t *testing.T) {
+	// 包含OverflowKey在内最多3个分组
+	registry := NewRegistry(WithMaxKeys(3), WithNamespace("app"))
+	for _, key := range []string{"a", "b", "c", "d"} {
+		a, b := net.Pipe()
+		conn := registry.Wrap(utils.ToConnReadWriteCloser(a), key)
+		_ = conn.Close()
+		_ = b.Close()
+	}
+
+	var keys []string
+	for _, s := range registry.Snapshot() {
+		keys = append(keys, s.Key)
+	}
+	require.Equal(t, []string{"a", "b", OverflowKey}, keys)
+	require.EqualValues(t, 2, registry.Snapshot()[2].Opened)
+
+	var buf bytes.Buffer
+	require.NoError(t, registry.WritePrometheus(&buf))
+	require.Contains(t, buf.String(), `app_connections_opened_total{endpoint="other"} 2`)
+
+	// 只有一个分组时全部合并到OverflowKey
+	registry = NewRegistry(WithMaxKeys(1))
+	for _, key := range []string{"a", "b"} {
+		a, b := net.Pipe()
+		_ = registry.Wrap(utils.ToConnReadWriteCloser(a), key).Close()
+		_ = b.Close()
+	}
+	snapshots := registry.Snapshot()
+	require.Len(t, snapshots, 1)
+	require.Equal(t, OverflowKey, snapshots[0].Key)
+	require.EqualValues(t, 2, snapshots[0].Opened)
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WritePrometheus 以Prometheus文本格式输出所有分组的指标,分组名作为endpoint标签
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	snapshots := r.Snapshot()
+	bw := bufio.NewWriter(w)
+	ns := r.opts.namespace
+
+	counter := func(name, help string, value func(s *Snapshot) uint64) {
+		writeHeader(bw, ns+"_"+name, help, "counter")
+		for i := range snapshots {
+			fmt.Fprintf(bw, "%s_%s{endpoint=%s} %d\n", ns, name, quote(snapshots[i].Key), value(&snapshots[i]))
+		}
+	}
+	counter("connections_opened_total", "Total number of connections opened.",
+		func(s *Snapshot) uint64 { return s.Opened })
+	counter("connections_closed_total", "Total number of connections closed.",
+		func(s *Snapshot) uint64 { return s.Closed })
+
+	writeHeader(bw, ns+"_connections_active", "Number of currently open connections.", "gauge")
+	for _, s := range snapshots {
+		fmt.Fprintf(bw, "%s_connections_active{endpoint=%s} %d\n", ns, quote(s.Key), s.Active)
+	}
+
+	counter("read_bytes_total", "Total number of bytes read.",
+		func(s *Snapshot) uint64 { return s.BytesRead })
+	counter("written_bytes_total", "Total number of bytes written.",
+		func(s *Snapshot) uint64 { return s.BytesWritten })
+	counter("read_errors_total", "Total number of read errors, excluding EOF.",
+		func(s *Snapshot) uint64 { return s.ReadErrors })
+	counter("write_errors_total", "Total number of write errors.",
+		func(s *Snapshot) uint64 { return s.WriteErrors })
+
+	hist := func(name, help string, value func(s *Snapshot) HistogramSnapshot) {
+		writeHeader(bw, ns+"_"+name, help, "histogram")
+		for i := range snapshots {
+			writeHistogram(bw, ns+"_"+name, quote(snapshots[i].Key), value(&snapshots[i]))
+		}
+	}
+	hist("read_duration_seconds", "Duration of read calls.",
+		func(s *Snapshot) HistogramSnapshot { return s.ReadLatency })
+	hist("write_duration_seconds", "Duration of write calls.",
+		func(s *Snapshot) HistogramSnapshot { return s.WriteLatency })
+	hist("connection_lifetime_seconds", "Lifetime of closed connections.",
+		func(s *Snapshot) HistogramSnapshot { return s.Lifetime })
+
+	return bw.Flush()
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeHistogram(w io.Writer, name, endpoint string, h HistogramSnapshot) {
+	for _, b := range h.Buckets {
+		fmt.Fprintf(w, "%s_bucket{endpoint=%s,le=\"%s\"} %d\n", name, endpoint, seconds(b.UpperBound), b.Count)
+	}
+	fmt.Fprintf(w, "%s_bucket{endpoint=%s,le=\"+Inf\"} %d\n", name, endpoint, h.Count)
+	fmt.Fprintf(w, "%s_sum{endpoint=%s} %s\n", name, endpoint, seconds(h.Sum))
+	fmt.Fprintf(w, "%s_count{endpoint=%s} %d\n", name, endpoint, h.Count)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// quote 按Prometheus标签值规则转义
+func quote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
@@ -0,0 +1,76 @@
+package mux
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// 协议版本
+	protoVersion byte = 0
+	// 帧头长度: 版本(1) + 类型(1) + 标志(2) + 流ID(4) + 长度(4)
+	headerSize = 12
+	// 协议规定的流初始窗口
+	initialStreamWindow = 256 * 1024
+)
+
+// 帧类型
+const (
+	// typeData 数据帧,长度为数据长度
+	typeData byte = iota
+	// typeWindowUpdate 窗口更新帧,长度为窗口增量
+	typeWindowUpdate
+	// typePing ping帧,长度为ping序号
+	typePing
+	// typeGoAway 关闭会话帧,长度为原因
+	typeGoAway
+)
+
+// 帧标志
+const (
+	// flagSYN 新建流或ping请求
+	flagSYN uint16 = 1 << iota
+	// flagACK 确认新建流或ping响应
+	flagACK
+	// flagFIN 半关闭流
+	flagFIN
+	// flagRST 重置流
+	flagRST
+)
+
+// go away原因
+const (
+	goAwayNormal uint32 = iota
+	goAwayProtoErr
+	goAwayInternalErr
+)
+
+// header 帧头: | 版本 1 | 类型 1 | 标志 2 | 流ID 4 | 长度 4 |
+type header [headerSize]byte
+
+func (h header) version() byte {
+	return h[0]
+}
+
+func (h header) msgType() byte {
+	return h[1]
+}
+
+func (h header) flags() uint16 {
+	return binary.BigEndian.Uint16(h[2:4])
+}
+
+func (h header) streamID() uint32 {
+	return binary.BigEndian.Uint32(h[4:8])
+}
+
+func (h header) length() uint32 {
+	return binary.BigEndian.Uint32(h[8:12])
+}
+
+func (h *header) encode(msgType byte, flags uint16, streamID, length uint32) {
+	h[0] = protoVersion
+	h[1] = msgType
+	binary.BigEndian.PutUint16(h[2:4], flags)
+	binary.BigEndian.PutUint32(h[4:8], streamID)
+	binary.BigEndian.PutUint32(h[8:12], length)
+}
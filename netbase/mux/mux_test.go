@@ -0,0 +1,269 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase"
+	"github.com/lngwu11/toolgo/netbase/internal/nettest"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func sessionPair(t *testing.T, options ...Option) (*Session, *Session) {
+	a, b := nettest.TCPPair(t)
+	client, server := Client(a, options...), Server(b, options...)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+func echo(_ context.Context, conn utils.ConnReadWriteCloser) {
+	_, _ = io.Copy(conn, conn)
+	_ = conn.CloseWrite()
+}
+
+func TestStreams(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	// 经过缓冲连接时每帧写入后Flush
+	client := Client(netbase.NewBufferConnection(a, 0))
+	server := Server(netbase.NewBufferConnection(b, 0))
+	defer client.Close()
+	go func() {
+		_ = server.Serve(context.Background(), echo)
+	}()
+
+	// 数据量超过接收窗口,依赖窗口更新
+	payload := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := client.Open()
+			require.NoError(t, err)
+			defer stream.Close()
+			go func() {
+				_, _ = stream.Write(payload)
+				_ = stream.CloseWrite()
+			}()
+			got, err := io.ReadAll(stream)
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(payload, got))
+		}()
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		return client.NumStreams() == 0 && server.NumStreams() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// 服务端也可以打开流
+	go func() {
+		_ = client.Serve(context.Background(), echo)
+	}()
+	stream, err := server.Open()
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), stream.ID()%2)
+	_, err = stream.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseWrite())
+	got, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(got))
+}
+
+func TestFlowControl(t *testing.T) {
+	client, server := sessionPair(t)
+
+	// 对端不读取时写满窗口后阻塞
+	blocked, err := client.Open()
+	require.NoError(t, err)
+	_, err = blocked.Write([]byte("x"))
+	require.NoError(t, err)
+	accepted, err := server.Accept()
+	require.NoError(t, err)
+
+	require.NoError(t, blocked.SetWriteDeadline(time.Now().Add(100*time.Millisecond)))
+	n, err := blocked.Write(make([]byte, 2*initialStreamWindow))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, initialStreamWindow-1, n)
+
+	// 其他流不受影响
+	go func() {
+		stream, err := server.Accept()
+		if err == nil {
+			echo(context.Background(), stream)
+		}
+	}()
+	other, err := client.Open()
+	require.NoError(t, err)
+	_, err = other.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, other.CloseWrite())
+	got, err := io.ReadAll(other)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+
+	// 读取后窗口恢复
+	require.NoError(t, blocked.SetWriteDeadline(time.Time{}))
+	done := make(chan error, 1)
+	go func() {
+		_, err := blocked.Write(make([]byte, initialStreamWindow))
+		done <- err
+	}()
+	_, err = io.CopyN(io.Discard, accepted, 2*initialStreamWindow)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+
+	// 读超时
+	require.NoError(t, accepted.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = accepted.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestHalfClose(t *testing.T) {
+	client, server := sessionPair(t)
+
+	stream, err := client.Open()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseWrite())
+	_, err = stream.Write([]byte("x"))
+	require.ErrorIs(t, err, ErrStreamClosed)
+
+	accepted, err := server.Accept()
+	require.NoError(t, err)
+	got, err := io.ReadAll(accepted)
+	require.NoError(t, err)
+	require.Empty(t, got)
+
+	// 关闭写方向后仍然可以读
+	_, err = accepted.Write([]byte("reply"))
+	require.NoError(t, err)
+	require.NoError(t, accepted.CloseWrite())
+	got, err = io.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, "reply", string(got))
+
+	// 关闭读方向后对端的写入不会阻塞
+	stream, err = client.Open()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseRead())
+	_, err = stream.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	accepted, err = server.Accept()
+	require.NoError(t, err)
+	_, err = accepted.Write(make([]byte, 4*initialStreamWindow))
+	require.NoError(t, err)
+}
+
+func TestGoAway(t *testing.T) {
+	client, server := sessionPair(t)
+
+	stream, err := client.Open()
+	require.NoError(t, err)
+	accepted, err := server.Accept()
+	require.NoError(t, err)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	require.Eventually(t, func() bool {
+		_, err := client.Open()
+		return err == ErrRemoteGoAway
+	}, time.Second, 10*time.Millisecond)
+
+	// 已有的流不受影响,全部关闭后会话关闭
+	_, err = stream.Write([]byte("last"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+	got, err := io.ReadAll(accepted)
+	require.NoError(t, err)
+	require.Equal(t, "last", string(got))
+	require.NoError(t, accepted.Close())
+
+	require.NoError(t, <-shutdown)
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("client session not closed")
+	}
+	_, err = client.Open()
+	require.ErrorIs(t, err, ErrSessionClosed)
+}
+
+func TestKeepAlive(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	client := Client(a, WithKeepAlive(20*time.Millisecond, 50*time.Millisecond))
+	defer client.Close()
+
+	server := Server(b)
+	rtt, err := client.Ping()
+	require.NoError(t, err)
+	require.Greater(t, rtt, time.Duration(0))
+
+	// 对端不再响应时心跳超时关闭会话
+	_ = server.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("client session not closed")
+	}
+
+	a, b = nettest.TCPPair(t)
+	client = Client(a, WithKeepAlive(20*time.Millisecond, 50*time.Millisecond))
+	defer client.Close()
+	// 对端只读取不响应
+	go func() {
+		_, _ = io.Copy(io.Discard, b)
+	}()
+	select {
+	case <-client.Done():
+		require.ErrorIs(t, client.Err(), ErrKeepAliveTimeout)
+	case <-time.After(time.Second):
+		t.Fatal("keepalive not timed out")
+	}
+}
+
+func TestStreamIDParity(t *testing.T) {
+	for _, id := range []uint32{0, 2} {
+		a, b := nettest.TCPPair(t)
+		server := Server(b, WithKeepAlive(-1, 0))
+
+		// 服务端的流ID为偶数,对端不能使用
+		var h header
+		h.encode(typeWindowUpdate, flagSYN, id, 0)
+		_, err := a.Write(h[:])
+		require.NoError(t, err)
+
+		select {
+		case <-server.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("session not closed [id=%v]", id)
+		}
+		require.Error(t, server.Err())
+		require.NotErrorIs(t, server.Err(), ErrSessionClosed)
+		require.Zero(t, server.NumStreams())
+	}
+}
+
+func TestControlQueueLimit(t *testing.T) {
+	client, _ := sessionPair(t, WithKeepAlive(-1, 0))
+
+	// 发送循环只在收到通知后取走控制帧
+	client.controlMu.Lock()
+	client.control = make([]header, maxQueuedControl)
+	client.controlMu.Unlock()
+	require.Error(t, client.queueControl(typePing, flagACK, 0, 1))
+}
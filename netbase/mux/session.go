@@ -0,0 +1,601 @@
+package mux
+
+import (
+	"bufio"
+	"context"
+	"github.com/lngwu11/toolgo/netbase/server"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认流接收窗口
+	defaultMaxStreamWindow = initialStreamWindow
+	// 默认心跳间隔
+	defaultKeepAliveInterval = 30 * time.Second
+	// 默认等待Accept的流数量
+	defaultAcceptBacklog = 256
+	// 默认写连接超时时间
+	defaultWriteTimeout = 10 * time.Second
+	// 每个数据帧的最大长度,避免大块写入长时间占用连接
+	maxDataFrameSize = 32 * 1024
+	// 等待发送的控制帧上限,对端只发送不读取时控制帧持续堆积,超出视为协议错误
+	maxQueuedControl = 4096
+)
+
+var (
+	// ErrSessionClosed 会话已关闭
+	ErrSessionClosed = errors.New("mux session closed")
+	// ErrRemoteGoAway 对端不再接受新的流
+	ErrRemoteGoAway = errors.New("mux remote go away")
+	// ErrStreamsExhausted 流ID已用完
+	ErrStreamsExhausted = errors.New("mux stream ids exhausted")
+	// ErrKeepAliveTimeout 心跳超时
+	ErrKeepAliveTimeout = errors.New("mux keepalive timeout")
+	// ErrStreamReset 流被对端重置
+	ErrStreamReset = errors.New("mux stream reset")
+	// ErrStreamClosed 流的写方向已关闭
+	ErrStreamClosed = errors.New("mux stream closed")
+)
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 流接收窗口
+	maxStreamWindow uint32
+	// 心跳间隔和超时时间
+	keepAliveInterval time.Duration
+	keepAliveTimeout  time.Duration
+	// 等待Accept的流数量
+	acceptBacklog int
+	// 写连接超时时间
+	writeTimeout time.Duration
+}
+
+// WithMaxStreamWindow 设置每个流的接收窗口,默认且最小为256KB
+// 窗口决定了对端在本端读取前最多可以发送的数据量
+func WithMaxStreamWindow(size uint32) Option {
+	return func(opts *Options) {
+		opts.maxStreamWindow = size
+	}
+}
+
+// WithKeepAlive 设置心跳间隔和超时时间,默认30s,超时时间为0时与间隔相同,间隔为负数时关闭心跳
+func WithKeepAlive(interval, timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.keepAliveInterval = interval
+		opts.keepAliveTimeout = timeout
+	}
+}
+
+// WithAcceptBacklog 设置等待Accept的流数量,默认256,超出时新的流被重置
+func WithAcceptBacklog(backlog int) Option {
+	return func(opts *Options) {
+		opts.acceptBacklog = backlog
+	}
+}
+
+// WithWriteTimeout 设置写连接超时时间,默认10s,超时后会话关闭
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.writeTimeout = timeout
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.maxStreamWindow < initialStreamWindow {
+		opts.maxStreamWindow = defaultMaxStreamWindow
+	}
+	if opts.keepAliveInterval == 0 {
+		opts.keepAliveInterval = defaultKeepAliveInterval
+	}
+	if opts.keepAliveTimeout <= 0 {
+		opts.keepAliveTimeout = opts.keepAliveInterval
+	}
+	if opts.acceptBacklog <= 0 {
+		opts.acceptBacklog = defaultAcceptBacklog
+	}
+	if opts.writeTimeout <= 0 {
+		opts.writeTimeout = defaultWriteTimeout
+	}
+	return opts
+}
+
+// Session 在一个连接上复用多个双向的流
+// 客户端打开的流ID为奇数,服务端为偶数,双方都可以打开和接受流
+type Session struct {
+	conn     utils.ConnReadWriteCloser
+	opts     *Options
+	reader   *bufio.Reader
+	isClient bool
+
+	// 写连接互斥锁,保证帧完整写入
+	sendMu sync.Mutex
+	// 接收循环中产生的控制帧,由发送循环写入,避免接收循环阻塞在写连接上
+	controlMu     sync.Mutex
+	control       []header
+	controlNotify chan struct{}
+
+	mu           sync.Mutex
+	nextID       uint32
+	streams      map[uint32]*Stream
+	localGoAway  bool
+	remoteGoAway bool
+	pingID       uint32
+	pings        map[uint32]chan struct{}
+	// 流数量变为0时关闭,用于Shutdown等待
+	idle chan struct{}
+
+	acceptCh chan *Stream
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// Client 在连接上创建客户端会话
+func Client(conn utils.ConnReadWriteCloser, options ...Option) *Session {
+	return newSession(conn, true, options...)
+}
+
+// Server 在连接上创建服务端会话
+func Server(conn utils.ConnReadWriteCloser, options ...Option) *Session {
+	return newSession(conn, false, options...)
+}
+
+func newSession(conn utils.ConnReadWriteCloser, isClient bool, options ...Option) *Session {
+	opts := loadOptions(options...)
+	s := &Session{
+		conn:          conn,
+		opts:          opts,
+		reader:        bufio.NewReader(conn),
+		isClient:      isClient,
+		controlNotify: make(chan struct{}, 1),
+		nextID:        2,
+		streams:       make(map[uint32]*Stream),
+		pings:         make(map[uint32]chan struct{}),
+		acceptCh:      make(chan *Stream, opts.acceptBacklog),
+		done:          make(chan struct{}),
+	}
+	if isClient {
+		s.nextID = 1
+	}
+	go s.recvLoop()
+	go s.sendLoop()
+	if opts.keepAliveInterval > 0 {
+		go s.keepAliveLoop()
+	}
+	return s
+}
+
+// Open 打开一个新的流
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	if s.remoteGoAway {
+		s.mu.Unlock()
+		return nil, ErrRemoteGoAway
+	}
+	if s.nextID >= math.MaxUint32-1 {
+		s.mu.Unlock()
+		return nil, ErrStreamsExhausted
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(s, id)
+	if !s.addStream(stream) {
+		s.mu.Unlock()
+		return nil, errors.Errorf("重复的流ID [stream=%v]", id)
+	}
+	s.mu.Unlock()
+
+	if err := s.writeFrame(typeWindowUpdate, flagSYN, id, s.opts.maxStreamWindow-initialStreamWindow, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept 等待对端打开的流
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case stream := <-s.acceptCh:
+		return stream, nil
+	case <-s.done:
+		return nil, s.Err()
+	}
+}
+
+// Serve 接受对端打开的流并交给handler处理,handler返回后关闭流,会话关闭时返回
+// 传入handler的ctx在会话关闭时取消
+func (s *Session) Serve(ctx context.Context, handler server.Handler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.Close()
+	})
+	defer stop()
+
+	for {
+		stream, err := s.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer stream.Close()
+			handler(ctx, stream)
+		}()
+	}
+}
+
+// Ping 发送ping并等待响应,返回往返时间
+func (s *Session) Ping() (time.Duration, error) {
+	s.mu.Lock()
+	id := s.pingID
+	s.pingID++
+	ch := make(chan struct{})
+	s.pings[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pings, id)
+		s.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := s.writeFrame(typePing, flagSYN, 0, id, nil); err != nil {
+		return 0, err
+	}
+	timer := time.NewTimer(s.opts.keepAliveTimeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-timer.C:
+		return 0, ErrKeepAliveTimeout
+	case <-s.done:
+		return 0, s.Err()
+	}
+}
+
+// GoAway 通知对端不再接受新的流,已有的流不受影响
+func (s *Session) GoAway() error {
+	s.mu.Lock()
+	s.localGoAway = true
+	s.mu.Unlock()
+	return s.writeFrame(typeGoAway, 0, 0, goAwayNormal, nil)
+}
+
+// Shutdown 发送go away后等待所有流关闭再关闭会话,ctx结束时直接关闭会话
+func (s *Session) Shutdown(ctx context.Context) error {
+	_ = s.GoAway()
+	for {
+		s.mu.Lock()
+		if len(s.streams) == 0 {
+			s.mu.Unlock()
+			return s.Close()
+		}
+		if s.idle == nil {
+			s.idle = make(chan struct{})
+		}
+		idle := s.idle
+		s.mu.Unlock()
+
+		select {
+		case <-idle:
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			_ = s.Close()
+			return ctx.Err()
+		}
+	}
+}
+
+// Close 发送go away并关闭会话和所有的流
+func (s *Session) Close() error {
+	if s.isClosed() {
+		return nil
+	}
+	_ = s.GoAway()
+	s.closeWithErr(ErrSessionClosed)
+	return nil
+}
+
+// NumStreams 返回未关闭的流数量
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// Done 返回会话关闭时关闭的通道
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err 返回会话关闭的原因,未关闭时返回nil
+func (s *Session) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+func (s *Session) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) closeWithErr(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		_ = s.conn.Close()
+
+		s.mu.Lock()
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+		for _, stream := range streams {
+			stream.notifyRead()
+			stream.notifyWrite()
+		}
+	})
+}
+
+// addStream 流ID已存在时返回false,调用者持有s.mu
+func (s *Session) addStream(stream *Stream) bool {
+	if _, ok := s.streams[stream.id]; ok {
+		return false
+	}
+	s.streams[stream.id] = stream
+	return true
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+	if len(s.streams) == 0 && s.idle != nil {
+		close(s.idle)
+		s.idle = nil
+	}
+}
+
+func (s *Session) getStream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+// writeFrame 写入一个完整的帧,写失败时关闭会话
+func (s *Session) writeFrame(msgType byte, flags uint16, id, length uint32, data []byte) error {
+	var h header
+	h.encode(msgType, flags, id, length)
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.isClosed() {
+		return ErrSessionClosed
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.opts.writeTimeout))
+	err := s.write(h[:], data)
+	_ = s.conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		s.closeWithErr(errors.Wrapf(err, "无法写入帧 [type=%v, stream=%v]", msgType, id))
+		return err
+	}
+	return nil
+}
+
+func (s *Session) write(h, data []byte) error {
+	if _, err := s.conn.Write(h); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if _, err := s.conn.Write(data); err != nil {
+			return err
+		}
+	}
+	if f, ok := s.conn.(utils.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// queueControl 缓存控制帧,由发送循环写入,超出maxQueuedControl时返回错误
+func (s *Session) queueControl(msgType byte, flags uint16, id, length uint32) error {
+	var h header
+	h.encode(msgType, flags, id, length)
+	s.controlMu.Lock()
+	if len(s.control) >= maxQueuedControl {
+		s.controlMu.Unlock()
+		return errors.Errorf("等待发送的控制帧过多 [count=%v]", maxQueuedControl)
+	}
+	s.control = append(s.control, h)
+	s.controlMu.Unlock()
+	select {
+	case s.controlNotify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *Session) sendLoop() {
+	for {
+		select {
+		case <-s.controlNotify:
+		case <-s.done:
+			return
+		}
+		s.controlMu.Lock()
+		control := s.control
+		s.control = nil
+		s.controlMu.Unlock()
+		for _, h := range control {
+			if err := s.writeFrame(h.msgType(), h.flags(), h.streamID(), h.length(), nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Session) keepAliveLoop() {
+	ticker := time.NewTicker(s.opts.keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Ping(); errors.Is(err, ErrKeepAliveTimeout) {
+				s.closeWithErr(err)
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Session) recvLoop() {
+	var h header
+	for {
+		if _, err := io.ReadFull(s.reader, h[:]); err != nil {
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
+				err = ErrSessionClosed
+			}
+			s.closeWithErr(err)
+			return
+		}
+		if h.version() != protoVersion {
+			s.protocolError(errors.Errorf("不支持的协议版本 [version=%v]", h.version()))
+			return
+		}
+
+		var err error
+		switch h.msgType() {
+		case typeData, typeWindowUpdate:
+			err = s.handleStreamFrame(h)
+		case typePing:
+			err = s.handlePing(h)
+		case typeGoAway:
+			s.mu.Lock()
+			s.remoteGoAway = true
+			s.mu.Unlock()
+			if h.length() != goAwayNormal {
+				s.closeWithErr(errors.Errorf("对端异常关闭会话 [reason=%v]", h.length()))
+				return
+			}
+		default:
+			err = errors.Errorf("未知的帧类型 [type=%v]", h.msgType())
+		}
+		if err != nil {
+			s.protocolError(err)
+			return
+		}
+	}
+}
+
+func (s *Session) protocolError(err error) {
+	_ = s.writeFrame(typeGoAway, 0, 0, goAwayProtoErr, nil)
+	s.closeWithErr(err)
+}
+
+func (s *Session) handleStreamFrame(h header) error {
+	id, flags := h.streamID(), h.flags()
+	if flags&flagSYN != 0 {
+		if err := s.incomingStream(id); err != nil {
+			return err
+		}
+	}
+
+	stream := s.getStream(id)
+	if stream == nil {
+		// 流已经关闭或被重置,丢弃数据
+		if h.msgType() == typeData {
+			if _, err := s.reader.Discard(int(h.length())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if h.msgType() == typeData {
+		if err := stream.receive(s.reader, h.length()); err != nil {
+			return err
+		}
+	} else {
+		stream.increaseSendWindow(h.length())
+	}
+	stream.handleFlags(flags)
+	return nil
+}
+
+// incomingStream 创建对端打开的流,不再接受新的流或等待队列已满时重置
+// 对端只能使用与本端奇偶性相反的流ID,否则会与本端Open分配的ID冲突
+func (s *Session) incomingStream(id uint32) error {
+	if id == 0 || (id%2 == 1) == s.isClient {
+		return errors.Errorf("无效的流ID [stream=%v]", id)
+	}
+	s.mu.Lock()
+	if s.localGoAway {
+		s.mu.Unlock()
+		return s.queueControl(typeWindowUpdate, flagRST, id, 0)
+	}
+	stream := newStream(s, id)
+	if !s.addStream(stream) {
+		s.mu.Unlock()
+		return errors.Errorf("重复的流ID [stream=%v]", id)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.acceptCh <- stream:
+		return s.queueControl(typeWindowUpdate, flagACK, id, s.opts.maxStreamWindow-initialStreamWindow)
+	default:
+		s.removeStream(id)
+		return s.queueControl(typeWindowUpdate, flagRST, id, 0)
+	}
+}
+
+func (s *Session) handlePing(h header) error {
+	if h.flags()&flagSYN != 0 {
+		return s.queueControl(typePing, flagACK, 0, h.length())
+	}
+	s.mu.Lock()
+	ch, ok := s.pings[h.length()]
+	if ok {
+		delete(s.pings, h.length())
+	}
+	s.mu.Unlock()
+	if ok {
+		close(ch)
+	}
+	return nil
+}
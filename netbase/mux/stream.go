@@ -0,0 +1,329 @@
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stream 会话中的一个双向流,实现utils.ConnReadWriteCloser
+// 每个流有独立的接收窗口,本端不读取时对端的写入最终阻塞,不影响其他流
+type Stream struct {
+	id      uint32
+	session *Session
+
+	mu sync.Mutex
+	// 已接收未读取的数据
+	recvBuf bytes.Buffer
+	// 对端还可以发送的数据量
+	recvWindow uint32
+	// 本端还可以发送的数据量
+	sendWindow uint32
+	// 本端关闭读方向,之后收到的数据被丢弃
+	readClosed bool
+	// 本端已发送FIN
+	writeClosed bool
+	// 对端已发送FIN
+	remoteClosed bool
+	// 流被重置
+	reset bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	// 状态变化时唤醒阻塞中的Read/Write
+	readNotify  chan struct{}
+	writeNotify chan struct{}
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		id:          id,
+		session:     session,
+		recvWindow:  session.opts.maxStreamWindow,
+		sendWindow:  initialStreamWindow,
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+// ID 返回流ID
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.recvBuf.Len() > 0 {
+			n, _ := s.recvBuf.Read(p)
+			delta := s.windowDelta()
+			s.mu.Unlock()
+			if delta > 0 {
+				s.updateWindow(delta)
+			}
+			return n, nil
+		}
+		if s.reset {
+			s.mu.Unlock()
+			return 0, ErrStreamReset
+		}
+		if s.remoteClosed || s.readClosed {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := s.readDeadline
+		s.mu.Unlock()
+
+		if err := s.wait(s.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// windowDelta 读取后归还的窗口,累计达到窗口一半时才发送窗口更新,调用者持有s.mu
+func (s *Stream) windowDelta() uint32 {
+	if s.remoteClosed {
+		return 0
+	}
+	max := s.session.opts.maxStreamWindow
+	delta := max - s.recvWindow - uint32(s.recvBuf.Len())
+	if delta < max/2 {
+		return 0
+	}
+	s.recvWindow += delta
+	return delta
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		s.mu.Lock()
+		if s.reset {
+			s.mu.Unlock()
+			return written, ErrStreamReset
+		}
+		if s.writeClosed {
+			s.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+		if s.sendWindow == 0 {
+			deadline := s.writeDeadline
+			s.mu.Unlock()
+			if err := s.wait(s.writeNotify, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := uint32(len(p))
+		if n > s.sendWindow {
+			n = s.sendWindow
+		}
+		if n > maxDataFrameSize {
+			n = maxDataFrameSize
+		}
+		s.sendWindow -= n
+		s.mu.Unlock()
+
+		if err := s.session.writeFrame(typeData, 0, s.id, n, p[:n]); err != nil {
+			return written, err
+		}
+		written += int(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait 等待状态变化,截止时间到达或会话关闭时返回错误
+func (s *Stream) wait(notify chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-notify:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-s.session.done:
+		return s.session.Err()
+	}
+}
+
+// CloseWrite 发送FIN,对端读完数据后返回io.EOF,本端仍然可以读
+func (s *Stream) CloseWrite() error {
+	s.mu.Lock()
+	if s.writeClosed || s.reset {
+		s.mu.Unlock()
+		return nil
+	}
+	s.writeClosed = true
+	s.mu.Unlock()
+	s.notifyWrite()
+
+	err := s.session.writeFrame(typeWindowUpdate, flagFIN, s.id, 0, nil)
+	s.maybeRemove()
+	return err
+}
+
+// CloseRead 关闭读方向,丢弃未读取和之后收到的数据
+func (s *Stream) CloseRead() error {
+	s.mu.Lock()
+	if s.readClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.readClosed = true
+	s.recvBuf.Reset()
+	// 归还丢弃的数据占用的窗口,避免对端阻塞
+	delta := s.session.opts.maxStreamWindow - s.recvWindow
+	s.recvWindow += delta
+	remove := s.remoteClosed || s.reset
+	s.mu.Unlock()
+	s.notifyRead()
+
+	if delta > 0 && !remove {
+		s.updateWindow(delta)
+	}
+	return nil
+}
+
+// updateWindow 由发送循环发送窗口更新,不阻塞读取
+func (s *Stream) updateWindow(delta uint32) {
+	if err := s.session.queueControl(typeWindowUpdate, 0, s.id, delta); err != nil {
+		s.session.closeWithErr(err)
+	}
+}
+
+// Close 关闭读写方向,对端仍然可以读完已发送的数据
+func (s *Stream) Close() error {
+	_ = s.CloseRead()
+	return s.CloseWrite()
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.session.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.session.RemoteAddr()
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	_ = s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.mu.Unlock()
+	s.notifyRead()
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.writeDeadline = t
+	s.mu.Unlock()
+	s.notifyWrite()
+	return nil
+}
+
+func (s *Stream) notifyRead() {
+	select {
+	case s.readNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Stream) notifyWrite() {
+	select {
+	case s.writeNotify <- struct{}{}:
+	default:
+	}
+}
+
+// receive 读取数据帧的数据,超出接收窗口视为协议错误
+func (s *Stream) receive(reader *bufio.Reader, length uint32) error {
+	if length == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	window, readClosed := s.recvWindow, s.readClosed
+	s.mu.Unlock()
+	if length > window {
+		return errors.Errorf("数据超出接收窗口 [stream=%v, length=%v, window=%v]", s.id, length, window)
+	}
+	if readClosed {
+		// 直接归还窗口
+		if _, err := reader.Discard(int(length)); err != nil {
+			return err
+		}
+		return s.session.queueControl(typeWindowUpdate, 0, s.id, length)
+	}
+
+	// 读取数据时不持有锁,避免阻塞Read
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.readClosed {
+		s.mu.Unlock()
+		return s.session.queueControl(typeWindowUpdate, 0, s.id, length)
+	}
+	s.recvWindow -= length
+	s.recvBuf.Write(data)
+	s.mu.Unlock()
+	s.notifyRead()
+	return nil
+}
+
+func (s *Stream) increaseSendWindow(delta uint32) {
+	if delta == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.sendWindow += delta
+	s.mu.Unlock()
+	s.notifyWrite()
+}
+
+func (s *Stream) handleFlags(flags uint16) {
+	if flags&(flagFIN|flagRST) == 0 {
+		return
+	}
+	s.mu.Lock()
+	if flags&flagFIN != 0 {
+		s.remoteClosed = true
+	}
+	if flags&flagRST != 0 {
+		s.reset = true
+	}
+	s.mu.Unlock()
+	s.notifyRead()
+	s.notifyWrite()
+	s.maybeRemove()
+}
+
+// maybeRemove 双方都关闭写方向或流被重置后从会话中移除
+func (s *Stream) maybeRemove() {
+	s.mu.Lock()
+	done := s.reset || (s.writeClosed && s.remoteClosed)
+	s.mu.Unlock()
+	if done {
+		s.session.removeStream(s.id)
+	}
+}
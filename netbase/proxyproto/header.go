@@ -0,0 +1,356 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// v1头部最大长度,包含\r\n
+	v1MaxLength = 107
+	// v2固定头部长度: 签名(12) + 版本命令(1) + 地址族协议(1) + 长度(2)
+	v2HeaderLength = 16
+	// v2 unix地址长度
+	v2UnixAddrLength = 108
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+var (
+	// ErrNoHeader 数据不是以PROXY协议头部开始
+	ErrNoHeader = errors.New("proxy protocol header not present")
+	// ErrInvalidHeader PROXY协议头部格式错误
+	ErrInvalidHeader = errors.New("invalid proxy protocol header")
+)
+
+// Command v2头部的命令
+type Command byte
+
+const (
+	// CommandLocal 连接由代理自身发起(如健康检查),地址信息应被忽略
+	CommandLocal Command = 0x0
+	// CommandProxy 连接由代理转发,地址为原始连接的地址
+	CommandProxy Command = 0x1
+)
+
+// v2地址族和传输协议
+const (
+	familyUnspec     byte = 0x00
+	familyTCP4       byte = 0x11
+	familyUDP4       byte = 0x12
+	familyTCP6       byte = 0x21
+	familyUDP6       byte = 0x22
+	familyUnixStream byte = 0x31
+	familyUnixDgram  byte = 0x32
+)
+
+// Header PROXY协议头部
+type Header struct {
+	// 版本,1或2
+	Version int
+	// 命令,v1总是CommandProxy
+	Command Command
+	// 原始连接的源地址和目标地址,地址未知时为nil
+	SourceAddr      net.Addr
+	DestinationAddr net.Addr
+	// v2头部中地址之后的TLV数据,不做解析
+	TLVs []byte
+}
+
+// Read 从r读取PROXY协议头部,不是以头部开始时返回ErrNoHeader且不消耗数据
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		prefix, err := r.Peek(len(v1Prefix))
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if !bytes.Equal(prefix, v1Prefix) {
+			return nil, ErrNoHeader
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		signature, err := r.Peek(len(v2Signature))
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if !bytes.Equal(signature, v2Signature) {
+			return nil, ErrNoHeader
+		}
+		return readV2(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+// readV1 解析文本头部: PROXY TCP4 源地址 目标地址 源端口 目标端口\r\n
+func readV1(r *bufio.Reader) (*Header, error) {
+	line := make([]byte, 0, v1MaxLength)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= v1MaxLength {
+			return nil, ErrInvalidHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: 1, Command: CommandProxy}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 {
+		return nil, ErrInvalidHeader
+	}
+
+	var want int
+	switch fields[1] {
+	case "TCP4":
+		want = net.IPv4len
+	case "TCP6":
+		want = net.IPv6len
+	default:
+		return nil, ErrInvalidHeader
+	}
+	src, err := parseV1Addr(fields[2], fields[4], want)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], want)
+	if err != nil {
+		return nil, err
+	}
+	h.SourceAddr, h.DestinationAddr = src, dst
+	return h, nil
+}
+
+func parseV1Addr(ip, port string, ipLen int) (*net.TCPAddr, error) {
+	// 地址的写法必须与协议族一致,TCP4不接受IPv6地址,TCP6不接受IPv4地址
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil || strings.Contains(ip, ":") != (ipLen == net.IPv6len) {
+		return nil, ErrInvalidHeader
+	}
+	if ipLen == net.IPv4len {
+		addr.IP = addr.IP.To4()
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrInvalidHeader
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+// readV2 解析二进制头部
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, noEOF(err)
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+	h := &Header{Version: 2, Command: Command(fixed[12] & 0x0f)}
+	if h.Command != CommandLocal && h.Command != CommandProxy {
+		return nil, ErrInvalidHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, noEOF(err)
+	}
+
+	var n int
+	switch family := fixed[13]; family {
+	case familyTCP4, familyUDP4:
+		if n = 2*net.IPv4len + 4; len(body) < n {
+			return nil, ErrInvalidHeader
+		}
+		h.SourceAddr, h.DestinationAddr = ipAddrs(family, body, net.IPv4len)
+	case familyTCP6, familyUDP6:
+		if n = 2*net.IPv6len + 4; len(body) < n {
+			return nil, ErrInvalidHeader
+		}
+		h.SourceAddr, h.DestinationAddr = ipAddrs(family, body, net.IPv6len)
+	case familyUnixStream, familyUnixDgram:
+		if n = 2 * v2UnixAddrLength; len(body) < n {
+			return nil, ErrInvalidHeader
+		}
+		network := "unix"
+		if family == familyUnixDgram {
+			network = "unixgram"
+		}
+		h.SourceAddr = &net.UnixAddr{Name: cString(body[:v2UnixAddrLength]), Net: network}
+		h.DestinationAddr = &net.UnixAddr{Name: cString(body[v2UnixAddrLength:n]), Net: network}
+	case familyUnspec:
+	default:
+		return nil, ErrInvalidHeader
+	}
+	if n < len(body) {
+		h.TLVs = body[n:]
+	}
+	return h, nil
+}
+
+func ipAddrs(family byte, body []byte, ipLen int) (src, dst net.Addr) {
+	srcIP := net.IP(append([]byte(nil), body[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), body[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	if family&0x0f == 0x2 {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Format 编码头部,地址为nil时v1编码为UNKNOWN,v2编码为未指定地址族
+func (h *Header) Format() ([]byte, error) {
+	switch h.Version {
+	case 1:
+		return h.formatV1()
+	case 2:
+		return h.formatV2()
+	default:
+		return nil, errors.Errorf("不支持的PROXY协议版本 [version=%v]", h.Version)
+	}
+}
+
+// WriteTo 编码头部并写入w
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	b, err := h.Format()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (h *Header) formatV1() ([]byte, error) {
+	if h.SourceAddr == nil || h.DestinationAddr == nil {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+	src, ok1 := h.SourceAddr.(*net.TCPAddr)
+	dst, ok2 := h.DestinationAddr.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return nil, errors.Errorf("v1只支持tcp地址 [source=%v, destination=%v]", h.SourceAddr, h.DestinationAddr)
+	}
+	proto := "TCP4"
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP == nil || dstIP == nil {
+		proto, srcIP, dstIP = "TCP6", src.IP.To16(), dst.IP.To16()
+	}
+	return []byte("PROXY " + proto + " " + formatV1IP(srcIP, proto) + " " + formatV1IP(dstIP, proto) + " " +
+		strconv.Itoa(src.Port) + " " + strconv.Itoa(dst.Port) + "\r\n"), nil
+}
+
+// formatV1IP TCP6中的IPv4地址使用IPv4映射的IPv6写法
+func formatV1IP(ip net.IP, proto string) string {
+	if proto == "TCP6" && ip.To4() != nil {
+		return "::ffff:" + ip.To4().String()
+	}
+	return ip.String()
+}
+
+func (h *Header) formatV2() ([]byte, error) {
+	if h.Command != CommandLocal && h.Command != CommandProxy {
+		return nil, errors.Errorf("无效的PROXY协议命令 [command=%v]", h.Command)
+	}
+
+	family := familyUnspec
+	var addrs []byte
+	switch src := h.SourceAddr.(type) {
+	case nil:
+	case *net.TCPAddr:
+		dst, ok := h.DestinationAddr.(*net.TCPAddr)
+		if !ok {
+			return nil, errors.Errorf("源地址和目标地址类型不一致")
+		}
+		family, addrs = ipFamily(familyTCP4, src.IP, dst.IP, src.Port, dst.Port)
+	case *net.UDPAddr:
+		dst, ok := h.DestinationAddr.(*net.UDPAddr)
+		if !ok {
+			return nil, errors.Errorf("源地址和目标地址类型不一致")
+		}
+		family, addrs = ipFamily(familyUDP4, src.IP, dst.IP, src.Port, dst.Port)
+	case *net.UnixAddr:
+		dst, ok := h.DestinationAddr.(*net.UnixAddr)
+		if !ok {
+			return nil, errors.Errorf("源地址和目标地址类型不一致")
+		}
+		if len(src.Name) > v2UnixAddrLength || len(dst.Name) > v2UnixAddrLength {
+			return nil, errors.Errorf("unix地址过长")
+		}
+		family = familyUnixStream
+		if src.Net == "unixgram" {
+			family = familyUnixDgram
+		}
+		addrs = make([]byte, 2*v2UnixAddrLength)
+		copy(addrs, src.Name)
+		copy(addrs[v2UnixAddrLength:], dst.Name)
+	default:
+		return nil, errors.Errorf("不支持的地址类型 [type=%T]", h.SourceAddr)
+	}
+
+	length := len(addrs) + len(h.TLVs)
+	if length > 0xffff {
+		return nil, errors.Errorf("头部过长 [length=%v]", length)
+	}
+	b := make([]byte, v2HeaderLength, v2HeaderLength+length)
+	copy(b, v2Signature)
+	b[12] = 0x20 | byte(h.Command)
+	b[13] = family
+	binary.BigEndian.PutUint16(b[14:], uint16(length))
+	b = append(b, addrs...)
+	return append(b, h.TLVs...), nil
+}
+
+// ipFamily 编码ip地址,base为对应的ipv4地址族
+func ipFamily(base byte, srcIP, dstIP net.IP, srcPort, dstPort int) (byte, []byte) {
+	family := base
+	src, dst := srcIP.To4(), dstIP.To4()
+	if src == nil || dst == nil {
+		family = base + 0x10
+		src, dst = srcIP.To16(), dstIP.To16()
+	}
+	b := make([]byte, 0, 2*len(src)+4)
+	b = append(b, src...)
+	b = append(b, dst...)
+	b = binary.BigEndian.AppendUint16(b, uint16(srcPort))
+	return family, binary.BigEndian.AppendUint16(b, uint16(dstPort))
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
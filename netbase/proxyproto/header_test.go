@@ -0,0 +1,84 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	headers := []*Header{
+		{Version: 1, Command: CommandProxy,
+			SourceAddr:      &net.TCPAddr{IP: net.ParseIP("192.168.1.10").To4(), Port: 5000},
+			DestinationAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 443}},
+		{Version: 1, Command: CommandProxy,
+			SourceAddr:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000},
+			DestinationAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}},
+		{Version: 1, Command: CommandProxy},
+		{Version: 2, Command: CommandProxy,
+			SourceAddr:      &net.TCPAddr{IP: net.ParseIP("192.168.1.10").To4(), Port: 5000},
+			DestinationAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 443},
+			TLVs:            []byte{0x04, 0x00, 0x01, 0xff}},
+		{Version: 2, Command: CommandProxy,
+			SourceAddr:      &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53},
+			DestinationAddr: &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53}},
+		{Version: 2, Command: CommandProxy,
+			SourceAddr:      &net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"},
+			DestinationAddr: &net.UnixAddr{Name: "/tmp/b.sock", Net: "unix"}},
+		{Version: 2, Command: CommandLocal},
+	}
+	for _, h := range headers {
+		b, err := h.Format()
+		require.NoError(t, err)
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(b), strings.NewReader("payload")))
+		got, err := Read(r)
+		require.NoError(t, err)
+		require.Equal(t, h, got)
+		rest, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "payload", string(rest))
+	}
+
+	// 地址族不同时IPv4地址使用映射写法
+	h := &Header{Version: 1, Command: CommandProxy,
+		SourceAddr:      &net.TCPAddr{IP: net.ParseIP("192.168.1.10").To4(), Port: 5000},
+		DestinationAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}}
+	b, err := h.Format()
+	require.NoError(t, err)
+	require.Equal(t, "PROXY TCP6 ::ffff:192.168.1.10 2001:db8::2 5000 443\r\n", string(b))
+	got, err := Read(bufio.NewReader(bytes.NewReader(b)))
+	require.NoError(t, err)
+	require.True(t, got.SourceAddr.(*net.TCPAddr).IP.Equal(net.ParseIP("192.168.1.10")))
+}
+
+func TestReadInvalid(t *testing.T) {
+	// 不是头部时不消耗数据
+	r := bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n"))
+	_, err := Read(r)
+	require.ErrorIs(t, err, ErrNoHeader)
+	rest, _ := io.ReadAll(r)
+	require.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+
+	for _, s := range []string{
+		"PROXY TCP4 1.2.3.4 5.6.7.8 80\r\n",
+		"PROXY TCP4 ::1 ::1 80 80\r\n",
+		"PROXY TCP4 ::ffff:1.2.3.4 5.6.7.8 80 80\r\n",
+		"PROXY TCP6 1.2.3.4 5.6.7.8 80 80\r\n",
+		"PROXY TCP6 ::1 5.6.7.8 80 80\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 80 99999\r\n",
+		"PROXY UDP4 1.2.3.4 5.6.7.8 80 80\r\n",
+		"PROXY " + strings.Repeat("x", 200) + "\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x31\x11\x00\x00",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x02\x00\x00",
+	} {
+		_, err = Read(bufio.NewReader(strings.NewReader(s)))
+		require.ErrorIs(t, err, ErrInvalidHeader, s)
+	}
+	_, err = Read(bufio.NewReader(strings.NewReader("PROXY TCP4 1.2.3.4")))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,198 @@
+package netbase
+
+import (
+	"errors"
+	"github.com/lngwu11/toolgo/utils"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 转发缓存大小
+	relayBufferSize = 32 * 1024
+)
+
+// ErrRelayIdleTimeout 两个方向都超过空闲时间没有数据
+var ErrRelayIdleTimeout = errors.New("relay idle timeout")
+
+var relayBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, relayBufferSize)
+		return &b
+	},
+}
+
+type RelayOption func(opts *RelayOptions)
+
+type RelayOptions struct {
+	// 空闲超时时间
+	idleTimeout time.Duration
+	// 实时字节计数
+	aToB *atomic.Int64
+	bToA *atomic.Int64
+}
+
+// WithRelayIdleTimeout 设置空闲超时时间,两个方向都超过该时间没有数据时关闭连接,默认不超时
+func WithRelayIdleTimeout(idleTimeout time.Duration) RelayOption {
+	return func(opts *RelayOptions) {
+		opts.idleTimeout = idleTimeout
+	}
+}
+
+// WithRelayCounters 设置实时字节计数,转发过程中累加a到b和b到a的字节数,可以为nil
+func WithRelayCounters(aToB, bToA *atomic.Int64) RelayOption {
+	return func(opts *RelayOptions) {
+		opts.aToB = aToB
+		opts.bToA = bToA
+	}
+}
+
+func loadRelayOptions(options ...RelayOption) *RelayOptions {
+	opts := new(RelayOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// RelayStats 转发统计
+type RelayStats struct {
+	// a到b的字节数
+	AToB int64
+	// b到a的字节数
+	BToA int64
+}
+
+// Relay 在a和b之间双向转发数据,直到两个方向都结束,返回时a和b都已关闭
+// 一个方向读到EOF时关闭目标的写方向和来源的读方向,另一个方向继续转发;
+// 目标不支持半关闭或任一方向出错时关闭两个连接。
+// 返回第一个非EOF错误,空闲超时返回ErrRelayIdleTimeout
+func Relay(a, b utils.ReadWriteCloser, options ...RelayOption) (RelayStats, error) {
+	opts := loadRelayOptions(options...)
+	r := &relay{a: a, b: b}
+	r.touch()
+
+	if opts.idleTimeout > 0 {
+		r.mu.Lock()
+		r.watchdog = time.AfterFunc(opts.idleTimeout, func() {
+			r.checkIdle(opts.idleTimeout)
+		})
+		r.mu.Unlock()
+	}
+
+	var stats RelayStats
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		stats.AToB = r.copy(b, a, opts.aToB)
+	}()
+	go func() {
+		defer wg.Done()
+		stats.BToA = r.copy(a, b, opts.bToA)
+	}()
+	wg.Wait()
+
+	r.mu.Lock()
+	if r.watchdog != nil {
+		r.watchdog.Stop()
+	}
+	err := r.err
+	r.mu.Unlock()
+	r.closeBoth()
+	return stats, err
+}
+
+type relay struct {
+	a, b utils.ReadWriteCloser
+	// 最后一次有数据的unix纳秒时间
+	last atomic.Int64
+
+	mu       sync.Mutex
+	watchdog *time.Timer
+	err      error
+	closed   bool
+}
+
+func (r *relay) touch() {
+	r.last.Store(time.Now().UnixNano())
+}
+
+// checkIdle 空闲超时则关闭连接,否则在剩余时间后再次检查
+func (r *relay) checkIdle(idleTimeout time.Duration) {
+	idle := time.Since(time.Unix(0, r.last.Load()))
+	if idle >= idleTimeout {
+		r.fail(ErrRelayIdleTimeout)
+		return
+	}
+	r.mu.Lock()
+	if !r.closed {
+		r.watchdog.Reset(idleTimeout - idle)
+	}
+	r.mu.Unlock()
+}
+
+// fail 记录第一个错误并关闭两个连接,唤醒另一个方向
+// 连接关闭后另一个方向产生的错误不记录
+func (r *relay) fail(err error) {
+	r.mu.Lock()
+	if r.err == nil && !r.closed {
+		r.err = err
+	}
+	r.mu.Unlock()
+	r.closeBoth()
+}
+
+func (r *relay) closeBoth() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	r.mu.Unlock()
+	_ = r.a.Close()
+	_ = r.b.Close()
+}
+
+// copy 从src复制到dst,返回复制的字节数
+func (r *relay) copy(dst, src utils.ReadWriteCloser, counter *atomic.Int64) int64 {
+	bufp := relayBufferPool.Get().(*[]byte)
+	defer relayBufferPool.Put(bufp)
+	buf := *bufp
+
+	var written int64
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			r.touch()
+			nw, werr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if counter != nil {
+				counter.Add(int64(nw))
+			}
+			if werr == nil && nw != nr {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				r.fail(werr)
+				return written
+			}
+		}
+		if rerr == io.EOF {
+			// 对端不再发送数据,向目标传递EOF
+			if err := dst.CloseWrite(); err != nil {
+				r.closeBoth()
+				return written
+			}
+			_ = src.CloseRead()
+			return written
+		}
+		if rerr != nil {
+			r.fail(rerr)
+			return written
+		}
+	}
+}
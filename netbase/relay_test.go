@@ -0,0 +1,107 @@
+package netbase
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase/internal/nettest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayHalfClose(t *testing.T) {
+	client, relayA := nettest.TCPPair(t)
+	relayB, upstream := nettest.TCPPair(t)
+
+	type result struct {
+		stats RelayStats
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stats, err := Relay(relayA, relayB)
+		done <- result{stats, err}
+	}()
+
+	// 客户端发送完请求后关闭写方向,上游读到EOF后仍可以回复
+	_, err := client.Write([]byte("request"))
+	require.NoError(t, err)
+	require.NoError(t, client.CloseWrite())
+
+	got, err := io.ReadAll(upstream)
+	require.NoError(t, err)
+	require.Equal(t, "request", string(got))
+	_, err = upstream.Write([]byte("response!"))
+	require.NoError(t, err)
+	require.NoError(t, upstream.Close())
+
+	got, err = io.ReadAll(client)
+	require.NoError(t, err)
+	require.Equal(t, "response!", string(got))
+
+	r := <-done
+	require.NoError(t, r.err)
+	require.Equal(t, RelayStats{AToB: 7, BToA: 9}, r.stats)
+	_ = client.Close()
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	client, relayA := nettest.TCPPair(t)
+	relayB, upstream := nettest.TCPPair(t)
+	defer client.Close()
+	defer upstream.Close()
+
+	start := time.Now()
+	_, err := Relay(relayA, relayB, WithRelayIdleTimeout(50*time.Millisecond))
+	require.ErrorIs(t, err, ErrRelayIdleTimeout)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestForwarder(t *testing.T) {
+	// 两个上游分别回复自己的名字
+	var upstreams []string
+	for _, name := range []string{"a", "b"} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		go func(ln net.Listener, name string) {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				_, _ = c.Write([]byte(name))
+				_ = c.Close()
+			}
+		}(ln, name)
+		upstreams = append(upstreams, ln.Addr().String())
+	}
+	// 不可用的上游被跳过
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, dead.Close())
+	upstreams = append(upstreams, dead.Addr().String())
+
+	f, err := NewForwarder("127.0.0.1:0", upstreams)
+	require.NoError(t, err)
+	require.NoError(t, f.Start())
+	defer f.Close()
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", f.Addr().String())
+		require.NoError(t, err)
+		got, err := io.ReadAll(c)
+		require.NoError(t, err)
+		_ = c.Close()
+		names = append(names, string(got))
+	}
+	require.Equal(t, []string{"a", "b", "a"}, names)
+
+	require.NoError(t, f.Shutdown(context.Background()))
+	stats := f.Stats()
+	require.EqualValues(t, 3, stats.BytesDown)
+	require.EqualValues(t, 1, stats.DialFailures)
+}
@@ -0,0 +1,193 @@
+package rpc
+
+import (
+	"context"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// ErrClientClosed 客户端已关闭
+var ErrClientClosed = errors.New("rpc client closed")
+
+// ServerError 服务端返回的错误
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+type call struct {
+	done  chan struct{}
+	reply *message
+}
+
+// Client rpc客户端,多个调用可以在同一连接上并发进行
+type Client struct {
+	conn   utils.ConnReadWriteCloser
+	opts   *Options
+	sender *sender
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]*call
+	closed  bool
+	err     error
+
+	done chan struct{}
+}
+
+// NewClient 在连接上创建客户端,连接由客户端负责关闭
+func NewClient(conn utils.ConnReadWriteCloser, options ...Option) *Client {
+	opts := loadOptions(options...)
+	c := &Client{
+		conn:    conn,
+		opts:    opts,
+		sender:  newSender(conn, opts),
+		pending: make(map[uint64]*call),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Call 调用服务端方法,args编码后发送,结果解码到reply,reply为nil时忽略结果
+// ctx的截止时间以剩余时间传递给服务端;ctx取消时通知服务端取消并返回ctx的错误,
+// 已经开始写入的请求仍会完整写入,不影响连接上的其他调用
+func (c *Client) Call(ctx context.Context, method string, args, reply interface{}) error {
+	payload, err := c.opts.encoding.Marshal(args)
+	if err != nil {
+		return errors.Wrapf(err, "无法编码请求参数 [method=%v]", method)
+	}
+
+	req := &message{typ: typeRequest, method: method, payload: payload}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+		req.timeout = int64(timeout)
+	}
+
+	cl := &call{done: make(chan struct{})}
+	c.mu.Lock()
+	if c.closed {
+		err = c.err
+		c.mu.Unlock()
+		return err
+	}
+	c.nextID++
+	req.id = c.nextID
+	c.pending[req.id] = cl
+	c.mu.Unlock()
+
+	if err = c.sender.send(ctx, req); err != nil {
+		c.removeCall(req.id)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// 请求可能已经开始写入,通知服务端取消
+			c.sender.post(&message{typ: typeCancel, id: req.id})
+			return ctxErr
+		}
+		return errors.Wrapf(err, "无法发送请求 [method=%v]", method)
+	}
+
+	select {
+	case <-cl.done:
+	case <-ctx.Done():
+		if c.removeCall(req.id) {
+			c.sender.post(&message{typ: typeCancel, id: req.id})
+			return ctx.Err()
+		}
+		// 响应和取消同时到达时以响应为准
+		<-cl.done
+	}
+
+	if cl.reply == nil {
+		return c.Err()
+	}
+	switch cl.reply.typ {
+	case typeError:
+		return ServerError(cl.reply.payload)
+	case typeResponse:
+		if reply == nil {
+			return nil
+		}
+		if err = c.opts.encoding.Unmarshal(cl.reply.payload, reply); err != nil {
+			return errors.Wrapf(err, "无法解码响应 [method=%v]", method)
+		}
+		return nil
+	default:
+		return errMalformedMessage
+	}
+}
+
+// removeCall 移除等待中的调用,调用仍在等待时返回true
+func (c *Client) removeCall(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	return ok
+}
+
+// Close 关闭客户端和连接,等待中的调用返回ErrClientClosed
+func (c *Client) Close() error {
+	c.shutdown(ErrClientClosed)
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Done 客户端关闭或连接断开后关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 返回客户端停止的原因
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.err = err
+	c.sender.close(err)
+	for id, cl := range c.pending {
+		delete(c.pending, id)
+		close(cl.done)
+	}
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	for {
+		frame, err := c.sender.codec.ReadFrame()
+		if err != nil {
+			c.shutdown(errors.Wrapf(err, "rpc连接已断开"))
+			return
+		}
+		m, err := decodeMessage(frame)
+		if err != nil {
+			c.shutdown(err)
+			_ = c.conn.Close()
+			return
+		}
+
+		c.mu.Lock()
+		cl, ok := c.pending[m.id]
+		delete(c.pending, m.id)
+		c.mu.Unlock()
+		if ok {
+			cl.reply = m
+			close(cl.done)
+		}
+	}
+}
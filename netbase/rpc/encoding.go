@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/pkg/errors"
+)
+
+// Encoding 请求参数和响应结果的编码方式,客户端和服务端必须一致
+type Encoding interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSON 使用encoding/json编码
+	JSON Encoding = jsonEncoding{}
+	// Gob 使用encoding/gob编码,每个值独立编码
+	Gob Encoding = gobEncoding{}
+	// Raw 不编码,值必须为[]byte,解码目标必须为*[]byte
+	Raw Encoding = rawEncoding{}
+)
+
+type jsonEncoding struct{}
+
+func (jsonEncoding) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonEncoding) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobEncoding struct{}
+
+func (gobEncoding) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobEncoding) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type rawEncoding struct{}
+
+func (rawEncoding) Marshal(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return b, nil
+	case *[]byte:
+		return *b, nil
+	default:
+		return nil, errors.Errorf("raw编码只支持[]byte [type=%T]", v)
+	}
+}
+
+func (rawEncoding) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return errors.Errorf("raw解码只支持*[]byte [type=%T]", v)
+	}
+	*b = data
+	return nil
+}
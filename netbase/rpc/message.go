@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/lngwu11/toolgo/netbase"
+	"github.com/lngwu11/toolgo/netbase/codec"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认最大消息长度
+	defaultMaxMessageSize = 16 * 1024 * 1024
+	// 消息头长度: type(1) + id(8) + timeout(8) + method长度(2)
+	headerSize = 19
+	// 等待写入的取消通知上限
+	maxPostedMessages = 64
+)
+
+// 消息类型
+const (
+	typeRequest byte = iota + 1
+	typeResponse
+	typeError
+	typeCancel
+)
+
+var errMalformedMessage = errors.New("malformed rpc message")
+
+// message 一个帧中的rpc消息:
+// | type 1 | id 8 | timeout 8 | method长度 2 | method | payload |
+// timeout为发送时距离请求截止时间的纳秒数,0表示没有截止时间,
+// 使用相对时间避免两端时钟不一致;错误响应的payload为错误信息
+type message struct {
+	typ     byte
+	id      uint64
+	timeout int64
+	method  string
+	payload []byte
+}
+
+func (m *message) encode() ([]byte, error) {
+	if len(m.method) > math.MaxUint16 {
+		return nil, errors.Errorf("方法名过长 [method=%v]", m.method)
+	}
+	frame := make([]byte, headerSize+len(m.method)+len(m.payload))
+	frame[0] = m.typ
+	binary.BigEndian.PutUint64(frame[1:], m.id)
+	binary.BigEndian.PutUint64(frame[9:], uint64(m.timeout))
+	binary.BigEndian.PutUint16(frame[17:], uint16(len(m.method)))
+	copy(frame[headerSize:], m.method)
+	copy(frame[headerSize+len(m.method):], m.payload)
+	return frame, nil
+}
+
+func decodeMessage(frame []byte) (*message, error) {
+	if len(frame) < headerSize {
+		return nil, errMalformedMessage
+	}
+	m := &message{
+		typ:     frame[0],
+		id:      binary.BigEndian.Uint64(frame[1:]),
+		timeout: int64(binary.BigEndian.Uint64(frame[9:])),
+	}
+	methodLen := int(binary.BigEndian.Uint16(frame[17:]))
+	if len(frame) < headerSize+methodLen {
+		return nil, errMalformedMessage
+	}
+	m.method = string(frame[headerSize : headerSize+methodLen])
+	m.payload = frame[headerSize+methodLen:]
+	return m, nil
+}
+
+// deadlineTime 按收到消息的时间计算截止时间
+func (m *message) deadlineTime() (time.Time, bool) {
+	if m.timeout <= 0 {
+		return time.Time{}, false
+	}
+	return time.Now().Add(time.Duration(m.timeout)), true
+}
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 参数和结果的编码方式
+	encoding Encoding
+	// 最大消息长度
+	maxMessageSize int
+	// 服务端每个连接同时处理的请求数
+	maxConcurrentCalls int
+}
+
+// WithEncoding 设置编码方式,默认JSON
+func WithEncoding(encoding Encoding) Option {
+	return func(opts *Options) {
+		opts.encoding = encoding
+	}
+}
+
+// WithMaxMessageSize 设置最大消息长度,默认16MB
+func WithMaxMessageSize(size int) Option {
+	return func(opts *Options) {
+		opts.maxMessageSize = size
+	}
+}
+
+// WithMaxConcurrentCalls 设置服务端每个连接同时处理的请求数,超过时请求直接返回错误,默认不限制
+func WithMaxConcurrentCalls(n int) Option {
+	return func(opts *Options) {
+		opts.maxConcurrentCalls = n
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.encoding == nil {
+		opts.encoding = JSON
+	}
+	if opts.maxMessageSize <= 0 {
+		opts.maxMessageSize = defaultMaxMessageSize
+	}
+	return opts
+}
+
+// sender 在单独的goroutine中串行写入消息
+// 调用方等待时可以被ctx中断,已经开始写入的消息总是完整写完,一个调用的ctx不会关闭连接
+type sender struct {
+	conn  utils.ConnReadWriteCloser
+	codec codec.Codec
+	// 等待写入的消息,写入goroutine空闲时才能放入
+	queue chan *outgoing
+	// 不需要等待结果的消息(如取消通知),缓存满时丢弃
+	posted chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	// closed关闭前设置
+	err error
+}
+
+type outgoing struct {
+	frame []byte
+	done  chan error
+}
+
+func newSender(conn utils.ConnReadWriteCloser, opts *Options) *sender {
+	// 4字节长度字段总是合法
+	c, _ := codec.NewLengthFieldCodec(
+		netbase.NewBufferConnection(conn, 0),
+		codec.WithMaxFrameSize(opts.maxMessageSize),
+	)
+	s := &sender{
+		conn:   conn,
+		codec:  c,
+		queue:  make(chan *outgoing),
+		posted: make(chan []byte, maxPostedMessages),
+		closed: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// send 写入消息并等待结果,ctx取消时返回ctx的错误;
+// 消息已经交给写入goroutine时仍会完整写入
+func (s *sender) send(ctx context.Context, m *message) error {
+	frame, err := m.encode()
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	out := &outgoing{frame: frame, done: make(chan error, 1)}
+	select {
+	case s.queue <- out:
+	case <-s.closed:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err = <-out.done:
+		return err
+	case <-s.closed:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// post 把消息放入发送缓存后立即返回,缓存已满或sender已关闭时丢弃
+func (s *sender) post(m *message) {
+	frame, err := m.encode()
+	if err != nil {
+		return
+	}
+	select {
+	case s.posted <- frame:
+	default:
+	}
+}
+
+// close 停止写入goroutine,之后的send返回err
+func (s *sender) close(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.closed)
+	})
+}
+
+func (s *sender) run() {
+	for {
+		var err error
+		select {
+		case frame := <-s.posted:
+			err = s.write(frame)
+		case out := <-s.queue:
+			err = s.write(out.frame)
+			out.done <- err
+		case <-s.closed:
+			return
+		}
+		// 写入失败后连接可能只写了部分消息,关闭连接
+		if err != nil {
+			s.close(err)
+			_ = s.conn.Close()
+			return
+		}
+	}
+}
+
+func (s *sender) write(frame []byte) error {
+	if err := s.codec.WriteFrame(frame); err != nil {
+		return err
+	}
+	return s.codec.Flush()
+}
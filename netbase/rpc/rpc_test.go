@@ -0,0 +1,262 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase/server"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+type pair struct {
+	A, B int
+}
+
+func startServer(t *testing.T, rs *Server) string {
+	srv := server.NewServer("127.0.0.1:0")
+	require.NoError(t, srv.Serve(rs.ServeConn))
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv.Addr().String()
+}
+
+func dial(t *testing.T, addr string, options ...Option) *Client {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	c := NewClient(utils.ToConnReadWriteCloser(conn), options...)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	for _, enc := range []Encoding{JSON, Gob} {
+		rs := NewServer(WithEncoding(enc))
+		require.NoError(t, rs.Register("add", func(ctx context.Context, args Args) (interface{}, error) {
+			var p pair
+			if err := args.Decode(&p); err != nil {
+				return nil, err
+			}
+			// 让后发的请求先返回
+			time.Sleep(time.Duration(10-p.A%10) * time.Millisecond)
+			return p.A + p.B, nil
+		}))
+		require.Error(t, rs.Register("add", func(context.Context, Args) (interface{}, error) { return nil, nil }))
+		c := dial(t, startServer(t, rs), WithEncoding(enc))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var sum int
+				require.NoError(t, c.Call(context.Background(), "add", pair{A: i, B: 1}, &sum))
+				require.Equal(t, i+1, sum)
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+func TestRawEncodingAndErrors(t *testing.T) {
+	rs := NewServer(WithEncoding(Raw))
+	require.NoError(t, rs.Register("echo", func(ctx context.Context, args Args) (interface{}, error) {
+		var b []byte
+		err := args.Decode(&b)
+		return b, err
+	}))
+	require.NoError(t, rs.Register("fail", func(context.Context, Args) (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	}))
+	require.NoError(t, rs.Register("panic", func(context.Context, Args) (interface{}, error) {
+		panic("oops")
+	}))
+	c := dial(t, startServer(t, rs), WithEncoding(Raw))
+
+	var reply []byte
+	require.NoError(t, c.Call(context.Background(), "echo", []byte("hello"), &reply))
+	require.Equal(t, "hello", string(reply))
+
+	err := c.Call(context.Background(), "fail", nil, nil)
+	require.Equal(t, ServerError("boom"), err)
+	err = c.Call(context.Background(), "panic", nil, nil)
+	require.IsType(t, ServerError(""), err)
+	err = c.Call(context.Background(), "missing", nil, nil)
+	require.IsType(t, ServerError(""), err)
+}
+
+func TestDeadlineAndCancel(t *testing.T) {
+	rs := NewServer()
+	canceled := make(chan error, 2)
+	deadlines := make(chan bool, 2)
+	require.NoError(t, rs.Register("wait", func(ctx context.Context, args Args) (interface{}, error) {
+		_, ok := ctx.Deadline()
+		deadlines <- ok
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	}))
+	c := dial(t, startServer(t, rs))
+
+	// 截止时间传递到服务端
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.Call(ctx, "wait", nil, nil), context.DeadlineExceeded)
+	require.True(t, <-deadlines)
+	select {
+	case err := <-canceled:
+		require.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server handler not canceled by deadline")
+	}
+
+	// 客户端取消通知服务端
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	require.ErrorIs(t, c.Call(ctx, "wait", nil, nil), context.Canceled)
+	require.False(t, <-deadlines)
+	select {
+	case err := <-canceled:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("server handler not canceled by client")
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	rs := NewServer()
+	require.NoError(t, rs.Register("block", func(ctx context.Context, args Args) (interface{}, error) {
+		<-ctx.Done()
+		return nil, nil
+	}))
+	c := dial(t, startServer(t, rs))
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.Call(context.Background(), "block", nil, nil) }()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, c.Close())
+	require.ErrorIs(t, <-errc, ErrClientClosed)
+	require.ErrorIs(t, c.Call(context.Background(), "block", nil, nil), ErrClientClosed)
+	<-c.Done()
+}
+
+func TestDisconnectCancelsHandlers(t *testing.T) {
+	rs := NewServer()
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	require.NoError(t, rs.Register("block", func(ctx context.Context, args Args) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}))
+	c := dial(t, startServer(t, rs))
+
+	go func() { _ = c.Call(context.Background(), "block", nil, nil) }()
+	<-started
+	// 客户端断开后服务端仍在运行,处理函数的ctx也要被取消
+	require.NoError(t, c.Close())
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("handler not canceled after client disconnected")
+	}
+}
+
+func TestSendHonorsContext(t *testing.T) {
+	// 对端暂不读取,写入一直阻塞
+	a, b := net.Pipe()
+	defer b.Close()
+	c := NewClient(utils.ToConnReadWriteCloser(a))
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	require.ErrorIs(t, c.Call(ctx, "echo", "first", nil), context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	// 超时的调用不关闭连接,对端开始读取后其他调用正常完成
+	select {
+	case <-c.Done():
+		t.Fatal("connection closed by a caller's context")
+	default:
+	}
+	rs := NewServer()
+	require.NoError(t, rs.Register("echo", func(ctx context.Context, args Args) (interface{}, error) {
+		var s string
+		err := args.Decode(&s)
+		return s, err
+	}))
+	go rs.ServeConn(context.Background(), utils.ToConnReadWriteCloser(b))
+	var reply string
+	require.NoError(t, c.Call(context.Background(), "echo", "second", &reply))
+	require.Equal(t, "second", reply)
+}
+
+func TestCancelDoesNotBlock(t *testing.T) {
+	// 对端读取请求后不再读取,取消通知无法写入
+	a, b := net.Pipe()
+	defer b.Close()
+	c := NewClient(utils.ToConnReadWriteCloser(a))
+	defer c.Close()
+	received := make(chan struct{})
+	go func() {
+		_, _ = b.Read(make([]byte, 1024))
+		close(received)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-received
+		cancel()
+	}()
+	start := time.Now()
+	require.ErrorIs(t, c.Call(ctx, "block", nil, nil), context.Canceled)
+	require.Less(t, time.Since(start), time.Second)
+
+	// 其他调用等待写入时仍然受自己的ctx控制
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.Call(ctx, "block", nil, nil), context.DeadlineExceeded)
+}
+
+func TestRelativeTimeout(t *testing.T) {
+	m := &message{typ: typeRequest, id: 1, timeout: int64(time.Minute)}
+	frame, err := m.encode()
+	require.NoError(t, err)
+	got, err := decodeMessage(frame)
+	require.NoError(t, err)
+	deadline, ok := got.deadlineTime()
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	got.timeout = 0
+	_, ok = got.deadlineTime()
+	require.False(t, ok)
+}
+
+func TestMaxConcurrentCalls(t *testing.T) {
+	rs := NewServer(WithMaxConcurrentCalls(1))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	require.NoError(t, rs.Register("block", func(ctx context.Context, args Args) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}))
+	c := dial(t, startServer(t, rs))
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.Call(context.Background(), "block", nil, nil) }()
+	<-started
+	require.IsType(t, ServerError(""), c.Call(context.Background(), "block", nil, nil))
+	close(release)
+	require.NoError(t, <-errc)
+}
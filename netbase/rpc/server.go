@@ -0,0 +1,172 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+)
+
+// Args 请求参数,按服务端的编码方式解码
+type Args interface {
+	Decode(v interface{}) error
+}
+
+// Handler 方法处理函数,返回值按服务端的编码方式编码后作为结果
+// ctx在客户端取消调用、超过调用截止时间或连接断开时取消
+type Handler func(ctx context.Context, args Args) (interface{}, error)
+
+type args struct {
+	payload  []byte
+	encoding Encoding
+}
+
+func (a *args) Decode(v interface{}) error {
+	return a.encoding.Unmarshal(a.payload, v)
+}
+
+// Server rpc服务端,保存方法注册表
+type Server struct {
+	opts *Options
+
+	mu      sync.RWMutex
+	methods map[string]Handler
+}
+
+// NewServer 创建服务端
+func NewServer(options ...Option) *Server {
+	return &Server{
+		opts:    loadOptions(options...),
+		methods: make(map[string]Handler),
+	}
+}
+
+// Register 注册方法,方法名已存在时返回错误
+func (s *Server) Register(method string, handler Handler) error {
+	if method == "" {
+		return errors.New("method cannot be empty")
+	}
+	if handler == nil {
+		return errors.New("handler cannot be nil")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.methods[method]; ok {
+		return errors.Errorf("方法已注册 [method=%v]", method)
+	}
+	s.methods[method] = handler
+	return nil
+}
+
+func (s *Server) handler(method string) (Handler, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.methods[method]
+	return h, ok
+}
+
+// ServeConn 处理连接上的请求直到连接断开或ctx取消,每个请求在独立的goroutine中处理,
+// 同时处理的请求数受WithMaxConcurrentCalls限制
+// 签名与server.Handler一致,可以直接传给server.Server.Serve
+func (s *Server) ServeConn(ctx context.Context, conn utils.ConnReadWriteCloser) {
+	ctx, cancel := context.WithCancel(ctx)
+	snd := newSender(conn, s.opts)
+	var wg sync.WaitGroup
+	// 连接断开后先取消正在处理的请求再等待,否则等待ctx的处理函数永远不会返回
+	defer func() {
+		cancel()
+		wg.Wait()
+		snd.close(net.ErrClosed)
+	}()
+	// ctx取消时关闭连接以结束读取
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	var mu sync.Mutex
+	inflight := make(map[uint64]context.CancelFunc)
+
+	for {
+		frame, err := snd.codec.ReadFrame()
+		if err != nil {
+			return
+		}
+		m, err := decodeMessage(frame)
+		if err != nil {
+			return
+		}
+
+		switch m.typ {
+		case typeCancel:
+			mu.Lock()
+			if cancelCall, ok := inflight[m.id]; ok {
+				cancelCall()
+			}
+			mu.Unlock()
+		case typeRequest:
+			mu.Lock()
+			busy := s.opts.maxConcurrentCalls > 0 && len(inflight) >= s.opts.maxConcurrentCalls
+			mu.Unlock()
+			if busy {
+				resp := &message{typ: typeError, id: m.id,
+					payload: []byte(fmt.Sprintf("并发调用过多 [max=%v]", s.opts.maxConcurrentCalls))}
+				if snd.send(ctx, resp) != nil {
+					return
+				}
+				continue
+			}
+
+			callCtx, cancelCall := context.WithCancel(ctx)
+			if deadline, ok := m.deadlineTime(); ok {
+				callCtx, cancelCall = context.WithDeadline(ctx, deadline)
+			}
+			mu.Lock()
+			inflight[m.id] = cancelCall
+			mu.Unlock()
+
+			wg.Add(1)
+			go func(m *message) {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(inflight, m.id)
+					mu.Unlock()
+					cancelCall()
+				}()
+				_ = snd.send(ctx, s.call(callCtx, m))
+			}(m)
+		}
+	}
+}
+
+// call 调用方法并生成响应消息
+func (s *Server) call(ctx context.Context, m *message) (resp *message) {
+	resp = &message{typ: typeResponse, id: m.id}
+	fail := func(err error) *message {
+		resp.typ = typeError
+		resp.payload = []byte(err.Error())
+		return resp
+	}
+
+	h, ok := s.handler(m.method)
+	if !ok {
+		return fail(errors.Errorf("方法不存在 [method=%v]", m.method))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			resp = fail(fmt.Errorf("方法处理异常 [method=%v]: %v", m.method, r))
+		}
+	}()
+
+	result, err := h(ctx, &args{payload: m.payload, encoding: s.opts.encoding})
+	if err != nil {
+		return fail(err)
+	}
+	if resp.payload, err = s.opts.encoding.Marshal(result); err != nil {
+		return fail(errors.Wrapf(err, "无法编码结果 [method=%v]", m.method))
+	}
+	return resp
+}
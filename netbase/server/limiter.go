@@ -0,0 +1,345 @@
+package server
+
+import (
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 默认接收连接临时错误的最小重试间隔
+	defaultAcceptMinBackoff = 5 * time.Millisecond
+	// 默认接收连接临时错误的最大重试间隔
+	defaultAcceptMaxBackoff = time.Second
+)
+
+// Stats 连接统计
+type Stats struct {
+	// 已接收并交付的连接数
+	Accepted uint64
+	// 当前连接数
+	Active int64
+	// 超过最大连接数被拒绝的连接数
+	RejectedMaxConnections uint64
+	// 超过单IP最大连接数被拒绝的连接数
+	RejectedPerIP uint64
+	// 不在允许列表或在禁止列表中被拒绝的连接数
+	RejectedByRule uint64
+	// 接收连接时遇到的临时错误次数
+	AcceptRetries uint64
+}
+
+// StatsListener RunTCPListener返回的listener,提供连接统计
+type StatsListener interface {
+	net.Listener
+	Stats() Stats
+}
+
+// WithMaxConnections 设置最大并发连接数
+// block为true时达到上限后暂停接收连接,否则接收后立即关闭新连接
+func WithMaxConnections(max int, block bool) Option {
+	return func(opts *Options) {
+		opts.maxConnections = max
+		opts.blockOnMaxConnections = block
+	}
+}
+
+// WithMaxConnectionsPerIP 设置单个远端IP的最大并发连接数,超过时关闭新连接
+func WithMaxConnectionsPerIP(max int) Option {
+	return func(opts *Options) {
+		opts.maxConnectionsPerIP = max
+	}
+}
+
+// WithAllowCIDRs 设置允许连接的地址段,如"10.0.0.0/8"或"192.168.1.1"
+// 设置后只接受匹配的远端地址
+func WithAllowCIDRs(cidrs ...string) Option {
+	return func(opts *Options) {
+		opts.allowCIDRs = append(opts.allowCIDRs, cidrs...)
+	}
+}
+
+// WithDenyCIDRs 设置禁止连接的地址段,优先于允许列表
+func WithDenyCIDRs(cidrs ...string) Option {
+	return func(opts *Options) {
+		opts.denyCIDRs = append(opts.denyCIDRs, cidrs...)
+	}
+}
+
+// WithAcceptBackoff 设置接收连接遇到临时错误(如EMFILE)时的重试间隔范围,间隔按指数增长
+func WithAcceptBackoff(min, max time.Duration) Option {
+	return func(opts *Options) {
+		opts.acceptMinBackoff = min
+		opts.acceptMaxBackoff = max
+	}
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Errorf("无效的IP地址 [ip=%v]", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "无效的地址段 [cidr=%v]", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitListener 在Accept中执行连接数限制、地址过滤和临时错误退避
+type limitListener struct {
+	net.Listener
+	opts  *Options
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	// 发送PROXY协议头部的可信来源
+	proxyTrusted []*net.IPNet
+	// unix socket文件,关闭时删除
+	socketPath string
+
+	// 限制最大连接数的信号量,阻塞模式使用
+	sem chan struct{}
+
+	mu    sync.Mutex
+	perIP map[string]int
+
+	accepted               uint64
+	active                 int64
+	rejectedMaxConnections uint64
+	rejectedPerIP          uint64
+	rejectedByRule         uint64
+	acceptRetries          uint64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newLimitListener(listener net.Listener, opts *Options) (*limitListener, error) {
+	allow, err := parseCIDRs(opts.allowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(opts.denyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	proxyTrusted, err := parseCIDRs(opts.proxyTrustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	// 信任所有来源时任何客户端都可以伪造地址,必须显式设置
+	if opts.proxyProtocol && len(proxyTrusted) == 0 && opts.network != "unix" {
+		return nil, errors.Errorf("PROXY协议没有设置可信来源,信任所有来源使用0.0.0.0/0和::/0")
+	}
+	l := &limitListener{
+		Listener:     listener,
+		opts:         opts,
+		allow:        allow,
+		deny:         deny,
+		proxyTrusted: proxyTrusted,
+		perIP:        make(map[string]int),
+		closed:       make(chan struct{}),
+	}
+	if opts.maxConnections > 0 && opts.blockOnMaxConnections {
+		l.sem = make(chan struct{}, opts.maxConnections)
+	}
+	return l, nil
+}
+
+// Stats 返回连接统计
+func (l *limitListener) Stats() Stats {
+	return Stats{
+		Accepted:               atomic.LoadUint64(&l.accepted),
+		Active:                 atomic.LoadInt64(&l.active),
+		RejectedMaxConnections: atomic.LoadUint64(&l.rejectedMaxConnections),
+		RejectedPerIP:          atomic.LoadUint64(&l.rejectedPerIP),
+		RejectedByRule:         atomic.LoadUint64(&l.rejectedByRule),
+		AcceptRetries:          atomic.LoadUint64(&l.acceptRetries),
+	}
+}
+
+// Close 关闭listener,唤醒等待连接数空闲的Accept
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	err := l.Listener.Close()
+	if err == nil && l.socketPath != "" {
+		_ = os.Remove(l.socketPath)
+	}
+	return err
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	backoff := time.Duration(0)
+	for {
+		if l.sem != nil {
+			select {
+			case l.sem <- struct{}{}:
+			case <-l.closed:
+				return nil, net.ErrClosed
+			}
+		}
+
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.releaseSlot()
+			if !isTemporary(err) {
+				return nil, err
+			}
+			atomic.AddUint64(&l.acceptRetries, 1)
+			backoff = l.nextBackoff(backoff)
+			select {
+			case <-time.After(backoff):
+			case <-l.closed:
+				return nil, net.ErrClosed
+			}
+			continue
+		}
+		backoff = 0
+
+		if conn, ok := l.admit(c); ok {
+			atomic.AddUint64(&l.accepted, 1)
+			return conn, nil
+		}
+		l.releaseSlot()
+		_ = c.Close()
+	}
+}
+
+func (l *limitListener) nextBackoff(backoff time.Duration) time.Duration {
+	min, max := l.opts.acceptMinBackoff, l.opts.acceptMaxBackoff
+	if min <= 0 {
+		min = defaultAcceptMinBackoff
+	}
+	if max < min {
+		max = defaultAcceptMaxBackoff
+	}
+	if backoff == 0 {
+		return min
+	}
+	if backoff *= 2; backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+func (l *limitListener) releaseSlot() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// admit 检查连接是否允许接入,允许时返回包装后的连接
+func (l *limitListener) admit(c net.Conn) (net.Conn, bool) {
+	ip := remoteIP(c)
+	if ip != nil && (containsIP(l.deny, ip) || (len(l.allow) > 0 && !containsIP(l.allow, ip))) {
+		atomic.AddUint64(&l.rejectedByRule, 1)
+		return nil, false
+	}
+
+	if l.sem == nil && l.opts.maxConnections > 0 && atomic.LoadInt64(&l.active) >= int64(l.opts.maxConnections) {
+		atomic.AddUint64(&l.rejectedMaxConnections, 1)
+		return nil, false
+	}
+
+	key := ""
+	if ip != nil && l.opts.maxConnectionsPerIP > 0 {
+		key = ip.String()
+		l.mu.Lock()
+		if l.perIP[key] >= l.opts.maxConnectionsPerIP {
+			l.mu.Unlock()
+			atomic.AddUint64(&l.rejectedPerIP, 1)
+			return nil, false
+		}
+		l.perIP[key]++
+		l.mu.Unlock()
+	}
+
+	if tcp, ok := c.(*net.TCPConn); ok {
+		_ = tcp.SetKeepAlive(true)
+		_ = tcp.SetKeepAlivePeriod(l.opts.keepAlivePeriod)
+	}
+
+	conn := utils.ToConnReadWriteCloser(c)
+	if l.opts.proxyProtocol && (l.opts.network == "unix" || (ip != nil && containsIP(l.proxyTrusted, ip))) {
+		conn = newProxyConn(conn, l.opts.proxyHeaderTimeout)
+	}
+
+	atomic.AddInt64(&l.active, 1)
+	return &limitedConn{
+		ConnReadWriteCloser: conn,
+		listener:            l,
+		ipKey:               key,
+	}, true
+}
+
+func (l *limitListener) release(ipKey string) {
+	atomic.AddInt64(&l.active, -1)
+	if ipKey != "" {
+		l.mu.Lock()
+		if l.perIP[ipKey]--; l.perIP[ipKey] <= 0 {
+			delete(l.perIP, ipKey)
+		}
+		l.mu.Unlock()
+	}
+	l.releaseSlot()
+}
+
+func remoteIP(c net.Conn) net.IP {
+	switch addr := c.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+func isTemporary(err error) bool {
+	var ne interface{ Temporary() bool }
+	return errors.As(err, &ne) && ne.Temporary()
+}
+
+// limitedConn 关闭时释放连接数
+type limitedConn struct {
+	utils.ConnReadWriteCloser
+	listener  *limitListener
+	ipKey     string
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.ConnReadWriteCloser.Close()
+	c.closeOnce.Do(func() {
+		c.listener.release(c.ipKey)
+	})
+	return err
+}
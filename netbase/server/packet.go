@@ -0,0 +1,276 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认数据包会话空闲超时时间
+	defaultSessionIdleTimeout = 60 * time.Second
+	// 默认每个会话缓存的数据包数
+	defaultSessionQueueSize = 64
+	// 默认最大数据包长度
+	defaultMaxPacketSize = 65535
+	// 等待Accept的新会话数
+	acceptQueueSize = 128
+)
+
+// ErrSessionClosed 会话已关闭
+var ErrSessionClosed = errors.New("packet session closed")
+
+// WithSessionIdleTimeout 设置数据包会话的空闲超时时间,超时未收发数据的会话被关闭,默认60s
+func WithSessionIdleTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.sessionIdleTimeout = timeout
+	}
+}
+
+// WithSessionQueueSize 设置每个会话缓存的数据包数,缓存满时丢弃新数据包,默认64
+func WithSessionQueueSize(size int) Option {
+	return func(opts *Options) {
+		opts.sessionQueueSize = size
+	}
+}
+
+// WithMaxPacketSize 设置接收数据包的最大长度,超过的部分被截断,默认65535
+func WithMaxPacketSize(size int) Option {
+	return func(opts *Options) {
+		opts.maxPacketSize = size
+	}
+}
+
+// PacketListener 数据包监听,按对端地址把数据包分发到会话
+// 只有地址过滤规则对会话生效,连接数限制不生效
+type PacketListener struct {
+	conn  net.PacketConn
+	opts  *Options
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	// unixgram时需要删除的socket文件
+	socketPath string
+
+	mu       sync.Mutex
+	sessions map[string]*PacketSession
+	closed   bool
+
+	accept chan *PacketSession
+	done   chan struct{}
+}
+
+// ListenPacket 监听udp或unixgram地址,network通过WithNetwork设置,默认udp
+func ListenPacket(endpoint string, options ...Option) (*PacketListener, error) {
+	opts := loadOptions(append([]Option{WithNetwork("udp")}, options...)...)
+	if opts.sessionIdleTimeout <= 0 {
+		opts.sessionIdleTimeout = defaultSessionIdleTimeout
+	}
+	if opts.sessionQueueSize <= 0 {
+		opts.sessionQueueSize = defaultSessionQueueSize
+	}
+	if opts.maxPacketSize <= 0 {
+		opts.maxPacketSize = defaultMaxPacketSize
+	}
+
+	allow, err := parseCIDRs(opts.allowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(opts.denyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	socketPath := ""
+	switch opts.network {
+	case "udp", "udp4", "udp6":
+	case "unixgram":
+		if err = removeStaleSocket(opts.network, endpoint); err != nil {
+			return nil, err
+		}
+		if !isAbstractSocket(endpoint) {
+			socketPath = endpoint
+		}
+	default:
+		return nil, net.UnknownNetworkError(opts.network)
+	}
+
+	var conn net.PacketConn
+	bind := func(address string) (err error) {
+		if conn, err = net.ListenPacket(opts.network, address); err != nil {
+			return errors.Wrapf(err, "无法绑定地址 [network=%v, address=%v]", opts.network, endpoint)
+		}
+		return nil
+	}
+	if opts.network == "unixgram" {
+		err = listenSocket(endpoint, opts, bind)
+	} else {
+		err = bind(endpoint)
+	}
+	if err != nil {
+		if conn != nil {
+			_ = conn.Close()
+			_ = os.Remove(socketPath)
+		}
+		return nil, err
+	}
+
+	l := &PacketListener{
+		conn:       conn,
+		opts:       opts,
+		allow:      allow,
+		deny:       deny,
+		socketPath: socketPath,
+		sessions:   make(map[string]*PacketSession),
+		accept:     make(chan *PacketSession, acceptQueueSize),
+		done:       make(chan struct{}),
+	}
+	go l.readLoop()
+	go l.expireLoop()
+	return l, nil
+}
+
+// Accept 等待新的对端会话
+func (l *PacketListener) Accept() (*PacketSession, error) {
+	select {
+	case s := <-l.accept:
+		return s, nil
+	case <-l.done:
+		// 关闭前已收到的会话仍然返回
+		select {
+		case s := <-l.accept:
+			return s, nil
+		default:
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+// Addr 返回监听地址
+func (l *PacketListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Close 停止监听并关闭所有会话
+func (l *PacketListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	sessions := l.sessions
+	l.sessions = make(map[string]*PacketSession)
+	l.mu.Unlock()
+
+	err := l.conn.Close()
+	for _, s := range sessions {
+		s.close()
+	}
+	if l.socketPath != "" {
+		_ = os.Remove(l.socketPath)
+	}
+	return err
+}
+
+func (l *PacketListener) allowed(addr net.Addr) bool {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return true
+	}
+	if containsIP(l.deny, udp.IP) {
+		return false
+	}
+	return len(l.allow) == 0 || containsIP(l.allow, udp.IP)
+}
+
+func (l *PacketListener) readLoop() {
+	defer close(l.done)
+	defer func() {
+		_ = l.Close()
+	}()
+
+	buf := make([]byte, l.opts.maxPacketSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if isTemporary(err) {
+				continue
+			}
+			if !errors.Is(err, net.ErrClosed) {
+				l.opts.reportError(errors.Wrapf(err, "无法读取数据包"))
+			}
+			return
+		}
+		// 未绑定地址的unixgram对端无法回复
+		if addr == nil || addr.String() == "" || !l.allowed(addr) {
+			continue
+		}
+
+		s := l.session(addr)
+		if s == nil {
+			continue
+		}
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		s.deliver(packet)
+	}
+}
+
+// session 查找或创建对端会话,新会话无法交给Accept时返回nil
+func (l *PacketListener) session(addr net.Addr) *PacketSession {
+	key := addr.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	if s, ok := l.sessions[key]; ok {
+		return s
+	}
+
+	s := newPacketSession(l, addr)
+	select {
+	case l.accept <- s:
+	default:
+		return nil
+	}
+	l.sessions[key] = s
+	return s
+}
+
+func (l *PacketListener) remove(s *PacketSession) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions[s.key] == s {
+		delete(l.sessions, s.key)
+	}
+}
+
+// expireLoop 定期关闭空闲会话
+func (l *PacketListener) expireLoop() {
+	interval := l.opts.sessionIdleTimeout / 2
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.done:
+			return
+		}
+
+		var expired []*PacketSession
+		l.mu.Lock()
+		for _, s := range l.sessions {
+			if s.idle() >= l.opts.sessionIdleTimeout {
+				expired = append(expired, s)
+			}
+		}
+		l.mu.Unlock()
+		for _, s := range expired {
+			_ = s.Close()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnixStreamServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.sock")
+	// 遗留的socket文件被删除
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	srv := NewServer(path, WithNetwork("unix"), WithSocketPerm(0600))
+	require.NoError(t, srv.Serve(echoHandler))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	// 创建socket的临时目录已删除
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// 正在使用的socket不能重复绑定
+	require.Error(t, NewServer(path, WithNetwork("unix")).Serve(echoHandler))
+
+	conn, err := client.NewTCPConnection(path, client.WithNetwork("unix"))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, conn.CloseWrite())
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+	require.NoError(t, conn.Close())
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func echoSessions(l *PacketListener) {
+	for {
+		s, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer s.Close()
+			for {
+				packet, err := s.ReadPacket()
+				if err != nil {
+					return
+				}
+				_, _ = s.Write(packet)
+			}
+		}()
+	}
+}
+
+func TestPacketListener(t *testing.T) {
+	udpListener, err := ListenPacket("127.0.0.1:0")
+	require.NoError(t, err)
+	defer udpListener.Close()
+	go echoSessions(udpListener)
+
+	path := filepath.Join(t.TempDir(), "dgram.sock")
+	unixListener, err := ListenPacket(path, WithNetwork("unixgram"))
+	require.NoError(t, err)
+	go echoSessions(unixListener)
+
+	dials := []struct {
+		endpoint string
+		network  string
+	}{
+		{udpListener.Addr().String(), "udp"},
+		{path, "unixgram"},
+	}
+	for _, d := range dials {
+		// 每个对端独立会话
+		for i := 0; i < 2; i++ {
+			conn, err := client.NewPacketConnection(d.endpoint, client.WithNetwork(d.network))
+			require.NoError(t, err)
+			buf := make([]byte, 16)
+			for _, msg := range []string{"a", "bb", "ccc"} {
+				_, err = conn.Write([]byte(msg))
+				require.NoError(t, err)
+				require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+				n, err := conn.Read(buf)
+				require.NoError(t, err)
+				require.Equal(t, msg, string(buf[:n]))
+			}
+			require.NoError(t, conn.Close())
+		}
+	}
+
+	require.NoError(t, unixListener.Close())
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPacketSessionIdleTimeout(t *testing.T) {
+	l, err := ListenPacket("127.0.0.1:0", WithSessionIdleTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer l.Close()
+
+	conn, err := client.NewPacketConnection(l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("x"))
+	require.NoError(t, err)
+
+	s, err := l.Accept()
+	require.NoError(t, err)
+	packet, err := s.ReadPacket()
+	require.NoError(t, err)
+	require.Equal(t, "x", string(packet))
+
+	require.NoError(t, s.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = s.ReadPacket()
+	require.ErrorIs(t, err, ErrSessionClosed)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PacketSession 同一对端的数据包会话,实现net.Conn
+// 每次Read返回一个数据包,缓冲区不足时多余部分被丢弃;每次Write发送一个数据包
+type PacketSession struct {
+	listener *PacketListener
+	addr     net.Addr
+	key      string
+	queue    chan []byte
+	// 最后一次收发的unix纳秒时间
+	last atomic.Int64
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	// 读截止时间变化时关闭,唤醒阻塞中的Read
+	deadlineChanged chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newPacketSession(l *PacketListener, addr net.Addr) *PacketSession {
+	s := &PacketSession{
+		listener:        l,
+		addr:            addr,
+		key:             addr.String(),
+		queue:           make(chan []byte, l.opts.sessionQueueSize),
+		deadlineChanged: make(chan struct{}),
+		closed:          make(chan struct{}),
+	}
+	s.touch()
+	return s
+}
+
+// ReadPacket 读取一个完整的数据包
+func (s *PacketSession) ReadPacket() ([]byte, error) {
+	for {
+		s.mu.Lock()
+		deadline, changed := s.readDeadline, s.deadlineChanged
+		s.mu.Unlock()
+
+		// 关闭前已收到的数据包仍然返回
+		select {
+		case packet := <-s.queue:
+			return packet, nil
+		default:
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case packet := <-s.queue:
+			stopTimer(timer)
+			return packet, nil
+		case <-s.closed:
+			stopTimer(timer)
+			return nil, ErrSessionClosed
+		case <-timeout:
+			return nil, os.ErrDeadlineExceeded
+		case <-changed:
+			stopTimer(timer)
+		}
+	}
+}
+
+func (s *PacketSession) Read(p []byte) (int, error) {
+	packet, err := s.ReadPacket()
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, packet), nil
+}
+
+func (s *PacketSession) Write(p []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, ErrSessionClosed
+	default:
+	}
+	s.touch()
+	return s.listener.conn.WriteTo(p, s.addr)
+}
+
+// Close 关闭会话,不影响监听,对端再发送数据包时产生新的会话
+func (s *PacketSession) Close() error {
+	s.listener.remove(s)
+	s.close()
+	return nil
+}
+
+func (s *PacketSession) LocalAddr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *PacketSession) RemoteAddr() net.Addr {
+	return s.addr
+}
+
+func (s *PacketSession) SetDeadline(t time.Time) error {
+	return s.SetReadDeadline(t)
+}
+
+func (s *PacketSession) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	close(s.deadlineChanged)
+	s.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline 数据包写入不会阻塞,忽略写截止时间
+func (s *PacketSession) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (s *PacketSession) close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+}
+
+// deliver 缓存收到的数据包,缓存满时丢弃
+func (s *PacketSession) deliver(packet []byte) {
+	s.touch()
+	select {
+	case s.queue <- packet:
+	default:
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+func (s *PacketSession) touch() {
+	s.last.Store(time.Now().UnixNano())
+}
+
+func (s *PacketSession) idle() time.Duration {
+	return time.Since(time.Unix(0, s.last.Load()))
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bufio"
+	"github.com/lngwu11/toolgo/netbase/proxyproto"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认读取PROXY协议头部的超时时间
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+// WithProxyProtocol 解析来自负载均衡的PROXY协议v1/v2头部,连接的RemoteAddr和LocalAddr返回原始连接的地址
+// timeout为读取头部的超时时间,默认5s;trustedCIDRs为发送头部的可信来源,tcp监听至少设置一个,
+// 否则启动监听返回错误,信任所有来源需要显式设置"0.0.0.0/0"和"::/0";unix socket的连接总是可信,
+// 能否连接由WithSocketPerm控制。
+// 可信来源的连接必须以头部开始,否则读取返回错误;其他来源的连接不解析头部。
+// 头部在处理函数运行前读取,读取期间不占用接收连接的goroutine。
+// 连接数限制和地址过滤规则作用于负载均衡的地址
+func WithProxyProtocol(timeout time.Duration, trustedCIDRs ...string) Option {
+	return func(opts *Options) {
+		opts.proxyProtocol = true
+		opts.proxyHeaderTimeout = timeout
+		opts.proxyTrustedCIDRs = append(opts.proxyTrustedCIDRs, trustedCIDRs...)
+	}
+}
+
+// proxyConn 在交给处理函数前读取PROXY协议头部,读取完成前获取地址返回负载均衡的地址
+type proxyConn struct {
+	utils.ConnReadWriteCloser
+	timeout time.Duration
+	reader  *bufio.Reader
+
+	once   sync.Once
+	ready  atomic.Bool
+	header *proxyproto.Header
+	err    error
+
+	// 调用者设置的读截止时间,读取头部后恢复
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+func newProxyConn(conn utils.ConnReadWriteCloser, timeout time.Duration) *proxyConn {
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	return &proxyConn{
+		ConnReadWriteCloser: conn,
+		timeout:             timeout,
+		reader:              bufio.NewReader(conn),
+	}
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		_ = c.ConnReadWriteCloser.SetReadDeadline(time.Now().Add(c.timeout))
+		c.header, c.err = proxyproto.Read(c.reader)
+		if c.err != nil {
+			c.err = errors.Wrapf(c.err, "无法读取PROXY协议头部 [remote=%v]", c.ConnReadWriteCloser.RemoteAddr())
+		}
+		c.mu.Lock()
+		_ = c.ConnReadWriteCloser.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+		c.ready.Store(true)
+	})
+}
+
+// awaitProxyHeader 连接解析PROXY协议时读取头部,在每个连接自己的goroutine中调用,
+// 之后获取地址不再阻塞
+func awaitProxyHeader(c net.Conn) {
+	if limited, ok := c.(*limitedConn); ok {
+		if proxy, ok := limited.ConnReadWriteCloser.(*proxyConn); ok {
+			proxy.readHeader()
+		}
+	}
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr 返回原始连接的源地址,头部没有读取完成或没有地址信息时返回负载均衡的地址
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.ready.Load() && c.header != nil && c.header.Command == proxyproto.CommandProxy && c.header.SourceAddr != nil {
+		return c.header.SourceAddr
+	}
+	return c.ConnReadWriteCloser.RemoteAddr()
+}
+
+// LocalAddr 返回原始连接的目标地址
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.ready.Load() && c.header != nil && c.header.Command == proxyproto.CommandProxy && c.header.DestinationAddr != nil {
+		return c.header.DestinationAddr
+	}
+	return c.ConnReadWriteCloser.LocalAddr()
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ConnReadWriteCloser.SetWriteDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.ConnReadWriteCloser.SetReadDeadline(t)
+}
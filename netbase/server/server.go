@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"net"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrServerClosed Serve之后服务已关闭
+var ErrServerClosed = errors.New("server closed")
+
+// Handler 连接处理函数,返回后连接被关闭
+// ctx在服务Shutdown或Close时取消
+type Handler func(ctx context.Context, conn utils.ConnReadWriteCloser)
+
+// Server 管理监听、连接处理和优雅关闭的tcp服务
+type Server struct {
+	endpoint string
+	opts     *Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	listener *limitListener
+	conns    map[utils.ConnReadWriteCloser]struct{}
+	closed   bool
+	err      error
+
+	handlers sync.WaitGroup
+	done     chan struct{}
+}
+
+// NewServer 创建服务,调用Serve后开始监听
+func NewServer(endpoint string, options ...Option) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		endpoint: endpoint,
+		opts:     loadOptions(options...),
+		ctx:      ctx,
+		cancel:   cancel,
+		conns:    make(map[utils.ConnReadWriteCloser]struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Serve 绑定地址并在后台接收连接,每个连接在独立的goroutine中交给handler处理
+// 绑定失败时返回错误;接收连接的错误通过WithErrorHandler回调和Err返回
+func (s *Server) Serve(handler Handler) error {
+	if handler == nil {
+		return errors.New("handler cannot be nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	if s.listener != nil {
+		return errors.New("server already serving")
+	}
+
+	listener, err := listen(s.endpoint, s.opts)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	go s.acceptLoop(listener, handler)
+	return nil
+}
+
+// Addr 返回监听地址,Serve之前返回nil
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stats 返回连接统计,Serve之前返回零值
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+	if listener == nil {
+		return Stats{}
+	}
+	return listener.Stats()
+}
+
+// ActiveConnections 返回正在处理的连接数
+func (s *Server) ActiveConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Err 返回导致停止接收连接的错误,服务正常关闭时返回nil
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Done 停止接收连接后关闭
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Shutdown 停止接收新连接,取消handler的ctx并等待所有handler返回
+// ctx到期时强制关闭剩余连接,等待handler返回后返回ctx的错误
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopAccept()
+
+	finished := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		s.closeConns()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// Close 立即停止接收连接并关闭所有连接
+func (s *Server) Close() error {
+	s.stopAccept()
+	s.closeConns()
+	s.handlers.Wait()
+	return nil
+}
+
+func (s *Server) stopAccept() {
+	s.mu.Lock()
+	listener := s.listener
+	alreadyClosed := s.closed
+	s.closed = true
+	s.mu.Unlock()
+
+	s.cancel()
+	if alreadyClosed {
+		return
+	}
+	if listener != nil {
+		_ = listener.Close()
+		<-s.done
+	} else {
+		close(s.done)
+	}
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *Server) acceptLoop(listener *limitListener, handler Handler) {
+	defer close(s.done)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			if !closed {
+				s.err = errors.Wrapf(err, "无法获取tcp连接")
+			}
+			s.mu.Unlock()
+			if !closed {
+				s.opts.reportError(s.Err())
+			}
+			return
+		}
+
+		conn := prepareConn(c, s.opts)
+		if !s.track(conn) {
+			_ = conn.Close()
+			continue
+		}
+		go s.handle(c, conn, handler)
+	}
+}
+
+// track 记录连接,服务已关闭时返回false
+func (s *Server) track(conn utils.ConnReadWriteCloser) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *Server) handle(c net.Conn, conn utils.ConnReadWriteCloser, handler Handler) {
+	defer s.handlers.Done()
+	defer func() {
+		_ = conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			s.opts.reportError(fmt.Errorf("连接处理异常 [remote=%v]: %v: %s", conn.RemoteAddr(), r, debug.Stack()))
+		}
+	}()
+
+	awaitProxyHeader(c)
+	handler(s.ctx, conn)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/lngwu11/toolgo/netbase/proxyproto"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(_ context.Context, conn utils.ConnReadWriteCloser) {
+	_, _ = io.Copy(conn, conn)
+}
+
+func TestServerShutdownWaitsForHandlers(t *testing.T) {
+	srv := NewServer("127.0.0.1:0")
+	finished := make(chan struct{})
+	require.NoError(t, srv.Serve(func(ctx context.Context, conn utils.ConnReadWriteCloser) {
+		<-ctx.Done()
+		close(finished)
+	}))
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return srv.ActiveConnections() == 1 }, time.Second, time.Millisecond)
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+	<-finished
+	require.Zero(t, srv.ActiveConnections())
+	require.NoError(t, srv.Err())
+
+	_, err = net.Dial("tcp", srv.Addr().String())
+	require.Error(t, err)
+}
+
+func TestServerShutdownForceClose(t *testing.T) {
+	srv := NewServer("127.0.0.1:0")
+	require.NoError(t, srv.Serve(echoHandler))
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// The echo handler ignores ctx, so the deadline force-closes it.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+	_, err = conn.Read(buf)
+	require.Error(t, err)
+}
+
+func TestServerConnectionLimits(t *testing.T) {
+	srv := NewServer("127.0.0.1:0",
+		WithMaxConnectionsPerIP(1),
+		WithDenyCIDRs("10.0.0.0/8"),
+	)
+	require.NoError(t, srv.Serve(func(ctx context.Context, conn utils.ConnReadWriteCloser) {
+		<-ctx.Done()
+	}))
+
+	first, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	require.Eventually(t, func() bool { return srv.ActiveConnections() == 1 }, time.Second, time.Millisecond)
+
+	second, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	_, err = second.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, uint64(1), srv.Stats().RejectedPerIP)
+
+	require.NoError(t, srv.Close())
+	require.Zero(t, srv.Stats().Active)
+}
+
+func TestServerDenyRule(t *testing.T) {
+	srv := NewServer("127.0.0.1:0", WithAllowCIDRs("10.0.0.0/8"))
+	require.NoError(t, srv.Serve(echoHandler))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.Equal(t, uint64(1), srv.Stats().RejectedByRule)
+
+	require.Error(t, NewServer("127.0.0.1:0", WithDenyCIDRs("bogus")).Serve(echoHandler))
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	remoteHandler := func(_ context.Context, conn utils.ConnReadWriteCloser) {
+		_, _ = conn.Write([]byte(conn.RemoteAddr().String()))
+	}
+	source := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 40000}
+	destination := &net.TCPAddr{IP: net.ParseIP("198.51.100.1").To4(), Port: 443}
+
+	trusted := NewServer("127.0.0.1:0", WithProxyProtocol(100*time.Millisecond, "127.0.0.0/8"))
+	require.NoError(t, trusted.Serve(remoteHandler))
+	defer trusted.Close()
+	untrusted := NewServer("127.0.0.1:0", WithProxyProtocol(time.Second, "10.0.0.0/8"))
+	require.NoError(t, untrusted.Serve(remoteHandler))
+	defer untrusted.Close()
+
+	readRemote := func(addr string, options ...client.Option) string {
+		conn, err := client.NewTCPConnection(addr, options...)
+		require.NoError(t, err)
+		defer conn.Close()
+		got, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(got)
+	}
+
+	for _, version := range []int{1, 2} {
+		header := &proxyproto.Header{Version: version, Command: proxyproto.CommandProxy,
+			SourceAddr: source, DestinationAddr: destination}
+		require.Equal(t, source.String(), readRemote(trusted.Addr().String(), client.WithProxyHeader(header)))
+	}
+
+	// 不可信来源不解析头部
+	remote := readRemote(untrusted.Addr().String())
+	require.Contains(t, remote, "127.0.0.1:")
+
+	// 可信来源没有头部时读取头部超时,地址为负载均衡的地址
+	require.Contains(t, readRemote(trusted.Addr().String()), "127.0.0.1:")
+
+	// tcp监听必须设置可信来源,信任所有来源需要显式设置
+	require.Error(t, NewServer("127.0.0.1:0", WithProxyProtocol(time.Second)).Serve(remoteHandler))
+	all := NewServer("127.0.0.1:0", WithProxyProtocol(time.Second, "0.0.0.0/0", "::/0"))
+	require.NoError(t, all.Serve(remoteHandler))
+	defer all.Close()
+	header := &proxyproto.Header{Version: 2, Command: proxyproto.CommandProxy,
+		SourceAddr: source, DestinationAddr: destination}
+	require.Equal(t, source.String(), readRemote(all.Addr().String(), client.WithProxyHeader(header)))
+
+	// unix socket的连接总是可信
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	unix := NewServer(path, WithNetwork("unix"), WithProxyProtocol(time.Second))
+	require.NoError(t, unix.Serve(remoteHandler))
+	defer unix.Close()
+	require.Equal(t, source.String(), readRemote(path, client.WithNetwork("unix"), client.WithProxyHeader(header)))
+}
+
+func TestProxyConnAddrDoesNotBlock(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	conn := newProxyConn(utils.ToConnReadWriteCloser(b), time.Minute)
+	defer conn.Close()
+
+	// 头部没有读取时返回负载均衡的地址,不等待头部
+	start := time.Now()
+	require.Equal(t, b.RemoteAddr(), conn.RemoteAddr())
+	require.Equal(t, b.LocalAddr(), conn.LocalAddr())
+	require.Less(t, time.Since(start), time.Second)
+
+	source := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 40000}
+	header := &proxyproto.Header{Version: 2, Command: proxyproto.CommandProxy,
+		SourceAddr: source, DestinationAddr: source}
+	go func() {
+		_, _ = header.WriteTo(a)
+	}()
+	conn.readHeader()
+	require.NoError(t, conn.err)
+	require.Equal(t, source, conn.RemoteAddr())
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/lngwu11/toolgo/utils"
 	"github.com/pkg/errors"
 	"net"
+	"os"
 	"time"
 )
 
@@ -24,9 +25,32 @@ type Options struct {
 	keepAlivePeriod time.Duration
 	// tls配置
 	tlsConfig *tls.Config
+	// 接收连接出错时的回调
+	errorHandler func(err error)
+	// 连接数限制
+	maxConnections        int
+	blockOnMaxConnections bool
+	maxConnectionsPerIP   int
+	// 远端地址过滤
+	allowCIDRs []string
+	denyCIDRs  []string
+	// 临时错误退避
+	acceptMinBackoff time.Duration
+	acceptMaxBackoff time.Duration
+	// PROXY协议配置
+	proxyProtocol      bool
+	proxyHeaderTimeout time.Duration
+	proxyTrustedCIDRs  []string
+	// unix socket文件权限
+	socketPerm os.FileMode
+	// 数据包会话配置
+	sessionIdleTimeout time.Duration
+	sessionQueueSize   int
+	maxPacketSize      int
 }
 
-// WithNetwork .
+// WithNetwork 设置network,支持tcp、tcp4、tcp6和unix,默认tcp;
+// ListenPacket支持udp、udp4、udp6和unixgram,默认udp
 func WithNetwork(network string) Option {
 	return func(opts *Options) {
 		opts.network = network
@@ -47,6 +71,13 @@ func WithTLSConfig(tlsConfig *tls.Config) Option {
 	}
 }
 
+// WithErrorHandler 设置接收连接出错时的回调
+func WithErrorHandler(handler func(err error)) Option {
+	return func(opts *Options) {
+		opts.errorHandler = handler
+	}
+}
+
 func loadOptions(options ...Option) *Options {
 	opts := new(Options)
 	for _, option := range options {
@@ -61,29 +92,77 @@ func loadOptions(options ...Option) *Options {
 	return opts
 }
 
-func RunTCPListener(endpoint string, connCh chan<- utils.ConnReadWriteCloser, options ...Option) (listener net.Listener, err error) {
-	opts := loadOptions(options...)
+func (opts *Options) reportError(err error) {
+	if opts.errorHandler != nil {
+		opts.errorHandler(err)
+	}
+}
 
+func listen(endpoint string, opts *Options) (*limitListener, error) {
 	switch opts.network {
 	case "tcp", "tcp4", "tcp6":
+	case "unix":
+		if err := removeStaleSocket(opts.network, endpoint); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, net.UnknownNetworkError(opts.network)
 	}
 
-	if opts.tlsConfig != nil {
-		// tls listen
-		listener, err = tls.Listen(opts.network, endpoint, opts.tlsConfig)
-		if err != nil {
-			err = errors.Wrapf(err, "无法绑定tcp地址 [address=%v]", endpoint)
-			return
+	var listener net.Listener
+	bind := func(address string) (err error) {
+		if listener, err = net.Listen(opts.network, address); err != nil {
+			return errors.Wrapf(err, "无法绑定地址 [network=%v, address=%v]", opts.network, endpoint)
+		}
+		return nil
+	}
+	var err error
+	socketPath := ""
+	if opts.network == "unix" {
+		err = listenSocket(endpoint, opts, bind)
+		// socket文件可能被移动过,由limitListener在关闭时删除
+		if unixListener, ok := listener.(*net.UnixListener); ok && !isAbstractSocket(endpoint) {
+			unixListener.SetUnlinkOnClose(false)
+			socketPath = endpoint
 		}
 	} else {
-		// normal listen
-		listener, err = net.Listen(opts.network, endpoint)
-		if err != nil {
-			err = errors.Wrapf(err, "无法绑定tcp地址 [address=%v]", endpoint)
-			return
+		err = bind(endpoint)
+	}
+	if err != nil {
+		if listener != nil {
+			_ = listener.Close()
 		}
+		return nil, err
+	}
+
+	limited, err := newLimitListener(listener, opts)
+	if err != nil {
+		_ = listener.Close()
+		if socketPath != "" {
+			_ = os.Remove(socketPath)
+		}
+		return nil, err
+	}
+	limited.socketPath = socketPath
+	return limited, nil
+}
+
+// prepareConn 需要时建立tls连接
+func prepareConn(c net.Conn, opts *Options) utils.ConnReadWriteCloser {
+	if opts.tlsConfig != nil {
+		c = tls.Server(c, opts.tlsConfig)
+	}
+	return utils.ToConnReadWriteCloser(c)
+}
+
+// RunTCPListener 在后台接收连接并发送到connCh,通过WithNetwork("unix")监听unix socket
+// 返回的listener实现了StatsListener,关闭listener即停止接收
+func RunTCPListener(endpoint string, connCh chan<- utils.ConnReadWriteCloser, options ...Option) (net.Listener, error) {
+	opts := loadOptions(options...)
+
+	listener, err := listen(endpoint, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	go func() {
@@ -92,21 +171,25 @@ func RunTCPListener(endpoint string, connCh chan<- utils.ConnReadWriteCloser, op
 		}()
 
 		for {
-			var c net.Conn
-			c, err = listener.Accept()
+			c, err := listener.Accept()
 			if err != nil {
-				err = errors.Wrapf(err, "无法获取tcp连接")
+				if !errors.Is(err, net.ErrClosed) {
+					opts.reportError(errors.Wrapf(err, "无法获取tcp连接"))
+				}
 				return
 			}
 
-			if tcp, ok := c.(*net.TCPConn); ok {
-				_ = tcp.SetKeepAlive(true)
-				_ = tcp.SetKeepAlivePeriod(opts.keepAlivePeriod)
+			if !opts.proxyProtocol {
+				connCh <- prepareConn(c, opts)
+				continue
 			}
-
-			connCh <- c.(utils.ConnReadWriteCloser)
+			// 在单独的goroutine中读取PROXY协议头部,避免阻塞接收
+			go func(c net.Conn) {
+				awaitProxyHeader(c)
+				connCh <- prepareConn(c, opts)
+			}(c)
 		}
 	}()
 
-	return
+	return listener, nil
 }
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 检查已有socket文件是否仍在使用的拨号超时时间
+const staleSocketDialTimeout = 100 * time.Millisecond
+
+// WithSocketPerm 设置unix socket文件的权限,默认由umask决定
+// 设置后socket先在同一目录下的临时目录中创建,需要目录可写
+func WithSocketPerm(perm os.FileMode) Option {
+	return func(opts *Options) {
+		opts.socketPerm = perm
+	}
+}
+
+// isAbstractSocket linux抽象命名空间的socket没有对应文件
+func isAbstractSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// removeStaleSocket 删除上次运行遗留的socket文件
+// 文件不是socket或仍有进程在监听时返回错误
+func removeStaleSocket(network, path string) error {
+	if isAbstractSocket(path) {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "无法读取socket文件 [path=%v]", path)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf("文件已存在且不是socket [path=%v]", path)
+	}
+	// unixgram无法通过拨号判断是否在使用,只检查stream socket
+	if network == "unix" {
+		if conn, err := net.DialTimeout(network, path, staleSocketDialTimeout); err == nil {
+			_ = conn.Close()
+			return errors.Errorf("socket正在使用 [path=%v]", path)
+		}
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "无法删除socket文件 [path=%v]", path)
+	}
+	return nil
+}
+
+// listenSocket 调用bind创建socket文件,设置了权限时先在同一目录下0700的临时目录中绑定并设置权限,
+// 再移动到path,socket文件在任何时刻的权限都不比配置的宽松。
+// bind成功而之后失败时调用者需要关闭已创建的socket,临时文件由listenSocket删除
+func listenSocket(path string, opts *Options, bind func(path string) error) error {
+	if opts.socketPerm == 0 || isAbstractSocket(path) {
+		return bind(path)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock")
+	if err != nil {
+		return errors.Wrapf(err, "无法创建socket临时目录 [path=%v]", path)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	tmp := filepath.Join(dir, "s")
+	if err = bind(tmp); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp, opts.socketPerm); err != nil {
+		return errors.Wrapf(err, "无法设置socket文件权限 [path=%v]", path)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return errors.Wrapf(err, "无法移动socket文件 [path=%v]", path)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+// 地址类型
+const (
+	addrIPv4   = 0x01
+	addrDomain = 0x03
+	addrIPv6   = 0x04
+)
+
+// errAddressType 不支持的地址类型
+var errAddressType = errors.New("address type not supported")
+
+// address 请求和UDP数据包中的地址,name和ip只设置一个
+type address struct {
+	name string
+	ip   net.IP
+	port int
+}
+
+func (a address) String() string {
+	host := a.name
+	if a.ip != nil {
+		host = a.ip.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.port))
+}
+
+// readAddress 读取 | 地址类型 | 地址 | 端口 |
+func readAddress(r io.Reader) (address, error) {
+	var typ [1]byte
+	if _, err := io.ReadFull(r, typ[:]); err != nil {
+		return address{}, err
+	}
+
+	var a address
+	switch typ[0] {
+	case addrIPv4:
+		a.ip = make(net.IP, net.IPv4len)
+		if _, err := io.ReadFull(r, a.ip); err != nil {
+			return address{}, err
+		}
+	case addrIPv6:
+		a.ip = make(net.IP, net.IPv6len)
+		if _, err := io.ReadFull(r, a.ip); err != nil {
+			return address{}, err
+		}
+	case addrDomain:
+		var size [1]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return address{}, err
+		}
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return address{}, err
+		}
+		// 部分客户端把IP地址作为主机名发送
+		if ip := net.ParseIP(string(name)); ip != nil {
+			a.ip = ip
+		} else {
+			a.name = string(name)
+		}
+	default:
+		return address{}, errAddressType
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return address{}, err
+	}
+	a.port = int(binary.BigEndian.Uint16(port[:]))
+	return a, nil
+}
+
+// appendAddress 追加 | 地址类型 | 地址 | 端口 |
+func appendAddress(b []byte, a address) []byte {
+	switch {
+	case a.ip == nil:
+		b = append(b, addrDomain, byte(len(a.name)))
+		b = append(b, a.name...)
+	case a.ip.To4() != nil:
+		b = append(b, addrIPv4)
+		b = append(b, a.ip.To4()...)
+	default:
+		b = append(b, addrIPv6)
+		b = append(b, a.ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(a.port))
+}
+
+// fromNetAddr 转换tcp和udp地址,其他地址返回0.0.0.0:0
+func fromNetAddr(addr net.Addr) address {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return address{ip: a.IP, port: a.Port}
+	case *net.UDPAddr:
+		return address{ip: a.IP, port: a.Port}
+	}
+	return address{ip: net.IPv4zero}
+}
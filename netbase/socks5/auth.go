@@ -0,0 +1,33 @@
+package socks5
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrAuthFailed 用户名或密码错误
+var ErrAuthFailed = errors.New("socks5 authentication failed")
+
+// Authenticator 校验用户名和密码,返回nil表示认证通过
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) error
+}
+
+// AuthenticatorFunc 函数形式的Authenticator
+type AuthenticatorFunc func(ctx context.Context, username, password string) error
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, username, password string) error {
+	return f(ctx, username, password)
+}
+
+// StaticCredentials 固定的用户名和密码表
+type StaticCredentials map[string]string
+
+func (c StaticCredentials) Authenticate(_ context.Context, username, password string) error {
+	expected, ok := c[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+		return ErrAuthFailed
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package socks5
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// rule 目标地址规则
+type rule struct {
+	ipNet *net.IPNet
+	// 主机名,"*"匹配任意地址,"*.example.com"匹配子域名
+	host string
+	// 端口,0匹配任意端口
+	port int
+}
+
+// parseRule 解析规则,格式为地址段、IP或主机名,可以带端口,如"10.0.0.0/8"、"[::1]:22"、"*.example.com:443"、"*:25"
+func parseRule(pattern string) (rule, error) {
+	var r rule
+	host := pattern
+	if _, _, err := net.ParseCIDR(pattern); err != nil && net.ParseIP(pattern) == nil {
+		if h, p, err := net.SplitHostPort(pattern); err == nil {
+			port, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return rule{}, errors.Errorf("无效的端口 [rule=%v]", pattern)
+			}
+			host, r.port = h, int(port)
+		}
+	}
+
+	if _, ipNet, err := net.ParseCIDR(host); err == nil {
+		r.ipNet = ipNet
+	} else if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		r.ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else if host != "" {
+		r.host = normalizeHost(host)
+	} else {
+		return rule{}, errors.Errorf("无效的规则 [rule=%v]", pattern)
+	}
+	return r, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// match name为请求中的主机名,请求IP地址时为空
+func (r rule) match(name string, ip net.IP, port int) bool {
+	if r.port != 0 && r.port != port {
+		return false
+	}
+	if r.ipNet != nil {
+		return r.ipNet.Contains(ip)
+	}
+	switch {
+	case r.host == "*":
+		return true
+	case strings.HasPrefix(r.host, "*."):
+		return strings.HasSuffix(name, r.host[1:])
+	default:
+		return name == r.host
+	}
+}
+
+// rules 目标地址的允许和拒绝规则
+type rules struct {
+	allow []rule
+	deny  []rule
+}
+
+func newRules(allow, deny []string) (*rules, error) {
+	r := new(rules)
+	for _, pattern := range allow {
+		parsed, err := parseRule(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.allow = append(r.allow, parsed)
+	}
+	for _, pattern := range deny {
+		parsed, err := parseRule(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.deny = append(r.deny, parsed)
+	}
+	return r, nil
+}
+
+// allowed 拒绝规则优先,设置了允许规则时只允许匹配的地址
+// 主机名的每个解析结果分别检查,主机名规则和IP规则都可以命中
+func (r *rules) allowed(name string, ip net.IP, port int) bool {
+	name = normalizeHost(name)
+	for _, rule := range r.deny {
+		if rule.match(name, ip, port) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, rule := range r.allow {
+		if rule.match(name, ip, port) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,422 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"github.com/lngwu11/toolgo/netbase"
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SOCKS5协议常量
+const (
+	socksVersion     = 0x05
+	authNone         = 0x00
+	authPassword     = 0x02
+	authNoAcceptable = 0xff
+	passwordVersion  = 0x01
+	cmdConnect       = 0x01
+	cmdUDPAssociate  = 0x03
+)
+
+// 响应状态
+const (
+	replySucceeded           = 0x00
+	replyGeneralFailure      = 0x01
+	replyNotAllowed          = 0x02
+	replyNetworkUnreachable  = 0x03
+	replyHostUnreachable     = 0x04
+	replyConnectionRefused   = 0x05
+	replyTTLExpired          = 0x06
+	replyCommandNotSupported = 0x07
+	replyAddressNotSupported = 0x08
+)
+
+const (
+	// 默认握手超时时间
+	defaultHandshakeTimeout = 10 * time.Second
+)
+
+// errDenied 目标地址被规则拒绝
+var errDenied = errors.New("destination denied by rules")
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 用户名密码认证,为nil时不认证
+	authenticator Authenticator
+	// 目标地址规则
+	allowDestinations []string
+	denyDestinations  []string
+	// 连接目标的配置
+	clientOptions []client.Option
+	// 握手超时时间
+	handshakeTimeout time.Duration
+	// 转发空闲超时时间
+	idleTimeout time.Duration
+	// 处理请求出错时的回调
+	errorHandler func(err error)
+}
+
+// WithAuthenticator 设置用户名密码认证,默认不认证
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(opts *Options) {
+		opts.authenticator = authenticator
+	}
+}
+
+// WithAllowDestinations 设置允许访问的目标地址,设置后只允许匹配的目标
+// 规则为地址段、IP或主机名,可以带端口,如"10.0.0.0/8"、"[::1]:22"、"*.example.com:443"、"*:25";
+// "*.example.com"只匹配子域名。主机名解析后每个地址分别检查,主机名规则和IP规则都可以命中
+func WithAllowDestinations(patterns ...string) Option {
+	return func(opts *Options) {
+		opts.allowDestinations = append(opts.allowDestinations, patterns...)
+	}
+}
+
+// WithDenyDestinations 设置禁止访问的目标地址,优先于允许规则,格式与WithAllowDestinations相同
+func WithDenyDestinations(patterns ...string) Option {
+	return func(opts *Options) {
+		opts.denyDestinations = append(opts.denyDestinations, patterns...)
+	}
+}
+
+// WithClientOptions 设置连接目标的配置,如拨号超时
+func WithClientOptions(options ...client.Option) Option {
+	return func(opts *Options) {
+		opts.clientOptions = append(opts.clientOptions, options...)
+	}
+}
+
+// WithHandshakeTimeout 设置认证和读取请求的超时时间,也是UDP转发时解析主机名的超时时间,默认10s
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.handshakeTimeout = timeout
+	}
+}
+
+// WithIdleTimeout 设置CONNECT转发的空闲超时时间,默认不超时
+func WithIdleTimeout(idleTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.idleTimeout = idleTimeout
+	}
+}
+
+// WithErrorHandler 设置处理请求出错时的回调
+func WithErrorHandler(handler func(err error)) Option {
+	return func(opts *Options) {
+		opts.errorHandler = handler
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.handshakeTimeout <= 0 {
+		opts.handshakeTimeout = defaultHandshakeTimeout
+	}
+	return opts
+}
+
+func (opts *Options) reportError(err error) {
+	if opts.errorHandler != nil {
+		opts.errorHandler(err)
+	}
+}
+
+// Stats 代理统计
+type Stats struct {
+	// 认证失败次数
+	AuthFailures uint64
+	// 被规则拒绝的目标数
+	Denied uint64
+	// 连接目标失败次数
+	DialFailures uint64
+}
+
+// UserStats 单个用户的统计,未开启认证时用户名为空
+type UserStats struct {
+	// 请求数
+	Requests uint64
+	// 进行中的请求数
+	Active int64
+	// 客户端发往目标的字节数
+	BytesUp int64
+	// 目标发往客户端的字节数
+	BytesDown int64
+}
+
+type userCounters struct {
+	requests  atomic.Uint64
+	active    atomic.Int64
+	bytesUp   atomic.Int64
+	bytesDown atomic.Int64
+}
+
+// Server SOCKS5代理,支持CONNECT和UDP ASSOCIATE
+type Server struct {
+	opts  *Options
+	rules *rules
+
+	mu    sync.Mutex
+	users map[string]*userCounters
+
+	authFailures atomic.Uint64
+	denied       atomic.Uint64
+	dialFailures atomic.Uint64
+}
+
+// NewServer 创建代理,规则格式错误时返回错误
+func NewServer(options ...Option) (*Server, error) {
+	opts := loadOptions(options...)
+	r, err := newRules(opts.allowDestinations, opts.denyDestinations)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		opts:  opts,
+		rules: r,
+		users: make(map[string]*userCounters),
+	}, nil
+}
+
+// Stats 返回代理统计
+func (s *Server) Stats() Stats {
+	return Stats{
+		AuthFailures: s.authFailures.Load(),
+		Denied:       s.denied.Load(),
+		DialFailures: s.dialFailures.Load(),
+	}
+}
+
+// Users 返回每个用户的统计
+func (s *Server) Users() map[string]UserStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make(map[string]UserStats, len(s.users))
+	for name, c := range s.users {
+		users[name] = UserStats{
+			Requests:  c.requests.Load(),
+			Active:    c.active.Load(),
+			BytesUp:   c.bytesUp.Load(),
+			BytesDown: c.bytesDown.Load(),
+		}
+	}
+	return users
+}
+
+func (s *Server) user(username string) *userCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.users[username]
+	if !ok {
+		c = new(userCounters)
+		s.users[username] = c
+	}
+	return c
+}
+
+// ServeConn 处理一个客户端连接,返回时连接已关闭
+// 签名与server.Handler一致,可以直接传给server.Server.Serve,也可以处理RunTCPListener接收的连接
+func (s *Server) ServeConn(ctx context.Context, conn utils.ConnReadWriteCloser) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(s.opts.handshakeTimeout))
+	username, err := s.negotiate(ctx, conn)
+	if err != nil {
+		s.opts.reportError(errors.Wrapf(err, "握手失败 [remote=%v]", conn.RemoteAddr()))
+		return
+	}
+
+	// 请求: | 版本 | 命令 | 保留 | 地址类型 | 地址 | 端口 |
+	var head [3]byte
+	if _, err = io.ReadFull(conn, head[:]); err != nil {
+		return
+	}
+	if head[0] != socksVersion {
+		s.opts.reportError(errors.Errorf("无效的SOCKS版本 [remote=%v, version=%v]", conn.RemoteAddr(), head[0]))
+		return
+	}
+	dest, err := readAddress(conn)
+	if err != nil {
+		if errors.Is(err, errAddressType) {
+			_ = writeReply(conn, replyAddressNotSupported, address{})
+		}
+		s.opts.reportError(errors.Wrapf(err, "无法读取目标地址 [remote=%v]", conn.RemoteAddr()))
+		return
+	}
+
+	user := s.user(username)
+	user.requests.Add(1)
+	user.active.Add(1)
+	defer user.active.Add(-1)
+
+	switch head[1] {
+	case cmdConnect:
+		err = s.connect(ctx, conn, user, dest)
+	case cmdUDPAssociate:
+		err = s.associate(ctx, conn, user, dest)
+	default:
+		_ = writeReply(conn, replyCommandNotSupported, address{})
+		err = errors.Errorf("不支持的命令 [cmd=%v]", head[1])
+	}
+	if err != nil {
+		s.opts.reportError(errors.Wrapf(err, "处理请求出错 [remote=%v, user=%v, dest=%v]", conn.RemoteAddr(), username, dest))
+	}
+}
+
+// negotiate 协商认证方式,返回认证通过的用户名
+func (s *Server) negotiate(ctx context.Context, conn utils.ConnReadWriteCloser) (string, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return "", err
+	}
+	if head[0] != socksVersion {
+		return "", errors.Errorf("无效的SOCKS版本 [version=%v]", head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(authNone)
+	if s.opts.authenticator != nil {
+		method = authPassword
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		_, _ = conn.Write([]byte{socksVersion, authNoAcceptable})
+		return "", errors.Errorf("客户端不支持认证方式 [method=%v]", method)
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == authNone {
+		return "", nil
+	}
+
+	// RFC 1929: | 版本 | 用户名长度 | 用户名 | 密码长度 | 密码 |
+	var ver [2]byte
+	if _, err := io.ReadFull(conn, ver[:]); err != nil {
+		return "", err
+	}
+	if ver[0] != passwordVersion {
+		return "", errors.Errorf("无效的认证版本 [version=%v]", ver[0])
+	}
+	username := make([]byte, ver[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return "", err
+	}
+	var size [1]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return "", err
+	}
+	password := make([]byte, size[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return "", err
+	}
+
+	if err := s.opts.authenticator.Authenticate(ctx, string(username), string(password)); err != nil {
+		s.authFailures.Add(1)
+		_, _ = conn.Write([]byte{passwordVersion, 0x01})
+		return "", errors.Wrapf(err, "认证失败 [user=%v]", string(username))
+	}
+	if _, err := conn.Write([]byte{passwordVersion, 0x00}); err != nil {
+		return "", err
+	}
+	return string(username), nil
+}
+
+// connect 连接目标并转发
+func (s *Server) connect(ctx context.Context, conn utils.ConnReadWriteCloser, user *userCounters, dest address) error {
+	ips, err := s.resolve(ctx, dest)
+	if err != nil {
+		_ = writeReply(conn, replyCode(err), address{})
+		return err
+	}
+	endpoints := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		endpoints = append(endpoints, net.JoinHostPort(ip.String(), strconv.Itoa(dest.port)))
+	}
+	upstream, _, err := client.NewMultiConnection(endpoints, s.opts.clientOptions...)
+	if err != nil {
+		s.dialFailures.Add(1)
+		_ = writeReply(conn, replyCode(err), address{})
+		return err
+	}
+	if err = writeReply(conn, replySucceeded, fromNetAddr(upstream.LocalAddr())); err != nil {
+		_ = upstream.Close()
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	_, err = netbase.Relay(conn, upstream,
+		netbase.WithRelayIdleTimeout(s.opts.idleTimeout),
+		netbase.WithRelayCounters(&user.bytesUp, &user.bytesDown),
+	)
+	return err
+}
+
+// resolve 解析目标地址,返回规则允许的IP
+func (s *Server) resolve(ctx context.Context, dest address) ([]net.IP, error) {
+	ips := []net.IP{dest.ip}
+	if dest.ip == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, dest.name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "无法解析主机名 [host=%v]", dest.name)
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	allowed := ips[:0]
+	for _, ip := range ips {
+		if s.rules.allowed(dest.name, ip, dest.port) {
+			allowed = append(allowed, ip)
+		}
+	}
+	if len(allowed) == 0 {
+		s.denied.Add(1)
+		return nil, errDenied
+	}
+	return allowed, nil
+}
+
+// writeReply 响应: | 版本 | 状态 | 保留 | 地址类型 | 地址 | 端口 |
+func writeReply(w io.Writer, code byte, bind address) error {
+	if bind.ip == nil && bind.name == "" {
+		bind.ip = net.IPv4zero
+	}
+	_, err := w.Write(appendAddress([]byte{socksVersion, code, 0}, bind))
+	return err
+}
+
+// replyCode 按错误类型返回响应状态
+func replyCode(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, errDenied):
+		return replyNotAllowed
+	case errors.As(err, &dnsErr), errors.Is(err, syscall.EHOSTUNREACH):
+		return replyHostUnreachable
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return replyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return replyNetworkUnreachable
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return replyTTLExpired
+	}
+	return replyGeneralFailure
+}
@@ -0,0 +1,203 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/lngwu11/toolgo/netbase/internal/nettest"
+	"github.com/lngwu11/toolgo/netbase/server"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// serve 通过server.Server运行代理,返回监听地址
+func serve(t *testing.T, s *Server) string {
+	srv := server.NewServer("127.0.0.1:0")
+	require.NoError(t, srv.Serve(s.ServeConn))
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+	return srv.Addr().String()
+}
+
+func TestConnect(t *testing.T) {
+	target := nettest.EchoServer(t)
+	s, err := NewServer(WithAuthenticator(StaticCredentials{"alice": "secret"}))
+	require.NoError(t, err)
+	addr := serve(t, s)
+
+	conn, err := client.NewTCPConnection(target, client.WithProxy("socks5://alice:secret@"+addr))
+	require.NoError(t, err)
+	nettest.RoundTripEcho(t, conn)
+	require.Equal(t, int64(1), s.Users()["alice"].Active)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		return s.Users()["alice"] == UserStats{Requests: 1, BytesUp: 5, BytesDown: 5}
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = client.NewTCPConnection(target, client.WithProxy("socks5://alice:wrong@"+addr))
+	require.Error(t, err)
+	_, err = client.NewTCPConnection(target, client.WithProxy("socks5://"+addr))
+	require.Error(t, err)
+	require.Equal(t, uint64(1), s.Stats().AuthFailures)
+}
+
+func TestRules(t *testing.T) {
+	target := nettest.EchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	s, err := NewServer(
+		WithAllowDestinations("localhost", "10.0.0.0/8"),
+		WithDenyDestinations("*:"+port),
+	)
+	require.NoError(t, err)
+	addr := serve(t, s)
+	_, err = client.NewTCPConnection(target, client.WithProxy("socks5://"+addr))
+	require.ErrorContains(t, err, "not allowed")
+
+	// 主机名规则允许解析出的地址
+	s, err = NewServer(WithAllowDestinations("localhost"))
+	require.NoError(t, err)
+	addr = serve(t, s)
+	conn, err := client.NewTCPConnection(net.JoinHostPort("localhost", port), client.WithProxy("socks5h://"+addr))
+	require.NoError(t, err)
+	nettest.RoundTripEcho(t, conn)
+	require.NoError(t, conn.Close())
+	_, err = client.NewTCPConnection(target, client.WithProxy("socks5://"+addr))
+	require.ErrorContains(t, err, "not allowed")
+	require.Equal(t, uint64(1), s.Stats().Denied)
+
+	_, err = NewServer(WithDenyDestinations("example.com:http"))
+	require.Error(t, err)
+}
+
+func TestRuleMatch(t *testing.T) {
+	r, err := newRules(nil, []string{"10.0.0.0/8", "[::1]:22", "*.example.com", "*:25"})
+	require.NoError(t, err)
+	require.False(t, r.allowed("", net.ParseIP("10.1.2.3"), 80))
+	require.False(t, r.allowed("", net.ParseIP("::1"), 22))
+	require.True(t, r.allowed("", net.ParseIP("::1"), 23))
+	require.False(t, r.allowed("www.Example.com.", net.ParseIP("1.1.1.1"), 443))
+	require.True(t, r.allowed("example.com", net.ParseIP("1.1.1.1"), 443))
+	require.False(t, r.allowed("", net.ParseIP("1.1.1.1"), 25))
+}
+
+// handshake 不认证,发送请求并返回响应中的地址
+func handshake(t *testing.T, conn net.Conn, cmd byte, dest address) (byte, address) {
+	_, err := conn.Write([]byte{socksVersion, 1, authNone})
+	require.NoError(t, err)
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, []byte{socksVersion, authNone}, reply)
+
+	_, err = conn.Write(appendAddress([]byte{socksVersion, cmd, 0}, dest))
+	require.NoError(t, err)
+	head := make([]byte, 3)
+	_, err = io.ReadFull(conn, head)
+	require.NoError(t, err)
+	bind, err := readAddress(conn)
+	require.NoError(t, err)
+	return head[1], bind
+}
+
+func TestUDPAssociate(t *testing.T) {
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer target.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := target.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = target.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	// 通过RunTCPListener接收连接
+	s, err := NewServer(WithDenyDestinations("127.0.0.2"))
+	require.NoError(t, err)
+	connCh := make(chan utils.ConnReadWriteCloser)
+	ln, err := server.RunTCPListener("127.0.0.1:0", connCh)
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for conn := range connCh {
+			go s.ServeConn(context.Background(), conn)
+		}
+	}()
+
+	ctrl, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer ctrl.Close()
+	code, bind := handshake(t, ctrl, cmdUDPAssociate, address{ip: net.IPv4zero})
+	require.Equal(t, byte(replySucceeded), code)
+
+	pc, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: bind.ip, Port: bind.port})
+	require.NoError(t, err)
+	defer pc.Close()
+
+	targetAddr := target.LocalAddr().(*net.UDPAddr)
+	send := func(dest address, payload string) {
+		packet := appendAddress([]byte{0, 0, 0}, dest)
+		_, err := pc.Write(append(packet, payload...))
+		require.NoError(t, err)
+	}
+	// 被拒绝的目标不转发
+	send(address{ip: net.IPv4(127, 0, 0, 2), port: targetAddr.Port}, "denied")
+	send(address{name: "localhost", port: targetAddr.Port}, "ping")
+
+	buf := make([]byte, 1024)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, err := pc.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0, 0, addrIPv4, 127, 0, 0, 1}, buf[:8])
+	require.Equal(t, uint16(targetAddr.Port), binary.BigEndian.Uint16(buf[8:10]))
+	require.Equal(t, "ping", string(buf[10:n]))
+
+	// 控制连接关闭后关联结束
+	require.NoError(t, ctrl.Close())
+	require.Eventually(t, func() bool {
+		return s.Users()[""] == UserStats{Requests: 1, BytesUp: 4, BytesDown: 4}
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, uint64(1), s.Stats().Denied)
+}
+
+func TestUnsupportedCommand(t *testing.T) {
+	s, err := NewServer()
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", serve(t, s))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// BIND
+	code, _ := handshake(t, conn, 0x02, address{ip: net.IPv4zero, port: 80})
+	require.Equal(t, byte(replyCommandNotSupported), code)
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestAddress(t *testing.T) {
+	for _, a := range []address{
+		{ip: net.ParseIP("1.2.3.4").To4(), port: 80},
+		{ip: net.ParseIP("2001:db8::1"), port: 443},
+		{name: "example.com", port: 8080},
+	} {
+		got, err := readAddress(bytes.NewReader(appendAddress(nil, a)))
+		require.NoError(t, err)
+		require.Equal(t, a.String(), got.String())
+	}
+
+	// 不支持的地址类型
+	_, err := readAddress(bytes.NewReader([]byte{0x05, 0, 0}))
+	require.ErrorIs(t, err, errAddressType)
+}
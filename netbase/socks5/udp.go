@@ -0,0 +1,179 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// udp数据包的最大长度
+	maxUDPPacketSize = 65535
+	// 单个关联记录的目标地址上限,超过后不再转发到新的目标
+	maxAssociationPeers = 4096
+)
+
+// associate 处理UDP ASSOCIATE,在控制连接的本地IP上监听udp端口,控制连接关闭时结束关联
+// 只接受控制连接远端IP发送的数据包;请求中的端口不为0时还要求端口一致
+func (s *Server) associate(ctx context.Context, conn utils.ConnReadWriteCloser, user *userCounters, dest address) error {
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	remote, ok2 := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !ok2 {
+		_ = writeReply(conn, replyCommandNotSupported, address{})
+		return errors.Errorf("UDP ASSOCIATE只支持tcp连接")
+	}
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		_ = writeReply(conn, replyGeneralFailure, address{})
+		return errors.Wrapf(err, "无法监听udp地址 [ip=%v]", local.IP)
+	}
+	defer pc.Close()
+	if err = writeReply(conn, replySucceeded, fromNetAddr(pc.LocalAddr())); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// 控制连接关闭或服务关闭时结束关联
+	stop := context.AfterFunc(ctx, func() {
+		_ = pc.Close()
+	})
+	defer stop()
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		_ = pc.Close()
+	}()
+
+	a := &association{
+		server:     s,
+		conn:       pc,
+		user:       user,
+		clientIP:   remote.IP,
+		clientPort: dest.port,
+		ipv4:       local.IP.To4() != nil,
+		peers:      make(map[string]struct{}),
+		resolved:   make(map[string]net.IP),
+	}
+	a.serve(ctx)
+	return nil
+}
+
+// association 一个UDP关联,在单个goroutine中收发
+type association struct {
+	server *Server
+	conn   *net.UDPConn
+	user   *userCounters
+
+	clientIP   net.IP
+	clientPort int
+	// 客户端地址,收到第一个数据包后确定
+	client *net.UDPAddr
+	// 只能发送到IPv4地址
+	ipv4 bool
+	// 发送过数据的目标,只转发这些地址的响应
+	peers map[string]struct{}
+	// 目标地址的解析结果,被拒绝的目标为nil
+	resolved map[string]net.IP
+}
+
+func (a *association) serve(ctx context.Context) {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if a.fromClient(from) {
+			a.forward(ctx, buf[:n], from)
+			continue
+		}
+		if _, ok := a.peers[from.String()]; ok && a.client != nil {
+			a.reply(buf[:n], from)
+		}
+	}
+}
+
+func (a *association) fromClient(from *net.UDPAddr) bool {
+	if !from.IP.Equal(a.clientIP) {
+		return false
+	}
+	if a.client != nil {
+		return from.Port == a.client.Port
+	}
+	return a.clientPort == 0 || from.Port == a.clientPort
+}
+
+// forward 转发客户端的数据包: | 保留 2 | 分片 1 | 地址类型 | 地址 | 端口 | 数据 |
+// 不支持分片,分片的数据包被丢弃
+func (a *association) forward(ctx context.Context, packet []byte, from *net.UDPAddr) {
+	if len(packet) < 3 || packet[2] != 0 {
+		return
+	}
+	r := bytes.NewReader(packet[3:])
+	dest, err := readAddress(r)
+	if err != nil {
+		return
+	}
+	payload := packet[len(packet)-r.Len():]
+
+	ip := a.resolve(ctx, dest)
+	if ip == nil {
+		return
+	}
+	target := &net.UDPAddr{IP: ip, Port: dest.port}
+	key := target.String()
+	if _, ok := a.peers[key]; !ok {
+		if len(a.peers) >= maxAssociationPeers {
+			return
+		}
+		a.peers[key] = struct{}{}
+	}
+	if a.client == nil {
+		a.client = from
+	}
+	if n, err := a.conn.WriteToUDP(payload, target); err == nil {
+		a.user.bytesUp.Add(int64(n))
+	}
+}
+
+// resolve 返回规则允许且与监听地址族相同的第一个IP,结果在关联内缓存
+// 解析在收发的goroutine中进行,超时时间与握手相同,避免一个无法解析的主机名阻塞整个关联;
+// 解析失败的结果不缓存
+func (a *association) resolve(ctx context.Context, dest address) net.IP {
+	key := dest.String()
+	if ip, ok := a.resolved[key]; ok {
+		return ip
+	}
+	var chosen net.IP
+	ctx, cancel := context.WithTimeout(ctx, a.server.opts.handshakeTimeout)
+	defer cancel()
+	ips, err := a.server.resolve(ctx, dest)
+	if err != nil {
+		a.server.opts.reportError(errors.Wrapf(err, "无法转发udp数据包 [client=%v, dest=%v]", a.clientIP, dest))
+		if !errors.Is(err, errDenied) {
+			return nil
+		}
+	}
+	for _, ip := range ips {
+		if !a.ipv4 || ip.To4() != nil {
+			chosen = ip
+			break
+		}
+	}
+	if len(a.resolved) < maxAssociationPeers {
+		a.resolved[key] = chosen
+	}
+	return chosen
+}
+
+// reply 把目标的响应加上来源地址发给客户端
+func (a *association) reply(payload []byte, from *net.UDPAddr) {
+	packet := appendAddress(make([]byte, 3, 3+1+net.IPv6len+2+len(payload)), fromNetAddr(from))
+	packet = append(packet, payload...)
+	if _, err := a.conn.WriteToUDP(packet, a.client); err == nil {
+		a.user.bytesDown.Add(int64(len(payload)))
+	}
+}
@@ -0,0 +1,188 @@
+package stream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+const (
+	// 预共享密钥最小长度
+	minPSKSize = 16
+	// 每帧最大明文长度
+	aeadMaxPlaintext = 16 * 1024
+	// 帧头: 密文长度(2)
+	aeadHeaderSize = 2
+)
+
+// ErrAuthFailed 帧认证失败,数据被篡改或密钥不一致
+var ErrAuthFailed = errors.New("stream frame authentication failed")
+
+type aeadLayer struct {
+	psk []byte
+}
+
+// AEAD 基于预共享密钥的AES-256-GCM加密层
+// 每个方向使用由密钥和握手随机数派生的独立密钥,帧序号作为nonce,
+// 关闭写方向时发送经过认证的结束帧,对端在收到结束帧前读到EOF返回io.ErrUnexpectedEOF
+func AEAD(psk []byte) Layer {
+	return &aeadLayer{psk: append([]byte(nil), psk...)}
+}
+
+func (l *aeadLayer) Name() string {
+	return "aead-aes256gcm"
+}
+
+func (l *aeadLayer) Wrap(conn utils.ConnReadWriteCloser, session *Session) (utils.ConnReadWriteCloser, error) {
+	if len(l.psk) < minPSKSize {
+		return nil, errors.Errorf("预共享密钥长度不能小于%v字节", minPSKSize)
+	}
+	clientWrite, err := l.newAEAD("client write", session)
+	if err != nil {
+		return nil, err
+	}
+	serverWrite, err := l.newAEAD("server write", session)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &aeadConn{ConnReadWriteCloser: conn, sealer: clientWrite, opener: serverWrite}
+	if !session.IsClient {
+		c.sealer, c.opener = serverWrite, clientWrite
+	}
+	return c, nil
+}
+
+// newAEAD 派生方向密钥: HMAC-SHA256(psk, label | 客户端随机数 | 服务端随机数)
+func (l *aeadLayer) newAEAD(label string, session *Session) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, l.psk)
+	mac.Write([]byte(label))
+	mac.Write(session.ClientRandom[:])
+	mac.Write(session.ServerRandom[:])
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// aeadConn 加密连接: | 密文长度 2 | 密文 |,明文为空的帧表示结束
+type aeadConn struct {
+	utils.ConnReadWriteCloser
+
+	// 加密和写入在锁内进行,并发写入时nonce不会重复
+	writeMu   sync.Mutex
+	sealer    cipher.AEAD
+	sealNonce uint64
+	opener    cipher.AEAD
+	openNonce uint64
+
+	header [aeadHeaderSize]byte
+	// 已解密未读取的数据
+	plain []byte
+	eof   bool
+}
+
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
+
+func (c *aeadConn) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+func (c *aeadConn) readFrame() error {
+	if _, err := io.ReadFull(c.ConnReadWriteCloser, c.header[:]); err != nil {
+		// 没有收到结束帧,连接可能被截断
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	length := int(binary.BigEndian.Uint16(c.header[:]))
+	if length < c.opener.Overhead() {
+		return ErrAuthFailed
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(c.ConnReadWriteCloser, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plain, err := c.opener.Open(sealed[:0], nonce(c.opener, c.openNonce), sealed, c.header[:])
+	if err != nil {
+		return ErrAuthFailed
+	}
+	c.openNonce++
+	if len(plain) == 0 {
+		c.eof = true
+	}
+	c.plain = plain
+	return nil
+}
+
+func (c *aeadConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > aeadMaxPlaintext {
+			n = aeadMaxPlaintext
+		}
+		if err := c.writeFrame(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *aeadConn) writeFrame(plain []byte) error {
+	frame := make([]byte, aeadHeaderSize, aeadHeaderSize+len(plain)+c.sealer.Overhead())
+	binary.BigEndian.PutUint16(frame, uint16(len(plain)+c.sealer.Overhead()))
+	frame = c.sealer.Seal(frame, nonce(c.sealer, c.sealNonce), plain, frame[:aeadHeaderSize])
+	c.sealNonce++
+	_, err := c.ConnReadWriteCloser.Write(frame)
+	return err
+}
+
+func (c *aeadConn) Flush() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return flush(c.ConnReadWriteCloser)
+}
+
+// CloseWrite 发送结束帧并关闭下层的写方向
+func (c *aeadConn) CloseWrite() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.writeFrame(nil); err != nil {
+		return err
+	}
+	if err := flush(c.ConnReadWriteCloser); err != nil {
+		return err
+	}
+	return c.ConnReadWriteCloser.CloseWrite()
+}
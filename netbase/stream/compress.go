@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"github.com/lngwu11/toolgo/utils"
+	"io"
+	"sync"
+)
+
+// compressWriter 支持同步刷新的压缩写入
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type compressLayer struct {
+	name      string
+	newWriter func(w io.Writer) (compressWriter, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (l *compressLayer) Name() string {
+	return l.name
+}
+
+func (l *compressLayer) Wrap(conn utils.ConnReadWriteCloser, _ *Session) (utils.ConnReadWriteCloser, error) {
+	writer, err := l.newWriter(conn)
+	if err != nil {
+		return nil, err
+	}
+	return &compressConn{
+		ConnReadWriteCloser: conn,
+		writer:              writer,
+		newReader:           l.newReader,
+	}, nil
+}
+
+// Gzip gzip压缩层,level为compress/gzip的压缩级别
+func Gzip(level int) Layer {
+	return &compressLayer{
+		name: "gzip",
+		newWriter: func(w io.Writer) (compressWriter, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+}
+
+// Deflate deflate压缩层,level为compress/flate的压缩级别
+func Deflate(level int) Layer {
+	return &compressLayer{
+		name: "deflate",
+		newWriter: func(w io.Writer) (compressWriter, error) {
+			return flate.NewWriter(w, level)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	}
+}
+
+// compressConn 流式压缩连接
+// Write写入压缩器的缓存,Flush同步刷新压缩器,对端可以解压出Flush之前写入的全部数据
+type compressConn struct {
+	utils.ConnReadWriteCloser
+	// 压缩器不支持并发写入
+	writeMu   sync.Mutex
+	writer    compressWriter
+	newReader func(r io.Reader) (io.ReadCloser, error)
+
+	// 解压器在第一次读取时创建,gzip需要读取头部
+	readerOnce sync.Once
+	reader     io.ReadCloser
+	readerErr  error
+}
+
+func (c *compressConn) Read(p []byte) (int, error) {
+	c.readerOnce.Do(func() {
+		c.reader, c.readerErr = c.newReader(c.ConnReadWriteCloser)
+	})
+	if c.readerErr != nil {
+		return 0, c.readerErr
+	}
+	return c.reader.Read(p)
+}
+
+func (c *compressConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writer.Write(p)
+}
+
+func (c *compressConn) Flush() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	return flush(c.ConnReadWriteCloser)
+}
+
+// CloseWrite 结束压缩流并关闭下层的写方向
+func (c *compressConn) CloseWrite() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.writer.Close(); err != nil {
+		return err
+	}
+	if err := flush(c.ConnReadWriteCloser); err != nil {
+		return err
+	}
+	return c.ConnReadWriteCloser.CloseWrite()
+}
@@ -0,0 +1,185 @@
+package stream
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+const (
+	// 每帧最大的原始数据长度
+	framedBlockSize = 64 * 1024
+	// 帧头: 标志(1) + 数据长度(4)
+	framedHeaderSize = 5
+)
+
+// 帧标志
+const (
+	frameRaw byte = iota
+	frameDeflate
+)
+
+var errInvalidFrame = errors.New("invalid compressed frame")
+
+type framedLayer struct {
+	level int
+}
+
+// Framed 分帧压缩层,每帧独立压缩,压缩后不变小的帧原样发送
+// 与Deflate相比压缩率较低,但每帧可以独立解码,适合已压缩数据较多的场景
+func Framed(level int) Layer {
+	return &framedLayer{level: level}
+}
+
+func (l *framedLayer) Name() string {
+	return "framed"
+}
+
+func (l *framedLayer) Wrap(conn utils.ConnReadWriteCloser, _ *Session) (utils.ConnReadWriteCloser, error) {
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, l.level)
+	if err != nil {
+		return nil, err
+	}
+	return &framedConn{
+		ConnReadWriteCloser: conn,
+		writer:              writer,
+		compressed:          &compressed,
+		pending:             make([]byte, 0, framedBlockSize),
+	}, nil
+}
+
+// framedConn 分帧压缩连接: | 标志 1 | 长度 4 | 数据 |
+type framedConn struct {
+	utils.ConnReadWriteCloser
+
+	// 压缩和写入在锁内进行,并发写入时帧不会交错
+	writeMu    sync.Mutex
+	writer     *flate.Writer
+	compressed *bytes.Buffer
+	// 等待压缩的数据
+	pending []byte
+
+	header [framedHeaderSize]byte
+	// 已解压未读取的数据
+	plain []byte
+}
+
+func (c *framedConn) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+func (c *framedConn) readFrame() error {
+	if _, err := io.ReadFull(c.ConnReadWriteCloser, c.header[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(c.header[1:])
+	// deflate最坏情况下略大于原始数据
+	if length > framedBlockSize+framedBlockSize/8 {
+		return errInvalidFrame
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.ConnReadWriteCloser, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	switch c.header[0] {
+	case frameRaw:
+		c.plain = data
+	case frameDeflate:
+		plain, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), framedBlockSize+1))
+		if err != nil || len(plain) > framedBlockSize {
+			return errInvalidFrame
+		}
+		c.plain = plain
+	default:
+		return errInvalidFrame
+	}
+	return nil
+}
+
+func (c *framedConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		n := copy(c.pending[len(c.pending):cap(c.pending)], p)
+		c.pending = c.pending[:len(c.pending)+n]
+		p = p[n:]
+		written += n
+		if len(c.pending) == cap(c.pending) {
+			if err := c.writeFrame(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeFrame 压缩并发送缓存的数据
+func (c *framedConn) writeFrame() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	defer func() {
+		c.pending = c.pending[:0]
+	}()
+
+	c.compressed.Reset()
+	c.writer.Reset(c.compressed)
+	if _, err := c.writer.Write(c.pending); err != nil {
+		return err
+	}
+	if err := c.writer.Close(); err != nil {
+		return err
+	}
+
+	flag, data := frameDeflate, c.compressed.Bytes()
+	if len(data) >= len(c.pending) {
+		flag, data = frameRaw, c.pending
+	}
+	var header [framedHeaderSize]byte
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := c.ConnReadWriteCloser.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := c.ConnReadWriteCloser.Write(data)
+	return err
+}
+
+func (c *framedConn) Flush() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.flush()
+}
+
+func (c *framedConn) flush() error {
+	if err := c.writeFrame(); err != nil {
+		return err
+	}
+	return flush(c.ConnReadWriteCloser)
+}
+
+func (c *framedConn) CloseWrite() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.flush(); err != nil {
+		return err
+	}
+	return c.ConnReadWriteCloser.CloseWrite()
+}
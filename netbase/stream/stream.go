@@ -0,0 +1,261 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/pkg/errors"
+	"io"
+	"time"
+)
+
+const (
+	// 默认握手超时时间
+	defaultHandshakeTimeout = 10 * time.Second
+	// 随机数长度
+	randomSize = 32
+)
+
+var (
+	// 握手消息前缀,包含协议版本
+	handshakeMagic = []byte("NBS1")
+	// 各层建立后双方通过完整的层栈交换的确认消息
+	confirmMessage = []byte("NBSTREAM")
+)
+
+// 握手响应状态
+const (
+	statusOK byte = iota
+	statusMismatch
+)
+
+var (
+	// ErrStackMismatch 双方配置的层不一致
+	ErrStackMismatch = errors.New("stream layers mismatch")
+	// ErrHandshakeFailed 层建立后确认消息校验失败,如预共享密钥不一致
+	ErrHandshakeFailed = errors.New("stream handshake failed")
+)
+
+// Layer 包装连接的流转换层,如压缩、加密
+// 包装后的连接实现utils.Flusher,Flush把本层缓存的数据处理后写入下层并继续Flush下层
+type Layer interface {
+	// Name 层的名称,握手时双方按名称校验层栈是否一致
+	Name() string
+	// Wrap 包装连接,session为本次握手的信息
+	Wrap(conn utils.ConnReadWriteCloser, session *Session) (utils.ConnReadWriteCloser, error)
+}
+
+// Session 握手信息
+type Session struct {
+	// 是否为发起握手的一端
+	IsClient bool
+	// 双方生成的随机数
+	ClientRandom [randomSize]byte
+	ServerRandom [randomSize]byte
+}
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 握手超时时间
+	handshakeTimeout time.Duration
+}
+
+// WithHandshakeTimeout 设置握手超时时间,默认10s
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.handshakeTimeout = timeout
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.handshakeTimeout <= 0 {
+		opts.handshakeTimeout = defaultHandshakeTimeout
+	}
+	return opts
+}
+
+// Client 作为发起方握手并按顺序包装连接
+// layers从应用侧到网络侧排列,如Client(conn, []Layer{Deflate(-1), AEAD(psk)})表示先压缩后加密。
+// 握手失败时由调用者关闭连接
+func Client(conn utils.ConnReadWriteCloser, layers []Layer, options ...Option) (utils.ConnReadWriteCloser, error) {
+	opts := loadOptions(options...)
+	if err := checkLayers(layers); err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(opts.handshakeTimeout))
+
+	session := &Session{IsClient: true}
+	if _, err := rand.Read(session.ClientRandom[:]); err != nil {
+		return nil, err
+	}
+
+	// 请求: magic | 随机数 | 层数 | (名称长度 | 名称)...
+	req := append([]byte(nil), handshakeMagic...)
+	req = append(req, session.ClientRandom[:]...)
+	req = append(req, byte(len(layers)))
+	for _, layer := range layers {
+		req = append(req, byte(len(layer.Name())))
+		req = append(req, layer.Name()...)
+	}
+	if err := writeAndFlush(conn, req); err != nil {
+		return nil, errors.Wrapf(err, "无法发送握手请求")
+	}
+
+	// 响应: magic | 状态 | 随机数
+	resp := make([]byte, len(handshakeMagic)+1+randomSize)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, errors.Wrapf(err, "无法读取握手响应")
+	}
+	if !bytes.Equal(resp[:len(handshakeMagic)], handshakeMagic) {
+		return nil, ErrHandshakeFailed
+	}
+	if resp[len(handshakeMagic)] != statusOK {
+		return nil, ErrStackMismatch
+	}
+	copy(session.ServerRandom[:], resp[len(handshakeMagic)+1:])
+
+	wrapped, err := wrap(conn, layers, session)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeAndFlush(wrapped, confirmMessage); err != nil {
+		return nil, errors.Wrapf(err, "无法发送握手确认")
+	}
+	if err = readConfirm(wrapped); err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return wrapped, nil
+}
+
+// Server 作为接收方握手,发起方的层栈与layers不一致时返回ErrStackMismatch
+func Server(conn utils.ConnReadWriteCloser, layers []Layer, options ...Option) (utils.ConnReadWriteCloser, error) {
+	opts := loadOptions(options...)
+	if err := checkLayers(layers); err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(opts.handshakeTimeout))
+
+	session := &Session{}
+	head := make([]byte, len(handshakeMagic)+randomSize+1)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, errors.Wrapf(err, "无法读取握手请求")
+	}
+	if !bytes.Equal(head[:len(handshakeMagic)], handshakeMagic) {
+		return nil, ErrHandshakeFailed
+	}
+	copy(session.ClientRandom[:], head[len(handshakeMagic):])
+
+	count := int(head[len(head)-1])
+	names := make([]string, count)
+	for i := range names {
+		var size [1]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return nil, errors.Wrapf(err, "无法读取握手请求")
+		}
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, errors.Wrapf(err, "无法读取握手请求")
+		}
+		names[i] = string(name)
+	}
+
+	status := statusOK
+	if !sameStack(names, layers) {
+		status = statusMismatch
+	}
+	if _, err := rand.Read(session.ServerRandom[:]); err != nil {
+		return nil, err
+	}
+	resp := append([]byte(nil), handshakeMagic...)
+	resp = append(resp, status)
+	resp = append(resp, session.ServerRandom[:]...)
+	if err := writeAndFlush(conn, resp); err != nil {
+		return nil, errors.Wrapf(err, "无法发送握手响应")
+	}
+	if status != statusOK {
+		return nil, errors.Wrapf(ErrStackMismatch, "对端的层 [layers=%v]", names)
+	}
+
+	wrapped, err := wrap(conn, layers, session)
+	if err != nil {
+		return nil, err
+	}
+	if err = readConfirm(wrapped); err != nil {
+		return nil, err
+	}
+	if err = writeAndFlush(wrapped, confirmMessage); err != nil {
+		return nil, errors.Wrapf(err, "无法发送握手确认")
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return wrapped, nil
+}
+
+func checkLayers(layers []Layer) error {
+	if len(layers) > 255 {
+		return errors.Errorf("层数过多 [count=%v]", len(layers))
+	}
+	for _, layer := range layers {
+		if n := len(layer.Name()); n == 0 || n > 255 {
+			return errors.Errorf("无效的层名称 [name=%v]", layer.Name())
+		}
+	}
+	return nil
+}
+
+func sameStack(names []string, layers []Layer) bool {
+	if len(names) != len(layers) {
+		return false
+	}
+	for i, layer := range layers {
+		if names[i] != layer.Name() {
+			return false
+		}
+	}
+	return true
+}
+
+// wrap 从网络侧开始逐层包装
+func wrap(conn utils.ConnReadWriteCloser, layers []Layer, session *Session) (utils.ConnReadWriteCloser, error) {
+	for i := len(layers) - 1; i >= 0; i-- {
+		wrapped, err := layers[i].Wrap(conn, session)
+		if err != nil {
+			return nil, errors.Wrapf(err, "无法建立层 [name=%v]", layers[i].Name())
+		}
+		conn = wrapped
+	}
+	return conn, nil
+}
+
+func readConfirm(conn utils.ConnReadWriteCloser) error {
+	confirm := make([]byte, len(confirmMessage))
+	if _, err := io.ReadFull(conn, confirm); err != nil {
+		return errors.Wrapf(ErrHandshakeFailed, "%v", err)
+	}
+	if !bytes.Equal(confirm, confirmMessage) {
+		return ErrHandshakeFailed
+	}
+	return nil
+}
+
+func writeAndFlush(conn io.Writer, b []byte) error {
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+	return flush(conn)
+}
+
+// flush 连接实现utils.Flusher时发送缓存的数据
+func flush(conn interface{}) error {
+	if f, ok := conn.(utils.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
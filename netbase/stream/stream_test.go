@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/lngwu11/toolgo/netbase/internal/nettest"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+type handshakeResult struct {
+	conn utils.ConnReadWriteCloser
+	err  error
+}
+
+func handshake(t *testing.T, clientLayers, serverLayers []Layer) (client, server handshakeResult) {
+	a, b := nettest.TCPPair(t)
+	done := make(chan handshakeResult, 1)
+	go func() {
+		conn, err := Server(b, serverLayers)
+		if err != nil {
+			_ = b.Close()
+		}
+		done <- handshakeResult{conn, err}
+	}()
+	conn, err := Client(a, clientLayers)
+	return handshakeResult{conn, err}, <-done
+}
+
+func TestStacks(t *testing.T) {
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	stacks := map[string]func() []Layer{
+		"none":         func() []Layer { return nil },
+		"gzip":         func() []Layer { return []Layer{Gzip(flate.DefaultCompression)} },
+		"deflate":      func() []Layer { return []Layer{Deflate(flate.BestSpeed)} },
+		"framed":       func() []Layer { return []Layer{Framed(flate.DefaultCompression)} },
+		"aead":         func() []Layer { return []Layer{AEAD(psk)} },
+		"deflate+aead": func() []Layer { return []Layer{Deflate(flate.DefaultCompression), AEAD(psk)} },
+		"framed+aead":  func() []Layer { return []Layer{Framed(flate.DefaultCompression), AEAD(psk)} },
+	}
+
+	random := make([]byte, 200*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	payload := append(bytes.Repeat([]byte("compressible "), 10000), random...)
+
+	for name, stack := range stacks {
+		t.Run(name, func(t *testing.T) {
+			client, server := handshake(t, stack(), stack())
+			require.NoError(t, client.err)
+			require.NoError(t, server.err)
+
+			// Flush后对端可以读到全部数据
+			_, err := client.conn.Write([]byte("ping"))
+			require.NoError(t, err)
+			require.NoError(t, flush(client.conn))
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(server.conn, buf)
+			require.NoError(t, err)
+			require.Equal(t, "ping", string(buf))
+
+			// 半关闭后对端读到EOF并仍可以回复
+			_, err = client.conn.Write(payload)
+			require.NoError(t, err)
+			require.NoError(t, client.conn.CloseWrite())
+			got, err := io.ReadAll(server.conn)
+			require.NoError(t, err)
+			require.Equal(t, payload, got)
+
+			_, err = server.conn.Write([]byte("pong"))
+			require.NoError(t, err)
+			require.NoError(t, server.conn.CloseWrite())
+			got, err = io.ReadAll(client.conn)
+			require.NoError(t, err)
+			require.Equal(t, "pong", string(got))
+		})
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	stack := func() []Layer { return []Layer{Framed(flate.BestSpeed), AEAD(psk)} }
+	client, server := handshake(t, stack(), stack())
+	require.NoError(t, client.err)
+	require.NoError(t, server.err)
+
+	// 并发写入的数据完整到达,nonce不会重复
+	const writers, count = 8, 200
+	chunk := bytes.Repeat([]byte("x"), 1000)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				_, _ = client.conn.Write(chunk)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		_ = client.conn.CloseWrite()
+	}()
+	got, err := io.ReadAll(server.conn)
+	require.NoError(t, err)
+	require.Equal(t, writers*count*len(chunk), len(got))
+}
+
+func TestHandshakeFailures(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+
+	client, server := handshake(t, []Layer{Gzip(-1)}, []Layer{Deflate(-1)})
+	require.ErrorIs(t, client.err, ErrStackMismatch)
+	require.ErrorIs(t, server.err, ErrStackMismatch)
+
+	client, server = handshake(t, []Layer{AEAD(psk)}, []Layer{AEAD([]byte("fedcba9876543210"))})
+	require.ErrorIs(t, server.err, ErrHandshakeFailed)
+	require.Error(t, client.err)
+
+	client, _ = handshake(t, []Layer{AEAD([]byte("short"))}, []Layer{AEAD([]byte("short"))})
+	require.Error(t, client.err)
+}
+
+func TestAEADTamper(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	a, b := nettest.TCPPair(t)
+	session := &Session{IsClient: true}
+	writer, err := AEAD(psk).Wrap(&tamperConn{ConnReadWriteCloser: a}, session)
+	require.NoError(t, err)
+	reader, err := AEAD(psk).Wrap(b, &Session{})
+	require.NoError(t, err)
+
+	_, err = writer.Write([]byte("secret"))
+	require.NoError(t, err)
+	_, err = reader.Read(make([]byte, 16))
+	require.ErrorIs(t, err, ErrAuthFailed)
+}
+
+// tamperConn 修改写入数据的最后一个字节
+type tamperConn struct {
+	utils.ConnReadWriteCloser
+}
+
+func (c *tamperConn) Write(p []byte) (int, error) {
+	b := append([]byte(nil), p...)
+	b[len(b)-1] ^= 0xff
+	return c.ConnReadWriteCloser.Write(b)
+}
@@ -0,0 +1,133 @@
+package throttle
+
+import (
+	"context"
+	"github.com/lngwu11/toolgo/netbase/server"
+	"github.com/lngwu11/toolgo/utils"
+	"sync"
+)
+
+type Option func(opts *Options)
+
+type Options struct {
+	readLimiters  []*Limiter
+	writeLimiters []*Limiter
+}
+
+// WithReadLimiter 添加读方向的限制器,多个限制器同时生效,如单连接限速和分组限速
+func WithReadLimiter(limiters ...*Limiter) Option {
+	return func(opts *Options) {
+		opts.readLimiters = append(opts.readLimiters, limiters...)
+	}
+}
+
+// WithWriteLimiter 添加写方向的限制器,多个限制器同时生效
+func WithWriteLimiter(limiters ...*Limiter) Option {
+	return func(opts *Options) {
+		opts.writeLimiters = append(opts.writeLimiters, limiters...)
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// Wrap 包装连接,读写按限制器的速率进行
+// 读取在数据到达后等待令牌,写入在发送前等待令牌,每次最多处理一个令牌桶容量的数据;
+// 等待令牌时不受读写截止时间影响,连接关闭时返回net.ErrClosed
+func Wrap(conn utils.ConnReadWriteCloser, options ...Option) utils.ConnReadWriteCloser {
+	opts := loadOptions(options...)
+	return &throttledConn{
+		ConnReadWriteCloser: conn,
+		readLimiters:        opts.readLimiters,
+		writeLimiters:       opts.writeLimiters,
+		closed:              make(chan struct{}),
+	}
+}
+
+// Handler 包装server.Handler,连接按限制器的速率读写,handler返回时关闭连接
+// 在所有连接间共享限制器即可限制整个监听的带宽
+func Handler(handler server.Handler, options ...Option) server.Handler {
+	return func(ctx context.Context, conn utils.ConnReadWriteCloser) {
+		throttled := Wrap(conn, options...)
+		defer func() {
+			_ = throttled.Close()
+		}()
+		handler(ctx, throttled)
+	}
+}
+
+type throttledConn struct {
+	utils.ConnReadWriteCloser
+	readLimiters  []*Limiter
+	writeLimiters []*Limiter
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if size := chunkSize(c.readLimiters, len(p)); size < len(p) {
+		p = p[:size]
+	}
+	n, err := c.ConnReadWriteCloser.Read(p)
+	if n > 0 {
+		if waitErr := c.wait(c.readLimiters, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := chunkSize(c.writeLimiters, len(p))
+		if err := c.wait(c.writeLimiters, size); err != nil {
+			return written, err
+		}
+		n, err := c.ConnReadWriteCloser.Write(p[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[size:]
+	}
+	return written, nil
+}
+
+func (c *throttledConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.ConnReadWriteCloser.Close()
+}
+
+// wait 从每个限制器取出n个令牌并等待最长的时间
+func (c *throttledConn) wait(limiters []*Limiter, n int) error {
+	reservations := make([]*reservation, 0, len(limiters))
+	for _, l := range limiters {
+		reservations = append(reservations, l.reserve(int64(n)))
+	}
+	// 令牌同时取出,依次等待的总时间即最长的等待时间
+	for _, r := range reservations {
+		if err := r.wait(c.closed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkSize 单次读写的数据量不超过最小的令牌桶容量
+func chunkSize(limiters []*Limiter, n int) int {
+	for _, l := range limiters {
+		if burst := l.burst(); burst > 0 && int64(n) > burst {
+			n = int(burst)
+		}
+	}
+	return n
+}
@@ -0,0 +1,134 @@
+package throttle
+
+import (
+	"github.com/juju/ratelimit"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认突发量不小于4KB
+	minBurst = 4 * 1024
+)
+
+// Limiter 字节速率限制器,可以被多个连接共享,如同一个监听或租户的所有连接
+// 速率可以在运行时调整,调整后替换令牌桶,剩余的令牌和等待中的读写按新速率继续
+type Limiter struct {
+	mu     sync.Mutex
+	bucket *ratelimit.Bucket
+	// 调整速率时关闭,唤醒等待中的读写重新计算等待时间
+	changed chan struct{}
+}
+
+// NewLimiter 创建速率为rate字节/秒的限制器,burst为令牌桶容量,
+// burst<=0时为速率的1/10且不小于4KB,rate<=0表示不限速
+func NewLimiter(rate float64, burst int64) *Limiter {
+	l := &Limiter{changed: make(chan struct{})}
+	l.SetRate(rate, burst)
+	return l
+}
+
+// SetRate 调整速率和令牌桶容量,参数含义与NewLimiter相同
+// 新的令牌桶保留原有的剩余令牌(不超过新的容量),等待中的读写欠下的令牌也一并转移
+func (l *Limiter) SetRate(rate float64, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var bucket *ratelimit.Bucket
+	if rate > 0 {
+		if burst <= 0 {
+			burst = int64(rate / 10)
+			if burst < minBurst {
+				burst = minBurst
+			}
+		}
+		bucket = ratelimit.NewBucketWithRate(rate, burst)
+		if l.bucket != nil {
+			if available := l.bucket.Available(); available < burst {
+				bucket.Take(burst - available)
+			}
+		}
+	}
+	l.bucket = bucket
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Rate 返回当前速率,不限速时返回0
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.bucket != nil {
+		return l.bucket.Rate()
+	}
+	return 0
+}
+
+// burst 返回令牌桶容量,不限速时返回0
+func (l *Limiter) burst() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.bucket != nil {
+		return l.bucket.Capacity()
+	}
+	return 0
+}
+
+// reserve 取出n个令牌,返回需要等待到的时间
+func (l *Limiter) reserve(n int64) *reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := &reservation{limiter: l, changed: l.changed}
+	if l.bucket != nil {
+		r.rate = l.bucket.Rate()
+		r.deadline = time.Now().Add(l.bucket.Take(n))
+	}
+	return r
+}
+
+// reservation 已取出的令牌,等待期间速率调整时按新速率重新计算剩余的等待时间
+type reservation struct {
+	limiter  *Limiter
+	rate     float64
+	deadline time.Time
+	changed  chan struct{}
+}
+
+// wait 等待到可以使用令牌,closed关闭时返回net.ErrClosed
+func (r *reservation) wait(closed <-chan struct{}) error {
+	for {
+		d := time.Until(r.deadline)
+		if d <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+			return nil
+		case <-closed:
+			timer.Stop()
+			return net.ErrClosed
+		case <-r.changed:
+			timer.Stop()
+			r.rescale()
+		}
+	}
+}
+
+// rescale 剩余的等待时间按新旧速率之比缩放,取消限速时不再等待
+func (r *reservation) rescale() {
+	l := r.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.changed = l.changed
+	if l.bucket == nil {
+		r.deadline = time.Time{}
+		return
+	}
+	rate := l.bucket.Rate()
+	if d := time.Until(r.deadline); d > 0 {
+		r.deadline = time.Now().Add(time.Duration(float64(d) * r.rate / rate))
+	}
+	r.rate = rate
+}
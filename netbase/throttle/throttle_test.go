@@ -0,0 +1,113 @@
+package throttle
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lngwu11/toolgo/netbase/internal/nettest"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRate  = 200 * 1024
+	testBurst = 8 * 1024
+	// 扣除突发量后按testRate需要200ms
+	testSize = testBurst + testRate/5
+)
+
+// transfer 从a写入size字节,在b读取,返回耗时
+func transfer(t *testing.T, a, b utils.ConnReadWriteCloser, size int) time.Duration {
+	start := time.Now()
+	go func() {
+		_, _ = a.Write(make([]byte, size))
+	}()
+	_, err := io.ReadFull(b, make([]byte, size))
+	require.NoError(t, err)
+	return time.Since(start)
+}
+
+func TestWriteLimit(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	limiter := NewLimiter(testRate, testBurst)
+	throttled := Wrap(a, WithWriteLimiter(limiter))
+	elapsed := transfer(t, throttled, b, testSize)
+	require.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+
+	// 读方向不受影响
+	require.Less(t, transfer(t, b, throttled, testSize), 150*time.Millisecond)
+
+	// 运行时取消限速
+	limiter.SetRate(0, 0)
+	require.Zero(t, limiter.Rate())
+	require.Less(t, transfer(t, throttled, b, 4*testSize), 150*time.Millisecond)
+}
+
+func TestSetRateKeepsTokens(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	limiter := NewLimiter(testRate, 64*1024)
+	throttled := Wrap(a, WithWriteLimiter(limiter))
+	require.Less(t, transfer(t, throttled, b, 64*1024), 150*time.Millisecond)
+
+	// 令牌已经用完,调整速率不会得到新的突发量
+	limiter.SetRate(testRate, 64*1024)
+	require.GreaterOrEqual(t, transfer(t, throttled, b, 64*1024), 250*time.Millisecond)
+}
+
+func TestSetRateDuringTransfer(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	// 按原速率传输需要5秒
+	limiter := NewLimiter(4*1024, 4*1024)
+	throttled := Wrap(a, WithWriteLimiter(limiter))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		limiter.SetRate(10*testRate, 4*1024)
+	}()
+	// 等待中的写入按新速率继续
+	require.Less(t, transfer(t, throttled, b, 24*1024), time.Second)
+}
+
+func TestReadLimit(t *testing.T) {
+	a, b := nettest.TCPPair(t)
+	throttled := Wrap(b, WithReadLimiter(NewLimiter(testRate, testBurst)))
+	require.GreaterOrEqual(t, transfer(t, a, throttled, testSize), 150*time.Millisecond)
+}
+
+func TestSharedLimiter(t *testing.T) {
+	// 两个连接共享速率,总耗时与单个连接传输两倍数据相同
+	limiter := NewLimiter(testRate, testBurst)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		a, b := nettest.TCPPair(t)
+		throttled := Wrap(a, WithWriteLimiter(limiter))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transfer(t, throttled, b, testSize)
+		}()
+	}
+	wg.Wait()
+	require.GreaterOrEqual(t, time.Since(start), 350*time.Millisecond)
+}
+
+func TestCloseWhileWaiting(t *testing.T) {
+	a, _ := nettest.TCPPair(t)
+	throttled := Wrap(a, WithWriteLimiter(NewLimiter(1024, 1024)))
+	done := make(chan error, 1)
+	go func() {
+		_, err := throttled.Write(make([]byte, 64*1024))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, throttled.Close())
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("write not interrupted")
+	}
+}
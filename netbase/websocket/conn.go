@@ -0,0 +1,311 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 帧类型
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xa
+)
+
+// 关闭状态码
+const (
+	closeNormal      = 1000
+	closeProtocolErr = 1002
+	closeTooLarge    = 1009
+)
+
+const (
+	finBit  = 0x80
+	rsvBits = 0x70
+	maskBit = 0x80
+	// 控制帧的最大长度
+	maxControlPayload = 125
+	// 发送关闭帧的写超时,对端不再读取时不会一直阻塞
+	closeWriteTimeout = time.Second
+)
+
+// Conn WebSocket连接,实现utils.ConnReadWriteCloser
+// 收到的文本和二进制消息按顺序作为字节流读取,每次Write发送一个二进制消息。
+// CloseWrite发送关闭帧,对端读到io.EOF;收到对端的关闭帧后Read返回io.EOF,
+// 本端在CloseWrite或Close时回复关闭帧并带上对端的状态码,以支持半关闭
+type Conn struct {
+	net.Conn
+	reader       *bufio.Reader
+	isClient     bool
+	maxFrameSize int64
+	subprotocol  string
+
+	// 读状态,只在Read中访问
+	remaining int64
+	mask      [4]byte
+	maskPos   int
+	masked    bool
+	readEOF   bool
+	readErr   error
+
+	writeMu     sync.Mutex
+	writeClosed bool
+	readClosed  bool
+	// 收到的关闭帧的状态码,本端关闭时回复
+	peerClose []byte
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isClient bool, opts *Options) *Conn {
+	return &Conn{
+		Conn:         conn,
+		reader:       reader,
+		isClient:     isClient,
+		maxFrameSize: opts.maxFrameSize,
+	}
+}
+
+// Subprotocol 返回协商的子协议
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.writeMu.Lock()
+	readClosed := c.readClosed
+	c.writeMu.Unlock()
+	if readClosed {
+		return 0, io.EOF
+	}
+
+	for c.remaining == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.readEOF {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			c.readErr = err
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	if c.masked {
+		c.maskPos = maskBytes(c.mask, c.maskPos, p[:n])
+	}
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.readErr = err
+	}
+	return n, err
+}
+
+// nextFrame 读取下一个数据帧的帧头,期间处理控制帧
+func (c *Conn) nextFrame() error {
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		fin, opcode := head[0]&finBit != 0, head[0]&0x0f
+		masked := head[1]&maskBit != 0
+		if head[0]&rsvBits != 0 {
+			return c.fail(closeProtocolErr, errors.Wrapf(ErrProtocol, "不支持的扩展位"))
+		}
+		// 客户端发送的帧必须掩码,服务端发送的帧不能掩码
+		if masked == c.isClient {
+			return c.fail(closeProtocolErr, errors.Wrapf(ErrProtocol, "掩码错误 [masked=%v]", masked))
+		}
+
+		length := int64(head[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint64(ext[:]))
+		}
+		if length < 0 || length > c.maxFrameSize {
+			return c.fail(closeTooLarge, errors.Wrapf(ErrFrameTooLarge, "[length=%v]", length))
+		}
+
+		c.masked, c.maskPos = masked, 0
+		if masked {
+			if _, err := io.ReadFull(c.reader, c.mask[:]); err != nil {
+				return err
+			}
+		}
+
+		switch opcode {
+		case opContinuation, opText, opBinary:
+			c.remaining = length
+			if length > 0 {
+				return nil
+			}
+			continue
+		case opClose, opPing, opPong:
+		default:
+			return c.fail(closeProtocolErr, errors.Wrapf(ErrProtocol, "未知的帧类型 [opcode=%v]", opcode))
+		}
+
+		if !fin || length > maxControlPayload {
+			return c.fail(closeProtocolErr, errors.Wrapf(ErrProtocol, "无效的控制帧 [opcode=%v]", opcode))
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return err
+		}
+		if masked {
+			maskBytes(c.mask, 0, payload)
+		}
+
+		switch opcode {
+		case opPing:
+			c.writeMu.Lock()
+			if !c.writeClosed {
+				_ = c.writeFrame(opPong, payload)
+			}
+			c.writeMu.Unlock()
+		case opClose:
+			c.writeMu.Lock()
+			c.peerClose = []byte{}
+			if len(payload) >= 2 {
+				c.peerClose = payload[:2]
+			}
+			c.writeMu.Unlock()
+			c.readEOF = true
+			return io.EOF
+		}
+	}
+}
+
+// fail 发送关闭帧后返回err
+func (c *Conn) fail(code int, err error) error {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(closeWriteTimeout))
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !c.writeClosed {
+		c.writeClosed = true
+		_ = c.writeFrame(opClose, closePayload(code))
+	}
+	return err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeClosed {
+		return 0, ErrWriteClosed
+	}
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame 发送一个完整的帧,调用者持有c.writeMu
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, finBit|opcode)
+
+	var maskFlag byte
+	if c.isClient {
+		maskFlag = maskBit
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskFlag|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskFlag|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskFlag|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if c.isClient {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(mask, 0, frame[start:])
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// CloseWrite 发送关闭帧,之后不能继续写,仍然可以读到对端的关闭帧为止
+// 已收到对端的关闭帧时回复相同的状态码;发送设置了短暂的写超时,对端不读取时不会一直阻塞
+func (c *Conn) CloseWrite() error {
+	// 先设置超时,唤醒阻塞在写入中的Write
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(closeWriteTimeout))
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeClosed {
+		return nil
+	}
+	c.writeClosed = true
+	payload := closePayload(closeNormal)
+	if c.peerClose != nil {
+		payload = c.peerClose
+	}
+	return c.writeFrame(opClose, payload)
+}
+
+// CloseRead 之后的Read返回io.EOF,对端仍然可以写直到本端关闭连接
+func (c *Conn) CloseRead() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.readClosed = true
+	return nil
+}
+
+// Close 发送关闭帧并关闭连接
+func (c *Conn) Close() error {
+	_ = c.CloseWrite()
+	return c.Conn.Close()
+}
+
+func closePayload(code int) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(code))
+}
+
+// maskBytes 对b做掩码运算,返回下一个掩码位置
+func maskBytes(mask [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= mask[pos&3]
+		pos++
+	}
+	return pos & 3
+}
@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Dial 连接ws或wss地址并完成握手
+func Dial(rawURL string, options ...Option) (*Conn, error) {
+	opts := loadOptions(options...)
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "无效的地址 [url=%v]", rawURL)
+	}
+	clientOptions := []client.Option{client.WithDialTimeout(opts.handshakeTimeout)}
+	defaultPort := "80"
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		defaultPort = "443"
+		clientOptions = append(clientOptions, client.WithTLSConfig(&tls.Config{}))
+	default:
+		return nil, errors.Errorf("不支持的协议 [url=%v]", rawURL)
+	}
+	endpoint := u.Host
+	if u.Port() == "" {
+		endpoint = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	netConn, err := client.NewTCPConnection(endpoint, append(clientOptions, opts.clientOptions...)...)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := handshake(netConn, u, opts)
+	if err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func handshake(netConn net.Conn, u *url.URL, opts *Options) (*Conn, error) {
+	_ = netConn.SetDeadline(time.Now().Add(opts.handshakeTimeout))
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	for name, values := range opts.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(opts.subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(opts.subprotocols, ", "))
+	}
+	if err := req.Write(netConn); err != nil {
+		return nil, errors.Wrapf(err, "无法发送握手请求 [url=%v]", u)
+	}
+
+	reader := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法读取握手响应 [url=%v]", u)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(resp.Header, "Upgrade", "websocket") ||
+		!headerContains(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.Wrapf(ErrBadHandshake, "[url=%v, status=%v]", u, resp.Status)
+	}
+
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !contains(opts.subprotocols, subprotocol) {
+		return nil, errors.Wrapf(ErrBadHandshake, "服务端选择了未请求的子协议 [subprotocol=%v]", subprotocol)
+	}
+
+	_ = netConn.SetDeadline(time.Time{})
+	conn := newConn(netConn, reader, true, opts)
+	conn.subprotocol = subprotocol
+	return conn, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
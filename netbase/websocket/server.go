@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"github.com/lngwu11/toolgo/netbase/server"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+// Upgrade 把HTTP请求升级为WebSocket连接,失败时已向客户端返回错误响应
+// 可以在gin的处理函数中使用: websocket.Upgrade(c.Writer, c.Request)
+func Upgrade(w http.ResponseWriter, r *http.Request, options ...Option) (*Conn, error) {
+	opts := loadOptions(options...)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil, errors.Wrapf(ErrBadHandshake, "请求方法错误 [method=%v]", r.Method)
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, errors.Wrapf(ErrBadHandshake, "不是WebSocket升级请求")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+		return nil, errors.Wrapf(ErrBadHandshake, "不支持的版本 [version=%v]", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, errors.Wrapf(ErrBadHandshake, "缺少Sec-WebSocket-Key")
+	}
+	if !opts.checkOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, errors.Wrapf(ErrBadHandshake, "不允许的Origin [origin=%v]", r.Header.Get("Origin"))
+	}
+	subprotocol := selectSubprotocol(r, opts.subprotocols)
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, errors.Errorf("ResponseWriter不支持Hijack")
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法接管连接")
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	resp += "\r\n"
+
+	_ = netConn.SetWriteDeadline(time.Now().Add(opts.handshakeTimeout))
+	if _, err = netConn.Write([]byte(resp)); err != nil {
+		_ = netConn.Close()
+		return nil, errors.Wrapf(err, "无法发送握手响应 [remote=%v]", netConn.RemoteAddr())
+	}
+	// 清除http.Server设置的截止时间
+	_ = netConn.SetDeadline(time.Time{})
+
+	conn := newConn(netConn, rw.Reader, false, opts)
+	conn.subprotocol = subprotocol
+	return conn, nil
+}
+
+// Handler 返回升级请求并调用handler的http.HandlerFunc,handler返回后关闭连接
+// 在gin中使用: router.GET("/ws", gin.WrapF(websocket.Handler(handler)))
+func Handler(handler server.Handler, options ...Option) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, options...)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(r.Context(), conn)
+	}
+}
+
+func selectSubprotocol(r *http.Request, supported []string) string {
+	for _, requested := range headerTokens(r.Header, "Sec-WebSocket-Protocol") {
+		for _, s := range supported {
+			if requested == s {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,153 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"github.com/lngwu11/toolgo/netbase/client"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// 默认握手超时时间
+	defaultHandshakeTimeout = 10 * time.Second
+	// 默认接收帧的最大长度
+	defaultMaxFrameSize = 1024 * 1024
+	// 计算Sec-WebSocket-Accept使用的GUID
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	// ErrBadHandshake 握手失败
+	ErrBadHandshake = errors.New("websocket bad handshake")
+	// ErrProtocol 对端违反协议
+	ErrProtocol = errors.New("websocket protocol error")
+	// ErrFrameTooLarge 收到的帧超过最大长度
+	ErrFrameTooLarge = errors.New("websocket frame too large")
+	// ErrWriteClosed 已发送关闭帧,不能继续写
+	ErrWriteClosed = errors.New("websocket write closed")
+)
+
+type Option func(opts *Options)
+
+type Options struct {
+	// 握手超时时间
+	handshakeTimeout time.Duration
+	// 接收帧的最大长度
+	maxFrameSize int64
+	// 支持的子协议,按优先级排列
+	subprotocols []string
+	// 服务端校验Origin
+	checkOrigin func(r *http.Request) bool
+	// 客户端握手请求附加的头部
+	header http.Header
+	// 客户端拨号配置
+	clientOptions []client.Option
+}
+
+// WithHandshakeTimeout 设置握手超时时间,默认10s
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.handshakeTimeout = timeout
+	}
+}
+
+// WithMaxFrameSize 设置接收帧的最大长度,默认1MB,超出时关闭连接
+func WithMaxFrameSize(size int64) Option {
+	return func(opts *Options) {
+		opts.maxFrameSize = size
+	}
+}
+
+// WithSubprotocols 设置支持的子协议
+// 服务端选择客户端请求中第一个受支持的子协议,客户端在握手请求中携带并校验服务端的选择
+func WithSubprotocols(subprotocols ...string) Option {
+	return func(opts *Options) {
+		opts.subprotocols = subprotocols
+	}
+}
+
+// WithCheckOrigin 设置服务端校验Origin的函数,默认只允许没有Origin或与Host相同的请求
+func WithCheckOrigin(checkOrigin func(r *http.Request) bool) Option {
+	return func(opts *Options) {
+		opts.checkOrigin = checkOrigin
+	}
+}
+
+// WithHeader 设置客户端握手请求附加的头部,如Authorization、Origin
+func WithHeader(header http.Header) Option {
+	return func(opts *Options) {
+		opts.header = header
+	}
+}
+
+// WithClientOptions 设置客户端拨号配置,wss地址默认使用空的tls配置
+func WithClientOptions(options ...client.Option) Option {
+	return func(opts *Options) {
+		opts.clientOptions = append(opts.clientOptions, options...)
+	}
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.handshakeTimeout <= 0 {
+		opts.handshakeTimeout = defaultHandshakeTimeout
+	}
+	if opts.maxFrameSize <= 0 {
+		opts.maxFrameSize = defaultMaxFrameSize
+	}
+	if opts.checkOrigin == nil {
+		opts.checkOrigin = sameOrigin
+	}
+	return opts
+}
+
+// sameOrigin 没有Origin或Origin的主机与Host相同
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains 判断逗号分隔的头部值中是否包含token,忽略大小写
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func headerTokens(header http.Header, name string) []string {
+	var tokens []string
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				tokens = append(tokens, v)
+			}
+		}
+	}
+	return tokens
+}
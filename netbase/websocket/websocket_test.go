@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lngwu11/toolgo/netbase"
+	"github.com/lngwu11/toolgo/netbase/codec"
+	"github.com/lngwu11/toolgo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func wsURL(server *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + path
+}
+
+func TestGinCodec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// 服务端按长度字段帧回显,直到客户端半关闭
+	router.GET("/ws", gin.WrapF(Handler(func(ctx context.Context, conn utils.ConnReadWriteCloser) {
+		c, err := codec.NewLengthFieldCodec(netbase.NewBufferConnection(conn, 0))
+		if err != nil {
+			return
+		}
+		for {
+			frame, err := c.ReadFrame()
+			if err != nil {
+				break
+			}
+			_ = c.WriteFrame(append([]byte("echo:"), frame...))
+			_ = c.Flush()
+		}
+		_ = conn.CloseWrite()
+	})))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, err := Dial(wsURL(server, "/ws"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	c, err := codec.NewLengthFieldCodec(netbase.NewBufferConnection(conn, 0))
+	require.NoError(t, err)
+	large := strings.Repeat("x", 200*1024)
+	for _, msg := range []string{"hello", "", large} {
+		require.NoError(t, c.WriteFrame([]byte(msg)))
+		require.NoError(t, c.Flush())
+		frame, err := c.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, "echo:"+msg, string(frame))
+	}
+
+	// 半关闭后对端读到EOF并关闭写方向
+	require.NoError(t, conn.CloseWrite())
+	_, err = conn.Write([]byte("x"))
+	require.ErrorIs(t, err, ErrWriteClosed)
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Empty(t, rest)
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", Handler(func(ctx context.Context, conn utils.ConnReadWriteCloser) {
+		_, _ = conn.Write([]byte(conn.(*Conn).Subprotocol()))
+	}, WithSubprotocols("v2", "v1")))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// 普通的HTTP请求
+	resp, err := http.Get(server.URL + "/ws")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 跨域请求默认被拒绝
+	_, err = Dial(wsURL(server, "/ws"), WithHeader(http.Header{"Origin": {"http://evil.example"}}))
+	require.ErrorIs(t, err, ErrBadHandshake)
+
+	// 子协议协商
+	conn, err := Dial(wsURL(server, "/ws"), WithSubprotocols("v1", "v2"))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "v1", conn.Subprotocol())
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(got))
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	serverErr := make(chan error, 1)
+	server := httptest.NewServer(Handler(func(ctx context.Context, conn utils.ConnReadWriteCloser) {
+		_, err := io.ReadAll(conn)
+		serverErr <- err
+	}, WithMaxFrameSize(1024)))
+	defer server.Close()
+
+	conn, err := Dial(wsURL(server, "/"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(make([]byte, 1024))
+	require.NoError(t, err)
+	_, err = conn.Write(make([]byte, 1025))
+	require.NoError(t, err)
+	require.ErrorIs(t, <-serverErr, ErrFrameTooLarge)
+
+	// 收到对端的关闭帧
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+}
+
+func TestCloseEchoesPeerCode(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	conn := newConn(b, bufio.NewReader(b), false, loadOptions())
+	defer conn.Close()
+
+	// 客户端发送带掩码的关闭帧,状态码1001
+	go func() {
+		_, _ = a.Write([]byte{finBit | opClose, maskBit | 2, 0, 0, 0, 0, 0x03, 0xe9})
+	}()
+	_, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- conn.CloseWrite() }()
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(a, reply)
+	require.NoError(t, err)
+	require.Equal(t, []byte{finBit | opClose, 2}, reply[:2])
+	require.Equal(t, uint16(1001), binary.BigEndian.Uint16(reply[2:]))
+	require.NoError(t, <-done)
+}
+
+func TestCloseDoesNotHang(t *testing.T) {
+	// 对端不读取,关闭帧无法写入
+	a, b := net.Pipe()
+	defer a.Close()
+	conn := newConn(b, bufio.NewReader(b), false, loadOptions())
+
+	start := time.Now()
+	require.NoError(t, conn.Close())
+	require.Less(t, time.Since(start), 3*closeWriteTimeout)
+}
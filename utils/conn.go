@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// ErrHalfCloseUnsupported 连接不支持半关闭
+var ErrHalfCloseUnsupported = errors.New("connection does not support half-close")
+
+type closeReader interface {
+	CloseRead() error
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// ToConnReadWriteCloser 将net.Conn转换为ConnReadWriteCloser
+// *tls.Conn没有CloseRead,由底层连接实现;其他不支持半关闭的连接,
+// CloseRead/CloseWrite返回ErrHalfCloseUnsupported
+func ToConnReadWriteCloser(conn net.Conn) ConnReadWriteCloser {
+	switch c := conn.(type) {
+	case ConnReadWriteCloser:
+		return c
+	case *tls.Conn:
+		return &tlsConn{Conn: c}
+	default:
+		return &halfCloseConn{Conn: conn}
+	}
+}
+
+type tlsConn struct {
+	*tls.Conn
+}
+
+// CloseRead 关闭底层连接的读方向
+func (c *tlsConn) CloseRead() error {
+	if cr, ok := c.NetConn().(closeReader); ok {
+		return cr.CloseRead()
+	}
+	return ErrHalfCloseUnsupported
+}
+
+type halfCloseConn struct {
+	net.Conn
+}
+
+func (c *halfCloseConn) CloseRead() error {
+	if cr, ok := c.Conn.(closeReader); ok {
+		return cr.CloseRead()
+	}
+	return ErrHalfCloseUnsupported
+}
+
+func (c *halfCloseConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return ErrHalfCloseUnsupported
+}